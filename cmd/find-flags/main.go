@@ -0,0 +1,222 @@
+// Command find-flags searches a local git range for references to LaunchDarkly feature flags.
+//
+// It runs the same scan as the GitHub action, without creating PR comments or flag links:
+//
+//	find-flags --base origin/main --head HEAD --project default --env production
+//
+// Flags are read from --flags-file instead of the API to run offline.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"text/template"
+
+	ghc "github.com/launchdarkly/find-code-references-in-pull-request/comments"
+	lcr "github.com/launchdarkly/find-code-references-in-pull-request/config"
+	"github.com/launchdarkly/find-code-references-in-pull-request/internal/git"
+	gha "github.com/launchdarkly/find-code-references-in-pull-request/internal/github_actions"
+	"github.com/launchdarkly/find-code-references-in-pull-request/internal/httpclient"
+	ldclient "github.com/launchdarkly/find-code-references-in-pull-request/internal/ldclient"
+	"github.com/launchdarkly/find-code-references-in-pull-request/internal/policies"
+	"github.com/launchdarkly/find-code-references-in-pull-request/internal/report"
+	"github.com/launchdarkly/find-code-references-in-pull-request/internal/scan"
+	"github.com/launchdarkly/find-code-references-in-pull-request/internal/version"
+)
+
+type cliOptions struct {
+	base    string
+	head    string
+	format  string
+	verbose bool
+	// template for markdown output
+	commentTemplate string
+}
+
+func main() {
+	config, cli, err := parseFlags(os.Args[1:])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	if cli.verbose {
+		gha.SetLogOutput(os.Stderr)
+	} else {
+		gha.SetLogOutput(io.Discard)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	code, err := run(ctx, config, cli, os.Stdout)
+	stop()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %s\n", err)
+		os.Exit(1)
+	}
+	os.Exit(code)
+}
+
+func parseFlags(args []string) (*lcr.Config, cliOptions, error) {
+	config := lcr.Config{
+		IncludeArchivedFlags: true,
+		CheckExtinctions:     true,
+		CheckIntroductions:   true,
+		FlagsPageSize:        100,
+		MaxFlagPages:         100,
+		HTTPClient:           httpclient.NewClient(httpclient.DefaultOptions()),
+	}
+	var cli cliOptions
+
+	fs := flag.NewFlagSet("find-flags", flag.ContinueOnError)
+	fs.StringVar(&cli.base, "base", "", "commit or ref to compare against (required)")
+	fs.StringVar(&cli.head, "head", "HEAD", "commit or ref to scan")
+	fs.StringVar(&cli.format, "format", "text", "output format: text, markdown, json or sarif")
+	fs.StringVar(&cli.commentTemplate, "comment-template", "", "template file for markdown output")
+	fs.BoolVar(&cli.verbose, "verbose", false, "log progress to stderr")
+	fs.StringVar(&config.LdProject, "project", "default", "comma-separated LaunchDarkly project keys")
+	fs.StringVar(&config.LdEnvironment, "env", "production", "comma-separated LaunchDarkly environment keys")
+	fs.StringVar(&config.LdInstance, "base-uri", "https://app.launchdarkly.com", "base URI for the LaunchDarkly server")
+	fs.StringVar(&config.ApiToken, "access-token", os.Getenv("LD_ACCESS_TOKEN"), "LaunchDarkly access token (defaults to $LD_ACCESS_TOKEN)")
+	fs.StringVar(&config.FlagsFile, "flags-file", "", "JSON flag export or snapshot directory to read flags from instead of the API")
+	fs.StringVar(&config.Workspace, "dir", ".", "path to the git repository")
+	fs.IntVar(&config.MaxFlags, "max-flags", 5, "maximum number of flags to show in markdown output, 0 for no limit")
+	fs.IntVar(&config.Concurrency, "concurrency", 0, "number of files to scan at once, 0 for one per CPU")
+	fs.BoolVar(&config.IncludeArchivedFlags, "include-archived-flags", true, "scan for archived flags")
+	fs.BoolVar(&config.CheckExtinctions, "check-extinctions", true, "check if removed flags still exist in the repository")
+	fs.BoolVar(&config.CheckIntroductions, "check-introductions", true, "check if added flags were already referenced in the repository")
+	fs.BoolVar(&config.FailOnArchivedAdded, "fail-on-archived-added", false, "exit with status 1 when references to archived flags are added")
+	fs.BoolVar(&config.FailOnDeprecatedAdded, "fail-on-deprecated-added", false, "exit with status 1 when references to deprecated flags are added")
+	fs.BoolVar(&config.DetectUnknownFlags, "detect-unknown-flags", false, "report keys evaluated by SDK calls that don't match a flag")
+	fs.BoolVar(&config.FailOnUnknownFlags, "fail-on-unknown-flag-like-strings", false, "exit with status 1 when keys that don't match a flag are evaluated")
+
+	if err := fs.Parse(args); err != nil {
+		return nil, cli, err
+	}
+
+	switch {
+	case cli.base == "":
+		return nil, cli, fmt.Errorf("--base is required")
+	case strings.HasPrefix(cli.base, "-"):
+		return nil, cli, fmt.Errorf("invalid --base %q", cli.base)
+	case strings.HasPrefix(cli.head, "-"):
+		return nil, cli, fmt.Errorf("invalid --head %q", cli.head)
+	case config.ApiToken == "" && config.FlagsFile == "":
+		return nil, cli, fmt.Errorf("--access-token, LD_ACCESS_TOKEN or --flags-file is required")
+	}
+
+	switch cli.format {
+	case "text", "markdown", report.FormatJSON, report.FormatSARIF:
+	default:
+		return nil, cli, fmt.Errorf("unsupported --format %q", cli.format)
+	}
+
+	config.LdProjects = lcr.SplitList(config.LdProject)
+	if len(config.LdProjects) == 0 {
+		return nil, cli, fmt.Errorf("--project is required")
+	}
+	config.LdProject = config.LdProjects[0]
+
+	config.LdEnvironments = lcr.SplitList(config.LdEnvironment)
+	if len(config.LdEnvironments) == 0 {
+		return nil, cli, fmt.Errorf("--env is required")
+	}
+	config.LdEnvironment = config.LdEnvironments[0]
+
+	dir, err := filepath.Abs(config.Workspace)
+	if err != nil {
+		return nil, cli, err
+	}
+	config.Workspace = dir
+	config.DetectUnknownFlags = config.DetectUnknownFlags || config.FailOnUnknownFlags
+
+	return &config, cli, nil
+}
+
+// Scan the range and write results to w. Returns the exit code.
+func run(ctx context.Context, config *lcr.Config, cli cliOptions, w io.Writer) (int, error) {
+	var commentTemplate *template.Template
+	if cli.commentTemplate != "" {
+		var err error
+		if commentTemplate, err = ghc.LoadTemplate(cli.commentTemplate); err != nil {
+			return 1, err
+		}
+	}
+
+	opts, err := scan.GetOptions(config)
+	if err != nil {
+		return 1, err
+	}
+
+	// searched for flags that were already referenced
+	config.BaseSha = cli.base
+	projects := scan.Projects(config, opts)
+	for i := range projects {
+		if projects[i].Flags, err = ldclient.GetAllFlags(ctx, projects[i].Config); err != nil {
+			return 1, err
+		}
+	}
+	if !scan.AnyFlags(projects) {
+		fmt.Fprintf(os.Stderr, "No flags found in project %s\n", strings.Join(scan.ProjectKeys(projects), ", "))
+		return 0, nil
+	}
+
+	rawDiff, err := git.Diff(config.Workspace, cli.base+"..."+cli.head)
+	if err != nil {
+		return 1, err
+	}
+	err = scan.ScanProjects(opts, projects, rawDiff)
+	if closeErr := rawDiff.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return 1, err
+	}
+
+	switch cli.format {
+	case "markdown":
+		if commentTemplate == nil {
+			fmt.Fprintln(w, ghc.BuildProjectsFlagSummary(ghc.ProcessProjects(projects)))
+			break
+		}
+		metadata := ghc.Metadata{
+			HeadSha:      cli.head,
+			LdInstance:   config.LdInstance,
+			Projects:     config.LdProjects,
+			Environments: config.LdEnvironments,
+			Version:      version.Version,
+		}
+		if sha, err := git.RevParse(config.Workspace, cli.head); err == nil {
+			metadata.HeadSha = sha
+		}
+		body, err := ghc.RenderTemplate(commentTemplate, ghc.NewTemplateData(projects, metadata))
+		if err != nil {
+			return 1, err
+		}
+		fmt.Fprintln(w, body)
+	case report.FormatJSON, report.FormatSARIF:
+		if err := report.Write(w, cli.format, report.Build(config, projects)); err != nil {
+			return 1, err
+		}
+	default:
+		writeText(w, projects)
+	}
+
+	var violations []policies.Violation
+	for _, p := range projects {
+		violations = append(violations, policies.Evaluate(p.Config, p.References, p.Flags)...)
+	}
+	for _, v := range violations {
+		fmt.Fprintf(os.Stderr, "error: %s\n", v)
+	}
+	if len(violations) > 0 {
+		return 1, nil
+	}
+	return 0, nil
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	refs "github.com/launchdarkly/find-code-references-in-pull-request/internal/references"
+	"github.com/launchdarkly/find-code-references-in-pull-request/internal/scan"
+)
+
+// Write a plain text summary of flag references, listing the location of each reference.
+// Projects are only named when more than one is scanned.
+func writeText(w io.Writer, projects []scan.Project) {
+	if !scan.AnyFound(projects) {
+		fmt.Fprintln(w, "No flag references found")
+		return
+	}
+
+	for _, p := range projects {
+		if len(projects) > 1 {
+			if !p.References.AnyFound() {
+				continue
+			}
+			fmt.Fprintf(w, "Project %s:\n", p.Key)
+		}
+		writeProject(w, p.References)
+	}
+}
+
+func writeProject(w io.Writer, flagsRef refs.ReferenceSummary) {
+	if keys := flagsRef.AddedKeys(); len(keys) > 0 {
+		fmt.Fprintf(w, "Flags added or modified (%d):\n", len(keys))
+		for _, key := range keys {
+			writeFlag(w, flagsRef, key, flagsRef.FlagsAdded[key])
+		}
+	}
+
+	if keys := flagsRef.RemovedKeys(); len(keys) > 0 {
+		fmt.Fprintf(w, "Flags removed (%d):\n", len(keys))
+		for _, key := range keys {
+			writeFlag(w, flagsRef, key, flagsRef.FlagsRemoved[key])
+		}
+	}
+
+	if len(flagsRef.UnknownFlags) > 0 {
+		fmt.Fprintf(w, "Flags not found in LaunchDarkly (%d):\n", len(flagsRef.UnknownFlags))
+		for _, unknown := range flagsRef.UnknownFlags {
+			line := "  " + unknown.Key
+			if len(unknown.Suggestions) > 0 {
+				line += fmt.Sprintf(" (did you mean: %s)", strings.Join(unknown.Suggestions, ", "))
+			}
+			fmt.Fprintln(w, line)
+			for _, location := range unknown.Locations {
+				fmt.Fprintf(w, "    %s %s\n", location.Operation, location)
+			}
+		}
+	}
+}
+
+func writeFlag(w io.Writer, flagsRef refs.ReferenceSummary, key string, aliases []string) {
+	line := "  " + key
+	if len(aliases) > 0 {
+		line += fmt.Sprintf(" (aliases: %s)", strings.Join(aliases, ", "))
+	}
+	switch {
+	case flagsRef.IsExtinct(key):
+		line += " [all references removed]"
+	case flagsRef.IsIntroduced(key):
+		line += " [introduced]"
+	case flagsRef.IsMoved(key):
+		line += " [moved]"
+	}
+	fmt.Fprintln(w, line)
+
+	for _, location := range flagsRef.Locations(key) {
+		if location.OrigPath != "" {
+			fmt.Fprintf(w, "    %s %s (renamed from %s)\n", location.Operation, location, location.OrigPath)
+			continue
+		}
+		fmt.Fprintf(w, "    %s %s\n", location.Operation, location)
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	refs "github.com/launchdarkly/find-code-references-in-pull-request/internal/references"
+	"github.com/launchdarkly/find-code-references-in-pull-request/internal/scan"
+	"github.com/launchdarkly/find-code-references-in-pull-request/internal/utils/diff_util"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteText(t *testing.T) {
+	flagsRef := refs.ReferenceSummary{
+		FlagsAdded:   refs.FlagAliasMap{"example-flag": {"exampleFlag"}},
+		FlagsRemoved: refs.FlagAliasMap{"old-flag": {}},
+		ExtinctFlags: map[string]struct{}{"old-flag": {}},
+		References: refs.FlagReferenceMap{
+			"example-flag": {{Path: "main.go", Line: 3, Operation: diff_util.OperationAdd}},
+			"old-flag":     {{Path: "app.go", Line: 10, Operation: diff_util.OperationDelete}},
+		},
+	}
+
+	var out bytes.Buffer
+	writeText(&out, []scan.Project{{Key: "default", References: flagsRef}})
+
+	expected := `Flags added or modified (1):
+  example-flag (aliases: exampleFlag)
+    + main.go:3
+Flags removed (1):
+  old-flag [all references removed]
+    - app.go:10
+`
+	assert.Equal(t, expected, out.String())
+}
+
+func TestWriteText_unknownFlags(t *testing.T) {
+	flagsRef := refs.ReferenceSummary{
+		UnknownFlags: []refs.UnknownFlag{{
+			Key:         "exmaple-flag",
+			Suggestions: []string{"example-flag"},
+			Locations:   []refs.ReferenceLocation{{Path: "main.go", Line: 3, Operation: diff_util.OperationAdd}},
+		}},
+	}
+
+	var out bytes.Buffer
+	writeText(&out, []scan.Project{{Key: "default", References: flagsRef}})
+
+	expected := `Flags not found in LaunchDarkly (1):
+  exmaple-flag (did you mean: example-flag)
+    + main.go:3
+`
+	assert.Equal(t, expected, out.String())
+}
+
+func TestWriteText_noFlags(t *testing.T) {
+	var out bytes.Buffer
+	writeText(&out, []scan.Project{{Key: "default"}})
+
+	assert.Equal(t, "No flag references found\n", out.String())
+}
+
+func TestParseFlags(t *testing.T) {
+	config, cli, err := parseFlags([]string{"--base", "origin/main", "--access-token", "api-123", "--env", "staging"})
+	assert.NoError(t, err)
+	assert.Equal(t, "origin/main", cli.base)
+	assert.Equal(t, "HEAD", cli.head)
+	assert.Equal(t, "staging", config.LdEnvironment)
+	assert.Equal(t, "default", config.LdProject)
+	assert.Equal(t, []string{"default"}, config.LdProjects)
+	assert.True(t, config.CheckExtinctions)
+
+	_, _, err = parseFlags([]string{"--access-token", "api-123"})
+	assert.EqualError(t, err, "--base is required")
+
+	_, _, err = parseFlags([]string{"--base", "--output=flags.txt", "--access-token", "api-123"})
+	assert.EqualError(t, err, `invalid --base "--output=flags.txt"`)
+}
@@ -0,0 +1,109 @@
+// Command snapshot-flags writes a project's flags from the LaunchDarkly API to a snapshot directory,
+// which can be used as the flags-file input to scan pull requests offline:
+//
+//	snapshot-flags --project default --env production --out .launchdarkly/flags
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+
+	lcr "github.com/launchdarkly/find-code-references-in-pull-request/config"
+	gha "github.com/launchdarkly/find-code-references-in-pull-request/internal/github_actions"
+	"github.com/launchdarkly/find-code-references-in-pull-request/internal/httpclient"
+	ldclient "github.com/launchdarkly/find-code-references-in-pull-request/internal/ldclient"
+)
+
+type cliOptions struct {
+	out     string
+	verbose bool
+}
+
+func main() {
+	config, cli, err := parseFlags(os.Args[1:])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	if cli.verbose {
+		gha.SetLogOutput(os.Stderr)
+	} else {
+		gha.SetLogOutput(io.Discard)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	err = run(ctx, config, cli, os.Stdout)
+	stop()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+func parseFlags(args []string) (*lcr.Config, cliOptions, error) {
+	config := lcr.Config{
+		IncludeArchivedFlags: true,
+		FlagsPageSize:        100,
+		MaxFlagPages:         100,
+		HTTPClient:           httpclient.NewClient(httpclient.DefaultOptions()),
+	}
+	var cli cliOptions
+
+	fs := flag.NewFlagSet("snapshot-flags", flag.ContinueOnError)
+	fs.StringVar(&cli.out, "out", "", "directory to write the snapshot to (required)")
+	fs.BoolVar(&cli.verbose, "verbose", false, "log progress to stderr")
+	fs.StringVar(&config.LdProject, "project", "default", "comma-separated LaunchDarkly project keys")
+	fs.StringVar(&config.LdEnvironment, "env", "production", "comma-separated LaunchDarkly environment keys")
+	fs.StringVar(&config.LdInstance, "base-uri", "https://app.launchdarkly.com", "base URI for the LaunchDarkly server")
+	fs.StringVar(&config.ApiToken, "access-token", os.Getenv("LD_ACCESS_TOKEN"), "LaunchDarkly access token (defaults to $LD_ACCESS_TOKEN)")
+	fs.BoolVar(&config.IncludeArchivedFlags, "include-archived-flags", true, "include archived flags in the snapshot")
+
+	if err := fs.Parse(args); err != nil {
+		return nil, cli, err
+	}
+
+	switch {
+	case cli.out == "":
+		return nil, cli, fmt.Errorf("--out is required")
+	case config.ApiToken == "":
+		return nil, cli, fmt.Errorf("--access-token or LD_ACCESS_TOKEN is required")
+	}
+
+	config.LdProjects = lcr.SplitList(config.LdProject)
+	if len(config.LdProjects) == 0 {
+		return nil, cli, fmt.Errorf("--project is required")
+	}
+	config.LdProject = config.LdProjects[0]
+
+	config.LdEnvironments = lcr.SplitList(config.LdEnvironment)
+	if len(config.LdEnvironments) == 0 {
+		return nil, cli, fmt.Errorf("--env is required")
+	}
+	config.LdEnvironment = config.LdEnvironments[0]
+
+	return &config, cli, nil
+}
+
+// Fetch the flags for each project and write them to the snapshot directory,
+// listing the files written to w
+func run(ctx context.Context, config *lcr.Config, cli cliOptions, w io.Writer) error {
+	for _, key := range config.LdProjects {
+		projectConfig := config.ForProject(key)
+		flags, err := ldclient.GetAllFlags(ctx, projectConfig)
+		if err != nil {
+			return fmt.Errorf("fetching flags for project %s: %w", key, err)
+		}
+		path, err := ldclient.WriteSnapshot(cli.out, key, flags)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "Wrote %d flags for project %s to %s\n", len(flags), key, path)
+	}
+	return nil
+}
@@ -8,6 +8,7 @@ import (
 	"html"
 	"html/template"
 	"reflect"
+	"regexp"
 	"sort"
 	"strings"
 	"time"
@@ -20,9 +21,12 @@ import (
 	lcr "github.com/launchdarkly/find-code-references-in-pull-request/config"
 	gha "github.com/launchdarkly/find-code-references-in-pull-request/internal/github_actions"
 	refs "github.com/launchdarkly/find-code-references-in-pull-request/internal/references"
+	"github.com/launchdarkly/find-code-references-in-pull-request/internal/scan"
+	"github.com/launchdarkly/find-code-references-in-pull-request/internal/utils"
 )
 
 type Comment struct {
+	ProjectKey         string
 	FlagKey            string
 	FlagName           string
 	Archived           bool
@@ -37,6 +41,37 @@ type Comment struct {
 	Primary            ldapi.FeatureFlagConfig
 	LDInstance         string
 	ExtinctionsEnabled bool
+	Environments       []EnvironmentState // only set when more than one environment is configured
+	Locations          []refs.ReferenceLocation
+	RepoURL            string // web URL of the repository, empty if references can't be linked to
+	HeadSha            string // commit the references were added in, empty if references can't be linked to
+	ReferencesCell     string // links to each added reference, grouped by file
+	Row                string // default table row for the flag
+}
+
+// Title of the PR comment, also used to find comments posted before they were marked
+const commentTitle = "LaunchDarkly flag references"
+
+// Marker identifying the PR comment posted for an instance id
+const commentMarker = "<!-- launchdarkly-flag-references:%s -->"
+
+var commentMarkerRegex = regexp.MustCompile(`<!-- launchdarkly-flag-references:(\S+) -->`)
+
+// Instance id of comments posted before comments were marked
+const DefaultInstanceID = "default"
+
+// Append the marker identifying the PR comment for the instance id
+func WithCommentMarker(body, instanceID string) string {
+	return body + "\n" + fmt.Sprintf(commentMarker, instanceID)
+}
+
+// Whether the body is the PR comment for the instance id. Unmarked comments with the
+// comment title belong to the default instance.
+func IsFlagComment(body, instanceID string) bool {
+	if match := commentMarkerRegex.FindStringSubmatch(body); match != nil {
+		return match[1] == instanceID
+	}
+	return instanceID == DefaultInstanceID && strings.Contains(body, commentTitle)
 }
 
 func isNil(a interface{}) bool {
@@ -46,7 +81,12 @@ func isNil(a interface{}) bool {
 
 // Test go template rendering here https://gotemplate.io/
 func githubFlagComment(flag ldapi.FeatureFlag, aliases []string, added, extinct bool, config *lcr.Config) (string, error) {
+	return renderRow(newComment(flag, aliases, added, extinct, config))
+}
+
+func newComment(flag ldapi.FeatureFlag, aliases []string, added, extinct bool, config *lcr.Config) Comment {
 	commentTemplate := Comment{
+		ProjectKey:         config.LdProject,
 		FlagKey:            flag.Key,
 		FlagName:           flag.Name,
 		Archived:           flag.Archived,
@@ -65,13 +105,26 @@ func githubFlagComment(flag ldapi.FeatureFlag, aliases []string, added, extinct
 	if flag.DeprecatedDate != nil {
 		commentTemplate.DeprecatedAt = time.UnixMilli(*flag.DeprecatedDate)
 	}
+	if len(config.LdEnvironments) > 1 {
+		commentTemplate.Environments = environmentStates(flag, config.LdEnvironments, config.LdInstance)
+	}
+	if referenceLinks(config) {
+		commentTemplate.RepoURL = config.RepoURL
+		commentTemplate.HeadSha = config.HeadSha
+	}
+	return commentTemplate
+}
 
+// Render the table row for a flag
+func renderRow(commentTemplate Comment) (string, error) {
 	// All whitespace for template is required to be there or it will not render properly nested.
 	tmplSetup := `| [{{.FlagName}}]({{.LDInstance}}{{.Primary.Site.Href}}) | ` +
 		"`" + `{{.FlagKey}}` + "` |" +
 		`{{- if ne (len .Aliases) 0}}` +
 		`{{range $i, $e := .Aliases }}` + `{{if $i}},{{end}}` + " `" + `{{$e}}` + "`" + `{{end}}` +
-		`{{- end}} | ` + infoCellTemplate() + ` |`
+		`{{- end}} | ` + infoCellTemplate() + ` |` +
+		`{{- if and .RepoURL .HeadSha}} {{.ReferencesCell}} |{{- end}}` +
+		`{{- range .Environments}}` + environmentCellTemplate() + `{{end}}`
 
 	tmpl := template.Must(template.New("comment").Funcs(template.FuncMap{"trim": strings.TrimSpace, "isNil": isNil}).Funcs(sprig.FuncMap()).Parse(tmplSetup))
 
@@ -105,39 +158,70 @@ func GithubNoFlagComment() *github.IssueComment {
 }
 
 type FlagComments struct {
-	CommentsAdded   []string
-	CommentsRemoved []string
+	CommentsIntroduced []string
+	CommentsAdded      []string // flags with references added that were neither introduced nor moved
+	CommentsMoved      []string
+	CommentsRemoved    []string
+	CommentsUnknown    []string // keys evaluated in the diff that don't match a flag
+	Environments       []string // environment columns of the flag table
+	ReferenceLinks     bool     // whether the flag table has a column linking to references
+	Provider           string   // code host the comment is posted to, which decides where left out rows are found
+	RunURL             string   // web URL of the workflow run, linked to when rows are left out of the comment
 }
 
-func BuildFlagComment(buildComment FlagComments, flagsRef refs.ReferenceSummary, existingComment *github.IssueComment) string {
-	tableHeader := "| Name | Key | Aliases found | Info |\n| --- | --- | --- | --- |"
+// Flag comments for a single project
+type ProjectFlagComments struct {
+	ProjectKey string
+	FlagComments
+	References refs.ReferenceSummary
+}
 
-	var commentStr []string
-	commentStr = append(commentStr, "## LaunchDarkly flag references")
+func BuildFlagComment(buildComment FlagComments, flagsRef refs.ReferenceSummary, existingComment string) string {
+	render := func(layout commentLayout) []string {
+		return buildFlagTables(buildComment, flagsRef, layout)
+	}
+	commentStr, allFlagKeys := fitComment(render, uniqueFlagKeys(flagsRef.FlagsAdded, flagsRef.FlagsRemoved), buildComment.Provider, buildComment.RunURL)
+	return withMarkers(commentStr, allFlagKeys, existingComment)
+}
 
-	numFlagsAdded := len(flagsRef.FlagsAdded)
-	if numFlagsAdded > 0 {
-		commentStr = append(commentStr, fmt.Sprintf("### :mag: %s added or modified\n", pluralize("flag", numFlagsAdded)))
-		commentStr = append(commentStr, tableHeader)
-		commentStr = append(commentStr, buildComment.CommentsAdded...)
-		commentStr = append(commentStr, "\n")
+// Build the PR comment with a section for each project that has flag references.
+// A single project is rendered the same as BuildFlagComment.
+func BuildProjectsFlagComment(projects []ProjectFlagComments, existingComment string) string {
+	if len(projects) == 1 {
+		return BuildFlagComment(projects[0].FlagComments, projects[0].References, existingComment)
 	}
 
-	numFlagsRemoved := len(flagsRef.FlagsRemoved)
-	if numFlagsRemoved > 0 {
-		commentStr = append(commentStr, fmt.Sprintf("### :x: %s removed\n", pluralize("flag", numFlagsRemoved)))
-		commentStr = append(commentStr, tableHeader)
-		commentStr = append(commentStr, buildComment.CommentsRemoved...)
+	allFlagKeys := make([]string, 0)
+	for _, p := range projects {
+		allFlagKeys = append(allFlagKeys, uniqueFlagKeys(p.References.FlagsAdded, p.References.FlagsRemoved)...)
+	}
+	render := func(layout commentLayout) []string {
+		return buildProjectTables(projects, layout)
+	}
+	commentStr, allFlagKeys := fitComment(render, utils.Dedupe(allFlagKeys), projects[0].Provider, projects[0].RunURL)
+	return withMarkers(commentStr, allFlagKeys, existingComment)
+}
+
+var flagsMarkerRegex = regexp.MustCompile(`<!-- flags:(\S*) -->`)
+
+// Keys of the flags referenced when the comment was posted, read from its markers. Returns false
+// if the comment has no flags marker, because no flags were referenced or there wasn't room for it.
+func FlagKeysFromComment(body string) ([]string, bool) {
+	match := flagsMarkerRegex.FindStringSubmatch(body)
+	if match == nil {
+		return nil, false
 	}
-	allFlagKeys := uniqueFlagKeys(flagsRef.FlagsAdded, flagsRef.FlagsRemoved)
+	return lcr.SplitList(match[1]), true
+}
+
+func withMarkers(commentStr []string, allFlagKeys []string, existingComment string) string {
 	if len(allFlagKeys) > 0 {
-		sort.Strings(allFlagKeys)
-		commentStr = append(commentStr, fmt.Sprintf(" <!-- flags:%s -->", strings.Join(allFlagKeys, ",")))
+		commentStr = append(commentStr, flagsMarker(allFlagKeys))
 	}
 	postedComments := strings.Join(commentStr, "\n")
 
 	hash := md5.Sum([]byte(postedComments))
-	if existingComment != nil && strings.Contains(*existingComment.Body, hex.EncodeToString(hash[:])) {
+	if strings.Contains(existingComment, hex.EncodeToString(hash[:])) {
 		gha.Log("comment already exists")
 		return ""
 	}
@@ -146,31 +230,339 @@ func BuildFlagComment(buildComment FlagComments, flagsRef refs.ReferenceSummary,
 	return postedComments
 }
 
+// Marker listing the keys of the flags referenced, sorting the keys
+func flagsMarker(allFlagKeys []string) string {
+	sort.Strings(allFlagKeys)
+	return fmt.Sprintf(" <!-- flags:%s -->", strings.Join(allFlagKeys, ","))
+}
+
+// Build the markdown summary of flag references, without the markers used to track the PR comment
+func BuildFlagSummary(buildComment FlagComments, flagsRef refs.ReferenceSummary) string {
+	if !flagsRef.AnyFound() {
+		return *GithubNoFlagComment().Body
+	}
+	return strings.Join(buildFlagTables(buildComment, flagsRef, defaultLayout), "\n")
+}
+
+// Build the markdown summary of flag references, grouped by project
+func BuildProjectsFlagSummary(projects []ProjectFlagComments) string {
+	if len(projects) == 1 {
+		return BuildFlagSummary(projects[0].FlagComments, projects[0].References)
+	}
+	if !anyFound(projects) {
+		return *GithubNoFlagComment().Body
+	}
+	return strings.Join(buildProjectTables(projects, defaultLayout), "\n")
+}
+
+func buildFlagTables(buildComment FlagComments, flagsRef refs.ReferenceSummary, layout commentLayout) []string {
+	commentStr := []string{"## LaunchDarkly flag references"}
+	return append(commentStr, renderSections(flagSections(buildComment, flagsRef), "###", layout)...)
+}
+
+func buildProjectTables(projects []ProjectFlagComments, layout commentLayout) []string {
+	commentStr := []string{"## LaunchDarkly flag references"}
+	for _, p := range projects {
+		if !p.References.AnyFound() {
+			continue
+		}
+		commentStr = append(commentStr, fmt.Sprintf("### Project `%s`", p.ProjectKey))
+		commentStr = append(commentStr, renderSections(flagSections(p.FlagComments, p.References), "####", layout)...)
+	}
+	return commentStr
+}
+
+// A table of flags in the comment
+type commentSection struct {
+	title  string // heading of the table
+	header string
+	rows   []string
+	spaced bool // followed by a blank line
+}
+
+// Sections of the comment, by change type
+func flagSections(buildComment FlagComments, flagsRef refs.ReferenceSummary) []commentSection {
+	tableHeader := tableHeader(buildComment.Environments, buildComment.ReferenceLinks)
+
+	var sections []commentSection
+
+	numFlagsIntroduced := len(flagsRef.IntroducedKeys())
+	if numFlagsIntroduced > 0 {
+		sections = append(sections, commentSection{
+			title:  fmt.Sprintf(":sparkles: %s introduced", utils.Pluralize("flag", numFlagsIntroduced)),
+			header: tableHeader,
+			rows:   buildComment.CommentsIntroduced,
+			spaced: true,
+		})
+	}
+
+	numFlagsModified := len(flagsRef.ModifiedKeys())
+	if numFlagsModified > 0 {
+		// without checking for introduced flags, new flags can't be told apart from modified ones
+		modified := "added or modified"
+		if flagsRef.IntroducedFlags != nil {
+			modified = "modified"
+		}
+		sections = append(sections, commentSection{
+			title:  fmt.Sprintf(":mag: %s %s", utils.Pluralize("flag", numFlagsModified), modified),
+			header: tableHeader,
+			rows:   buildComment.CommentsAdded,
+			spaced: true,
+		})
+	}
+
+	numFlagsMoved := len(flagsRef.MovedKeys())
+	if numFlagsMoved > 0 {
+		sections = append(sections, commentSection{
+			title:  fmt.Sprintf(":truck: %s moved", utils.Pluralize("flag", numFlagsMoved)),
+			header: tableHeader,
+			rows:   buildComment.CommentsMoved,
+			spaced: true,
+		})
+	}
+
+	numFlagsRemoved := len(flagsRef.FlagsRemoved)
+	numFlagsUnknown := len(flagsRef.UnknownFlags)
+	if numFlagsRemoved > 0 {
+		sections = append(sections, commentSection{
+			title:  fmt.Sprintf(":x: %s removed", utils.Pluralize("flag", numFlagsRemoved)),
+			header: tableHeader,
+			rows:   buildComment.CommentsRemoved,
+			spaced: numFlagsUnknown > 0,
+		})
+	}
+
+	if numFlagsUnknown > 0 {
+		sections = append(sections, commentSection{
+			title:  fmt.Sprintf(":question: %s not found in LaunchDarkly", utils.Pluralize("flag", numFlagsUnknown)),
+			header: "| Key | Location | Did you mean |\n| --- | --- | --- |",
+			rows:   buildComment.CommentsUnknown,
+		})
+	}
+
+	return sections
+}
+
+// Render each section as a heading followed by its table
+func renderSections(sections []commentSection, heading string, layout commentLayout) []string {
+	var commentStr []string
+	for _, section := range sections {
+		commentStr = append(commentStr, fmt.Sprintf("%s %s\n", heading, section.title))
+		if layout.collapsed {
+			commentStr = append(commentStr, "<details><summary>Show flags</summary>\n")
+		}
+		commentStr = append(commentStr, section.header)
+
+		rows := section.rows
+		if layout.maxRows >= 0 && len(rows) > layout.maxRows {
+			rows = rows[:layout.maxRows]
+		}
+		commentStr = append(commentStr, rows...)
+		if omitted := len(section.rows) - len(rows); omitted > 0 {
+			commentStr = append(commentStr, "", fmt.Sprintf("_%s_", withSeeSummary(utils.Pluralize("more row", omitted)+" not shown.", layout.seeSummary)))
+		}
+
+		if layout.collapsed {
+			commentStr = append(commentStr, "</details>")
+		}
+		if section.spaced {
+			commentStr = append(commentStr, "\n")
+		}
+	}
+	return commentStr
+}
+
+// Process flags for the PR comment, showing only the first max-flags rows
 func ProcessFlags(flagsRef refs.ReferenceSummary, flags []ldapi.FeatureFlag, config *lcr.Config) FlagComments {
-	buildComment := FlagComments{}
+	buildComment, _ := processFlags(flagsRef, flags, config, maxFlagsLimit(config))
+	return buildComment
+}
+
+// Process flags for the job summary, showing every flag regardless of max-flags
+func ProcessAllFlags(flagsRef refs.ReferenceSummary, flags []ldapi.FeatureFlag, config *lcr.Config) FlagComments {
+	buildComment, _ := processFlags(flagsRef, flags, config, -1)
+	return buildComment
+}
+
+// Rows for up to limit flags, in the order of the comment sections. A negative limit shows
+// all flags. Returns the limit left for further rows.
+func processFlags(flagsRef refs.ReferenceSummary, flags []ldapi.FeatureFlag, config *lcr.Config, limit int) (FlagComments, int) {
+	buildComment := FlagComments{Environments: environmentColumns(config), ReferenceLinks: referenceLinks(config), Provider: config.Provider, RunURL: config.RunURL}
+
+	added, removed := flagComments(flagsRef, flags, config)
+	buildComment.CommentsIntroduced, limit = limitRows(byChangeType(added, changeTypeIntroduced), limit, config)
+	buildComment.CommentsAdded, limit = limitRows(byChangeType(added, changeTypeModified), limit, config)
+	buildComment.CommentsMoved, limit = limitRows(byChangeType(added, changeTypeMoved), limit, config)
+	buildComment.CommentsRemoved, limit = limitRows(removed, limit, config)
+
+	for _, unknown := range flagsRef.UnknownFlags {
+		buildComment.CommentsUnknown = append(buildComment.CommentsUnknown, unknownFlagRow(unknown))
+	}
+
+	return buildComment, limit
+}
+
+// Row limit for max-flags, where 0 means no limit
+func maxFlagsLimit(config *lcr.Config) int {
+	if config.MaxFlags > 0 {
+		return config.MaxFlags
+	}
+	return -1
+}
+
+// Row for a key that doesn't match a flag, listing where it was added and similar flag keys
+func unknownFlagRow(unknown refs.UnknownFlag) string {
+	locations := make([]string, 0, len(unknown.Locations))
+	for _, l := range unknown.Locations {
+		locations = append(locations, fmt.Sprintf("`%s`", l))
+	}
+	suggestions := make([]string, 0, len(unknown.Suggestions))
+	for _, s := range unknown.Suggestions {
+		suggestions = append(suggestions, fmt.Sprintf("`%s`", s))
+	}
+	return fmt.Sprintf("| `%s` | %s | %s |", unknown.Key, strings.Join(locations, "<br>"), strings.Join(suggestions, ", "))
+}
+
+// Rows for up to limit flags, followed by a row counting the flags left out.
+// A negative limit shows all flags. Returns the limit left for further rows.
+func limitRows(comments []Comment, limit int, config *lcr.Config) ([]string, int) {
+	if len(comments) == 0 {
+		return nil, limit
+	}
+	shown := len(comments)
+	if limit >= 0 && shown > limit {
+		shown = limit
+	}
+
+	rows := make([]string, 0, shown+1)
+	for _, c := range comments[:shown] {
+		rows = append(rows, c.Row)
+	}
+	if omitted := len(comments) - shown; omitted > 0 {
+		columns := len(environmentColumns(config))
+		if referenceLinks(config) {
+			columns++
+		}
+		rows = append(rows, moreFlagsRow(omitted, columns))
+	}
+	if limit < 0 {
+		return rows, limit
+	}
+	return rows, limit - shown
+}
 
+// Environments shown as columns of the flag table
+func environmentColumns(config *lcr.Config) []string {
+	if len(config.LdEnvironments) > 1 {
+		return config.LdEnvironments
+	}
+	return nil
+}
+
+// Row counting the flags left out of a table by max-flags
+func moreFlagsRow(count int, extraColumns int) string {
+	flags := "flag"
+	if count != 1 {
+		flags += "s"
+	}
+	return fmt.Sprintf("| _and %d more %s_ | | | |", count, flags) + strings.Repeat(" |", extraColumns)
+}
+
+// Change types of flags in the comment
+const (
+	changeTypeIntroduced = "introduced"
+	changeTypeModified   = "modified"
+	changeTypeMoved      = "moved"
+	changeTypeRemoved    = "removed"
+)
+
+// Build the comment for each added and removed flag, in key order
+func flagComments(flagsRef refs.ReferenceSummary, flags []ldapi.FeatureFlag, config *lcr.Config) (added, removed []Comment) {
 	for _, flagKey := range flagsRef.AddedKeys() {
 		flagAliases := flagsRef.FlagsAdded[flagKey]
 		idx, _ := find(flags, flagKey)
-		createComment, err := githubFlagComment(flags[idx], flagAliases, true, false, config)
-		if err != nil {
-			gha.LogError(err)
+		comment := newComment(flags[idx], flagAliases, true, false, config)
+		switch {
+		case flagsRef.IsIntroduced(flagKey):
+			comment.ChangeType = changeTypeIntroduced
+		case flagsRef.IsMoved(flagKey):
+			comment.ChangeType = changeTypeMoved
+		default:
+			comment.ChangeType = changeTypeModified
 		}
-		buildComment.CommentsAdded = append(buildComment.CommentsAdded, createComment)
+		added = append(added, processComment(comment, flagsRef))
 	}
 
 	for _, flagKey := range flagsRef.RemovedKeys() {
 		flagAliases := flagsRef.FlagsRemoved[flagKey]
 		idx, _ := find(flags, flagKey)
 		extinct := flagsRef.IsExtinct(flagKey)
-		removedComment, err := githubFlagComment(flags[idx], flagAliases, false, extinct, config)
-		if err != nil {
-			gha.LogError(err)
+		comment := newComment(flags[idx], flagAliases, false, extinct, config)
+		comment.ChangeType = changeTypeRemoved
+		removed = append(removed, processComment(comment, flagsRef))
+	}
+
+	return added, removed
+}
+
+func byChangeType(comments []Comment, changeType string) []Comment {
+	filtered := make([]Comment, 0, len(comments))
+	for _, c := range comments {
+		if c.ChangeType == changeType {
+			filtered = append(filtered, c)
 		}
-		buildComment.CommentsRemoved = append(buildComment.CommentsRemoved, removedComment)
 	}
+	return filtered
+}
 
-	return buildComment
+func processComment(comment Comment, flagsRef refs.ReferenceSummary) Comment {
+	comment.Locations = flagsRef.References[comment.FlagKey]
+	if comment.RepoURL != "" && comment.HeadSha != "" {
+		comment.ReferencesCell = referencesCell(flagsRef, comment.FlagKey, comment.RepoURL, comment.HeadSha)
+	}
+	row, err := renderRow(comment)
+	if err != nil {
+		gha.LogError(err)
+	}
+	comment.Row = row
+	return comment
+}
+
+// Process flags of each project for the PR comment. max-flags limits the rows shown across all
+// projects, so once it's reached later projects only note how many flags were left out.
+func ProcessProjects(projects []scan.Project) []ProjectFlagComments {
+	if len(projects) == 0 {
+		return []ProjectFlagComments{}
+	}
+	return processProjects(projects, maxFlagsLimit(projects[0].Config))
+}
+
+// Process every flag of each project for the job summary
+func ProcessAllProjects(projects []scan.Project) []ProjectFlagComments {
+	return processProjects(projects, -1)
+}
+
+func processProjects(projects []scan.Project, limit int) []ProjectFlagComments {
+	projectComments := make([]ProjectFlagComments, 0, len(projects))
+	for _, p := range projects {
+		var flagComments FlagComments
+		flagComments, limit = processFlags(p.References, p.Flags, p.Config, limit)
+		projectComments = append(projectComments, ProjectFlagComments{
+			ProjectKey:   p.Key,
+			FlagComments: flagComments,
+			References:   p.References,
+		})
+	}
+	return projectComments
+}
+
+func anyFound(projects []ProjectFlagComments) bool {
+	for _, p := range projects {
+		if p.References.AnyFound() {
+			return true
+		}
+	}
+	return false
 }
 
 func find(slice []ldapi.FeatureFlag, val string) (int, bool) {
@@ -197,12 +589,3 @@ func uniqueFlagKeys(a, b refs.FlagAliasMap) []string {
 
 	return allKeys
 }
-
-func pluralize(str string, strLength int) string {
-	tmpl := "%d %s"
-	if strLength != 1 {
-		tmpl += "s"
-	}
-
-	return fmt.Sprintf(tmpl, strLength, str)
-}
@@ -1,12 +1,16 @@
 package comments
 
 import (
+	"fmt"
 	"strings"
 	"testing"
+	"unicode/utf8"
 
 	ldapi "github.com/launchdarkly/api-client-go/v15"
 	"github.com/launchdarkly/find-code-references-in-pull-request/config"
 	refs "github.com/launchdarkly/find-code-references-in-pull-request/internal/references"
+	"github.com/launchdarkly/find-code-references-in-pull-request/internal/scan"
+	"github.com/launchdarkly/find-code-references-in-pull-request/internal/utils/diff_util"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -243,7 +247,7 @@ func (e *testFlagEnv) DeprecatedRemoved(t *testing.T) {
 func (e *testCommentBuilder) AddedOnly(t *testing.T) {
 	e.FlagsRef.FlagsAdded["example-flag"] = []string{}
 	e.Comments.CommentsAdded = []string{"comment1", "comment2"}
-	comment := BuildFlagComment(e.Comments, e.FlagsRef, nil)
+	comment := BuildFlagComment(e.Comments, e.FlagsRef, "")
 
 	expected := "## LaunchDarkly flag references\n### :mag: 1 flag added or modified\n\n| Name | Key | Aliases found | Info |\n| --- | --- | --- | --- |\ncomment1\ncomment2\n\n\n <!-- flags:example-flag -->\n <!-- comment hash: 58e2fd003e576dfd9e59d82b7fb20ca4 -->"
 	assert.Equal(t, expected, comment)
@@ -253,7 +257,7 @@ func (e *testCommentBuilder) RemovedOnly(t *testing.T) {
 	e.FlagsRef.FlagsRemoved["example-flag"] = []string{}
 	e.FlagsRef.FlagsRemoved["sample-flag"] = []string{}
 	e.Comments.CommentsRemoved = []string{"comment1", "comment2"}
-	comment := BuildFlagComment(e.Comments, e.FlagsRef, nil)
+	comment := BuildFlagComment(e.Comments, e.FlagsRef, "")
 
 	expected := "## LaunchDarkly flag references\n### :x: 2 flags removed\n\n| Name | Key | Aliases found | Info |\n| --- | --- | --- | --- |\ncomment1\ncomment2\n <!-- flags:example-flag,sample-flag -->\n <!-- comment hash: acdd50c762ddfa84720067a3b272a032 -->"
 	assert.Equal(t, expected, comment)
@@ -264,7 +268,7 @@ func (e *testCommentBuilder) AddedAndRemoved(t *testing.T) {
 	e.FlagsRef.FlagsRemoved["example-flag"] = []string{}
 	e.Comments.CommentsAdded = []string{"comment1", "comment2"}
 	e.Comments.CommentsRemoved = []string{"comment1", "comment2"}
-	comment := BuildFlagComment(e.Comments, e.FlagsRef, nil)
+	comment := BuildFlagComment(e.Comments, e.FlagsRef, "")
 
 	expected := "## LaunchDarkly flag references\n### :mag: 1 flag added or modified\n\n| Name | Key | Aliases found | Info |\n| --- | --- | --- | --- |\ncomment1\ncomment2\n\n\n### :x: 1 flag removed\n\n| Name | Key | Aliases found | Info |\n| --- | --- | --- | --- |\ncomment1\ncomment2\n <!-- flags:example-flag -->\n <!-- comment hash: 4f891355662b901597e6563a11c15332 -->"
 
@@ -272,6 +276,91 @@ func (e *testCommentBuilder) AddedAndRemoved(t *testing.T) {
 
 }
 
+func TestBuildFlagComment_tooLong(t *testing.T) {
+	flagsRef := refs.ReferenceSummary{FlagsAdded: refs.FlagAliasMap{}, FlagsRemoved: refs.FlagAliasMap{"old-flag": {}}}
+	buildComment := FlagComments{RunURL: "https://github.com/org/repo/actions/runs/1", CommentsRemoved: []string{"removed"}}
+	for i := 0; i < 300; i++ {
+		key := fmt.Sprintf("flag-%d", i)
+		flagsRef.FlagsAdded[key] = []string{}
+		buildComment.CommentsAdded = append(buildComment.CommentsAdded, fmt.Sprintf("| %s | `%s` | | |", strings.Repeat("x", 250), key))
+	}
+
+	// tables are collapsed and the rows that don't fit are left out
+	comment := BuildFlagComment(buildComment, flagsRef, "")
+	assert.LessOrEqual(t, utf8.RuneCountInString(comment), MaxCommentLength)
+	assert.Contains(t, comment, "### :mag: 300 flags added or modified\n\n<details><summary>Show flags</summary>\n\n| Name |")
+	assert.Contains(t, comment, "`flag-99` | | |\n\n_200 more rows not shown. See the [job summary](https://github.com/org/repo/actions/runs/1) for all flags._\n</details>")
+	assert.Contains(t, comment, "### :x: 1 flag removed\n\n<details><summary>Show flags</summary>\n\n| Name | Key | Aliases found | Info |\n| --- | --- | --- | --- |\nremoved\n</details>")
+	keys, _ := FlagKeysFromComment(comment)
+	assert.Len(t, keys, 301)
+
+	// the summary is unchanged
+	assert.NotContains(t, BuildFlagSummary(buildComment, flagsRef), "<details>")
+
+	// without room for the flag keys, they're left out
+	for i := 0; i < 3000; i++ {
+		flagsRef.FlagsRemoved[fmt.Sprintf("removed-flag-with-a-long-key-%d", i)] = []string{}
+	}
+	comment = BuildFlagComment(buildComment, flagsRef, "")
+	assert.LessOrEqual(t, utf8.RuneCountInString(comment), MaxCommentLength)
+	assert.Contains(t, comment, "_300 more rows not shown.")
+	_, marked := FlagKeysFromComment(comment)
+	assert.False(t, marked)
+}
+
+func TestSeeSummary(t *testing.T) {
+	assert.Equal(t, "See the job summary for all flags.", seeSummary(config.ProviderGitHub, ""))
+	assert.Equal(t, "See the [job summary](https://github.com/org/repo/actions/runs/1) for all flags.", seeSummary(config.ProviderGitHub, "https://github.com/org/repo/actions/runs/1"))
+
+	// GitLab has no job summary to point to
+	assert.Empty(t, seeSummary(config.ProviderGitLab, "https://gitlab.com/org/repo/-/pipelines/1"))
+	lines := truncateLines([]string{"flags", strings.Repeat("x", 50)}, 40, seeSummary(config.ProviderGitLab, ""))
+	assert.Equal(t, []string{"flags", "", "_Comment truncated._"}, lines)
+}
+
+func TestBuildFlagSummary(t *testing.T) {
+	env := newCommentBuilderAccEnv()
+	assert.Equal(t, "## LaunchDarkly flag references\n\n **No flag references found in PR**", BuildFlagSummary(env.Comments, env.FlagsRef))
+
+	env.FlagsRef.FlagsAdded["example-flag"] = []string{}
+	env.FlagsRef.FlagsRemoved["sample-flag"] = []string{}
+	env.Comments.CommentsAdded = []string{"comment1"}
+	env.Comments.CommentsRemoved = []string{"comment2"}
+
+	expected := "## LaunchDarkly flag references\n### :mag: 1 flag added or modified\n\n| Name | Key | Aliases found | Info |\n| --- | --- | --- | --- |\ncomment1\n\n\n### :x: 1 flag removed\n\n| Name | Key | Aliases found | Info |\n| --- | --- | --- | --- |\ncomment2"
+	assert.Equal(t, expected, BuildFlagSummary(env.Comments, env.FlagsRef))
+}
+
+func TestBuildProjectsFlagComment(t *testing.T) {
+	web := ProjectFlagComments{
+		ProjectKey:   "web",
+		FlagComments: FlagComments{CommentsAdded: []string{"comment1"}},
+		References:   refs.ReferenceSummary{FlagsAdded: refs.FlagAliasMap{"web-flag": {}}},
+	}
+	mobile := ProjectFlagComments{
+		ProjectKey:   "mobile",
+		FlagComments: FlagComments{CommentsRemoved: []string{"comment2"}},
+		References:   refs.ReferenceSummary{FlagsRemoved: refs.FlagAliasMap{"mobile-flag": {}}},
+	}
+	empty := ProjectFlagComments{ProjectKey: "empty"}
+
+	// a single project is unchanged
+	assert.Equal(t, BuildFlagComment(web.FlagComments, web.References, ""), BuildProjectsFlagComment([]ProjectFlagComments{web}, ""))
+
+	comment := BuildProjectsFlagComment([]ProjectFlagComments{web, mobile, empty}, "")
+	expected := "## LaunchDarkly flag references\n### Project `web`\n#### :mag: 1 flag added or modified\n\n| Name | Key | Aliases found | Info |\n| --- | --- | --- | --- |\ncomment1\n\n\n### Project `mobile`\n#### :x: 1 flag removed\n\n| Name | Key | Aliases found | Info |\n| --- | --- | --- | --- |\ncomment2\n <!-- flags:mobile-flag,web-flag -->\n <!-- comment hash: "
+	assert.True(t, strings.HasPrefix(comment, expected), comment)
+	keys, marked := FlagKeysFromComment(comment)
+	assert.True(t, marked)
+	assert.Equal(t, []string{"mobile-flag", "web-flag"}, keys)
+	_, marked = FlagKeysFromComment(GithubNoFlagComment().GetBody())
+	assert.False(t, marked)
+	_, marked = FlagKeysFromComment("")
+	assert.False(t, marked)
+
+	assert.Equal(t, "## LaunchDarkly flag references\n\n **No flag references found in PR**", BuildProjectsFlagSummary([]ProjectFlagComments{empty, empty}))
+}
+
 func (e *testProcessor) Basic(t *testing.T) {
 	e.FlagsRef.FlagsAdded["example-flag"] = []string{}
 	processor := ProcessFlags(e.FlagsRef, e.Flags, &e.Config)
@@ -293,3 +382,260 @@ func (e *testProcessor) Multi(t *testing.T) {
 	}
 	assert.Equal(t, expected, processor)
 }
+
+func TestGithubFlagComment_multipleEnvironments(t *testing.T) {
+	flag := createFlag("example-flag")
+	flag.Variations[0].Name = ptr("Enabled")
+	flag.Environments["production"] = ldapi.FeatureFlagConfig{
+		On:           true,
+		Site:         ldapi.Link{Href: ptr("/default/production/features/example-flag")},
+		Fallthrough:  &ldapi.VariationOrRolloutRep{Variation: ptr(int32(0))},
+		OffVariation: ptr(int32(1)),
+		LastModified: 1691072480000,
+	}
+	flag.Environments["staging"] = ldapi.FeatureFlagConfig{
+		Site:         ldapi.Link{Href: ptr("/default/staging/features/example-flag")},
+		Fallthrough:  &ldapi.VariationOrRolloutRep{Rollout: &ldapi.Rollout{}},
+		OffVariation: ptr(int32(1)),
+	}
+	config := config.Config{
+		LdEnvironment:  "production",
+		LdEnvironments: []string{"production", "staging", "test"},
+		LdInstance:     "https://example.com",
+	}
+
+	comment, err := githubFlagComment(flag, []string{}, true, false, &config)
+	require.NoError(t, err)
+
+	expected := "| [example flag](https://example.com/default/production/features/example-flag) | `example-flag` | | |" +
+		" [:green_circle: On](https://example.com/default/production/features/example-flag)<br>Default: `Enabled`<br>Off: `false`<br>Modified 2023-08-03 |" +
+		" [:red_circle: Off](https://example.com/default/staging/features/example-flag)<br>Default: percentage rollout<br>Off: `false` |" +
+		" - |"
+	assert.Equal(t, expected, comment)
+
+	processed := ProcessFlags(refs.ReferenceSummary{FlagsAdded: refs.FlagAliasMap{"example-flag": {}}}, []ldapi.FeatureFlag{flag}, &config)
+	assert.Equal(t, []string{"production", "staging", "test"}, processed.Environments)
+	summary := BuildFlagSummary(processed, refs.ReferenceSummary{FlagsAdded: refs.FlagAliasMap{"example-flag": {}}})
+	assert.Contains(t, summary, "| Name | Key | Aliases found | Info | production | staging | test |\n| --- | --- | --- | --- | --- | --- | --- |")
+}
+
+func TestProcessFlags_maxFlags(t *testing.T) {
+	flags := []ldapi.FeatureFlag{createFlag("flag-a"), createFlag("flag-b"), createFlag("flag-c"), createFlag("flag-d")}
+	flagsRef := refs.ReferenceSummary{
+		FlagsAdded:   refs.FlagAliasMap{"flag-a": {}, "flag-b": {}},
+		FlagsRemoved: refs.FlagAliasMap{"flag-c": {}, "flag-d": {}},
+	}
+	config := config.Config{
+		LdEnvironment: "production",
+		LdInstance:    "https://example.com/",
+		MaxFlags:      1,
+	}
+
+	processed := ProcessFlags(flagsRef, flags, &config)
+	assert.Equal(t, []string{
+		"| [flag a](https://example.com/test) | `flag-a` | | |",
+		"| _and 1 more flag_ | | | |",
+	}, processed.CommentsAdded)
+	assert.Equal(t, []string{"| _and 2 more flags_ | | | |"}, processed.CommentsRemoved)
+
+	// the headings count all flags
+	summary := BuildFlagSummary(processed, flagsRef)
+	assert.Contains(t, summary, "### :mag: 2 flags added or modified")
+	assert.Contains(t, summary, "### :x: 2 flags removed")
+
+	config.MaxFlags = 3
+	processed = ProcessFlags(flagsRef, flags, &config)
+	assert.Len(t, processed.CommentsAdded, 2)
+	assert.Equal(t, []string{
+		"| [flag c](https://example.com/test) | `flag-c` | | |",
+		"| _and 1 more flag_ | | | |",
+	}, processed.CommentsRemoved)
+
+	config.MaxFlags = 0
+	config.LdEnvironments = []string{"production", "staging"}
+	processed = ProcessFlags(flagsRef, flags, &config)
+	assert.Len(t, processed.CommentsAdded, 2)
+	assert.Len(t, processed.CommentsRemoved, 2)
+
+	assert.Equal(t, "| _and 3 more flags_ | | | | | |", moreFlagsRow(3, len(config.LdEnvironments)))
+}
+
+func TestProcessProjects_maxFlags(t *testing.T) {
+	config := &config.Config{LdEnvironment: "production", LdInstance: "https://example.com/", MaxFlags: 3}
+	projects := []scan.Project{
+		{
+			Key:        "web",
+			Config:     config,
+			Flags:      []ldapi.FeatureFlag{createFlag("flag-a"), createFlag("flag-b")},
+			References: refs.ReferenceSummary{FlagsAdded: refs.FlagAliasMap{"flag-a": {}, "flag-b": {}}},
+		},
+		{
+			Key:        "mobile",
+			Config:     config,
+			Flags:      []ldapi.FeatureFlag{createFlag("flag-c"), createFlag("flag-d")},
+			References: refs.ReferenceSummary{FlagsAdded: refs.FlagAliasMap{"flag-c": {}, "flag-d": {}}},
+		},
+	}
+
+	// max-flags is shared by all projects
+	processed := ProcessProjects(projects)
+	require.Len(t, processed, 2)
+	assert.Len(t, processed[0].CommentsAdded, 2)
+	assert.Equal(t, []string{
+		"| [flag c](https://example.com/test) | `flag-c` | | |",
+		"| _and 1 more flag_ | | | |",
+	}, processed[1].CommentsAdded)
+
+	// the job summary shows every flag
+	processed = ProcessAllProjects(projects)
+	assert.Len(t, processed[1].CommentsAdded, 2)
+}
+
+func TestProcessFlags_changeTypes(t *testing.T) {
+	flags := []ldapi.FeatureFlag{createFlag("flag-a"), createFlag("flag-b"), createFlag("flag-c"), createFlag("flag-d")}
+	flagsRef := refs.ReferenceSummary{
+		FlagsAdded:      refs.FlagAliasMap{"flag-a": {}, "flag-b": {}, "flag-c": {}},
+		FlagsRemoved:    refs.FlagAliasMap{"flag-d": {}},
+		IntroducedFlags: map[string]struct{}{"flag-a": {}},
+		MovedFlags:      map[string]struct{}{"flag-c": {}},
+	}
+	config := config.Config{
+		LdEnvironment: "production",
+		LdInstance:    "https://example.com/",
+	}
+
+	processed := ProcessFlags(flagsRef, flags, &config)
+	assert.Equal(t, []string{"| [flag a](https://example.com/test) | `flag-a` | | |"}, processed.CommentsIntroduced)
+	assert.Equal(t, []string{"| [flag b](https://example.com/test) | `flag-b` | | |"}, processed.CommentsAdded)
+	assert.Equal(t, []string{"| [flag c](https://example.com/test) | `flag-c` | | |"}, processed.CommentsMoved)
+	assert.Equal(t, []string{"| [flag d](https://example.com/test) | `flag-d` | | |"}, processed.CommentsRemoved)
+
+	header := "\n| Name | Key | Aliases found | Info |\n| --- | --- | --- | --- |\n"
+	expected := "## LaunchDarkly flag references\n" +
+		"### :sparkles: 1 flag introduced\n" + header + processed.CommentsIntroduced[0] + "\n\n\n" +
+		"### :mag: 1 flag modified\n" + header + processed.CommentsAdded[0] + "\n\n\n" +
+		"### :truck: 1 flag moved\n" + header + processed.CommentsMoved[0] + "\n\n\n" +
+		"### :x: 1 flag removed\n" + header + processed.CommentsRemoved[0]
+	assert.Equal(t, expected, BuildFlagSummary(processed, flagsRef))
+
+	added, removed := flagComments(flagsRef, flags, &config)
+	changeTypes := make([]string, 0)
+	for _, c := range append(added, removed...) {
+		changeTypes = append(changeTypes, c.ChangeType)
+	}
+	assert.Equal(t, []string{"introduced", "modified", "moved", "removed"}, changeTypes)
+}
+
+func TestProcessFlags_referenceLinks(t *testing.T) {
+	flags := []ldapi.FeatureFlag{createFlag("flag-a"), createFlag("flag-b")}
+	flagsRef := refs.ReferenceSummary{
+		FlagsAdded:   refs.FlagAliasMap{"flag-a": {}},
+		FlagsRemoved: refs.FlagAliasMap{"flag-b": {}},
+		References: refs.FlagReferenceMap{
+			"flag-a": {
+				{Path: "app.go", Line: 4, Operation: diff_util.OperationAdd},
+				{Path: "app.go", Line: 9, Operation: diff_util.OperationDelete},
+				{Path: "app.go", Line: 12, Operation: diff_util.OperationAdd},
+				{Path: "web/my page.ts", Line: 1, Operation: diff_util.OperationAdd},
+			},
+			"flag-b": {{Path: "old.go", Line: 3, Operation: diff_util.OperationDelete}},
+		},
+	}
+	config := config.Config{
+		LdEnvironment: "production",
+		LdInstance:    "https://example.com/",
+		RepoURL:       "https://github.com/org/repo",
+		HeadSha:       "abc123",
+		MaxFlags:      1,
+	}
+
+	processed := ProcessFlags(flagsRef, flags, &config)
+	assert.True(t, processed.ReferenceLinks)
+	assert.Equal(t, []string{
+		"| [flag a](https://example.com/test) | `flag-a` | | | `app.go` (3): [L4](https://github.com/org/repo/blob/abc123/app.go#L4), [L12](https://github.com/org/repo/blob/abc123/app.go#L12)<br>`web/my page.ts` (1): [L1](https://github.com/org/repo/blob/abc123/web/my%20page.ts#L1) |",
+	}, processed.CommentsAdded)
+	// removed references aren't in the head commit, so they aren't linked to
+	assert.Equal(t, []string{"| _and 1 more flag_ | | | | |"}, processed.CommentsRemoved)
+	assert.Contains(t, BuildFlagSummary(processed, flagsRef), "| Name | Key | Aliases found | Info | References |\n| --- | --- | --- | --- | --- |")
+
+	config.MaxFlags = 0
+	_, removed := flagComments(flagsRef, flags, &config)
+	assert.Equal(t, "| [flag b](https://example.com/test) | `flag-b` | | | `old.go` (1) |", removed[0].Row)
+
+	// without a head commit the column is left out
+	config.HeadSha = ""
+	processed = ProcessFlags(flagsRef, flags, &config)
+	assert.False(t, processed.ReferenceLinks)
+	assert.Equal(t, []string{"| [flag a](https://example.com/test) | `flag-a` | | |"}, processed.CommentsAdded)
+}
+
+func TestProcessFlags_unknownFlags(t *testing.T) {
+	flagsRef := refs.ReferenceSummary{
+		UnknownFlags: []refs.UnknownFlag{
+			{
+				Key:         "exmaple-flag",
+				Suggestions: []string{"example-flag", "sample-flag"},
+				Locations:   []refs.ReferenceLocation{{Path: "main.go", Line: 3}, {Path: "app.go", Line: 7}},
+			},
+			{Key: "new-flag", Locations: []refs.ReferenceLocation{{Path: "main.go", Line: 4}}},
+		},
+	}
+	config := config.Config{LdEnvironment: "production"}
+
+	processed := ProcessFlags(flagsRef, nil, &config)
+	assert.Equal(t, []string{
+		"| `exmaple-flag` | `main.go:3`<br>`app.go:7` | `example-flag`, `sample-flag` |",
+		"| `new-flag` | `main.go:4` |  |",
+	}, processed.CommentsUnknown)
+
+	expected := "## LaunchDarkly flag references\n### :question: 2 flags not found in LaunchDarkly\n\n| Key | Location | Did you mean |\n| --- | --- | --- |\n" +
+		strings.Join(processed.CommentsUnknown, "\n")
+	assert.Equal(t, expected, BuildFlagSummary(processed, flagsRef))
+}
+
+func TestReviewFlagComment(t *testing.T) {
+	env := newTestAccEnv()
+
+	comment, err := ReviewFlagComment(env.Flag, &env.Config)
+	require.NoError(t, err)
+	assert.Equal(t, "**LaunchDarkly flag** [example flag](https://example.com/test) `example-flag`\n\n<!-- launchdarkly-flag-reference:example-flag -->", comment)
+
+	comment, err = ReviewFlagComment(env.ArchivedFlag, &env.Config)
+	require.NoError(t, err)
+	assert.Equal(t, "**LaunchDarkly flag** [archived flag](https://example.com/test) `archived-flag`\n\n:warning: archived on 2023-08-03\n\n<!-- launchdarkly-flag-reference:archived-flag -->", comment)
+
+	comment, err = ReviewFlagComment(env.DeprecatedFlag, &env.Config)
+	require.NoError(t, err)
+	assert.Equal(t, "**LaunchDarkly flag** [deprecated flag](https://example.com/test) `deprecated-flag`\n\n:warning: deprecated on 2023-08-03\n\n<!-- launchdarkly-flag-reference:deprecated-flag -->", comment)
+}
+
+func TestReviewCommentFlagKey(t *testing.T) {
+	key, ok := ReviewCommentFlagKey("some text\n\n<!-- launchdarkly-flag-reference:example-flag -->")
+	assert.True(t, ok)
+	assert.Equal(t, "example-flag", key)
+
+	_, ok = ReviewCommentFlagKey("a human comment mentioning example-flag")
+	assert.False(t, ok)
+}
+
+func TestProcessAllFlags(t *testing.T) {
+	flags := []ldapi.FeatureFlag{createFlag("flag-a"), createFlag("flag-b"), createFlag("flag-c")}
+	flagsRef := refs.ReferenceSummary{
+		FlagsAdded:   refs.FlagAliasMap{"flag-a": {}, "flag-b": {}},
+		FlagsRemoved: refs.FlagAliasMap{"flag-c": {}},
+	}
+	config := config.Config{
+		LdEnvironment: "production",
+		LdInstance:    "https://example.com/",
+		MaxFlags:      1,
+	}
+
+	// max-flags only limits the PR comment
+	processed := ProcessAllFlags(flagsRef, flags, &config)
+	assert.Equal(t, []string{
+		"| [flag a](https://example.com/test) | `flag-a` | | |",
+		"| [flag b](https://example.com/test) | `flag-b` | | |",
+	}, processed.CommentsAdded)
+	assert.Equal(t, []string{"| [flag c](https://example.com/test) | `flag-c` | | |"}, processed.CommentsRemoved)
+	assert.NotContains(t, BuildFlagSummary(processed, flagsRef), "more flag")
+}
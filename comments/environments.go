@@ -0,0 +1,96 @@
+package comments
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	ldapi "github.com/launchdarkly/api-client-go/v15"
+)
+
+// State of a flag in a single environment, rendered as a column of the flag table
+type EnvironmentState struct {
+	Key          string
+	Found        bool // whether the flag has a configuration for the environment
+	On           bool
+	URL          string
+	Fallthrough  string // variation served when targeting is on and no rules match
+	OffVariation string // variation served when targeting is off
+	LastModified time.Time
+}
+
+// Template for an environment cell
+func environmentCellTemplate() string {
+	return `{{- if not .Found}} - ` +
+		`{{- else}} [{{if .On}}:green_circle: On{{else}}:red_circle: Off{{end}}]({{.URL}})` +
+		`{{- if .Fallthrough}}<br>Default: {{.Fallthrough}}{{end}}` +
+		`{{- if .OffVariation}}<br>Off: {{.OffVariation}}{{end}}` +
+		`{{- if not .LastModified.IsZero}}<br>Modified {{.LastModified | date "2006-01-02"}}{{end}}` +
+		`{{- end}} |`
+}
+
+// Get the state of the flag in each environment, in order
+func environmentStates(flag ldapi.FeatureFlag, environments []string, ldInstance string) []EnvironmentState {
+	states := make([]EnvironmentState, 0, len(environments))
+	for _, envKey := range environments {
+		env, ok := flag.Environments[envKey]
+		state := EnvironmentState{Key: envKey, Found: ok}
+		if ok {
+			state.On = env.On
+			state.URL = ldInstance + env.Site.GetHref()
+			state.Fallthrough = fallthroughDescription(flag, env.Fallthrough)
+			if env.OffVariation != nil {
+				state.OffVariation = variationName(flag, int(*env.OffVariation))
+			}
+			if env.LastModified > 0 {
+				state.LastModified = time.UnixMilli(env.LastModified)
+			}
+		}
+		states = append(states, state)
+	}
+	return states
+}
+
+func fallthroughDescription(flag ldapi.FeatureFlag, rep *ldapi.VariationOrRolloutRep) string {
+	switch {
+	case rep == nil:
+		return ""
+	case rep.Variation != nil:
+		return variationName(flag, int(*rep.Variation))
+	case rep.Rollout != nil:
+		return "percentage rollout"
+	}
+	return ""
+}
+
+// Name of the variation at idx, falling back to its value
+func variationName(flag ldapi.FeatureFlag, idx int) string {
+	if idx < 0 || idx >= len(flag.Variations) {
+		return ""
+	}
+	variation := flag.Variations[idx]
+	if name := variation.GetName(); name != "" {
+		return fmt.Sprintf("`%s`", name)
+	}
+
+	b, err := json.Marshal(variation.Value)
+	if err != nil {
+		return ""
+	}
+	return fmt.Sprintf("`%s`", b)
+}
+
+// Header of the flag table, with a column for references when they can be linked to and a
+// column for each environment
+func tableHeader(environments []string, referenceLinks bool) string {
+	header, separator := "| Name | Key | Aliases found | Info |", "| --- | --- | --- | --- |"
+	if referenceLinks {
+		header += " References |"
+		separator += " --- |"
+	}
+	for _, env := range environments {
+		header += fmt.Sprintf(" %s |", env)
+		separator += " --- |"
+	}
+	return header + "\n" + separator
+}
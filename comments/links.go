@@ -0,0 +1,34 @@
+package comments
+
+import (
+	"fmt"
+	"strings"
+
+	lcr "github.com/launchdarkly/find-code-references-in-pull-request/config"
+	refs "github.com/launchdarkly/find-code-references-in-pull-request/internal/references"
+	"github.com/launchdarkly/find-code-references-in-pull-request/internal/utils/diff_util"
+)
+
+// Whether references can be linked to in the repository
+func referenceLinks(config *lcr.Config) bool {
+	return config.RepoURL != "" && config.HeadSha != ""
+}
+
+// Cell listing each file with references to the flag and the number of references in it, linking
+// to the lines where references were added
+func referencesCell(flagsRef refs.ReferenceSummary, flagKey, repoURL, headSha string) string {
+	added := make(map[string][]string)
+	for _, l := range flagsRef.LocationsByOperation(flagKey, diff_util.OperationAdd) {
+		added[l.Path] = append(added[l.Path], fmt.Sprintf("[L%d](%s)", l.Line, l.BlobURL(repoURL, headSha)))
+	}
+
+	files := make([]string, 0)
+	for _, file := range flagsRef.FileCounts(flagKey) {
+		cell := fmt.Sprintf("`%s` (%d)", file.Path, file.Count)
+		if links := added[file.Path]; len(links) > 0 {
+			cell += ": " + strings.Join(links, ", ")
+		}
+		files = append(files, cell)
+	}
+	return strings.Join(files, "<br>")
+}
@@ -0,0 +1,81 @@
+package comments
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"html/template"
+	"regexp"
+	"strings"
+	"time"
+
+	sprig "github.com/Masterminds/sprig/v3"
+
+	ldapi "github.com/launchdarkly/api-client-go/v15"
+	lcr "github.com/launchdarkly/find-code-references-in-pull-request/config"
+)
+
+const reviewCommentMarker = "<!-- launchdarkly-flag-reference:%s -->"
+
+var reviewCommentMarkerRegex = regexp.MustCompile(`<!-- launchdarkly-flag-reference:(\S+) -->`)
+
+// Build the body of an inline review comment left on a line that references a flag
+func ReviewFlagComment(flag ldapi.FeatureFlag, config *lcr.Config) (string, error) {
+	commentTemplate := Comment{
+		FlagKey:    flag.Key,
+		FlagName:   flag.Name,
+		Archived:   flag.Archived,
+		Deprecated: flag.Deprecated,
+		Added:      true,
+		Primary:    flag.Environments[config.LdEnvironment],
+		LDInstance: config.LdInstance,
+	}
+	if flag.ArchivedDate != nil {
+		commentTemplate.ArchivedAt = time.UnixMilli(*flag.ArchivedDate)
+	}
+	if flag.DeprecatedDate != nil {
+		commentTemplate.DeprecatedAt = time.UnixMilli(*flag.DeprecatedDate)
+	}
+
+	tmplSetup := `**LaunchDarkly flag** [{{.FlagName}}]({{.LDInstance}}{{.Primary.Site.Href}}) ` + "`" + `{{.FlagKey}}` + "`"
+
+	header, err := renderComment(tmplSetup, commentTemplate)
+	if err != nil {
+		return "", err
+	}
+	info, err := renderComment(infoCellTemplate(), commentTemplate)
+	if err != nil {
+		return "", err
+	}
+
+	lines := []string{header}
+	if info = strings.TrimSpace(info); info != "" {
+		lines = append(lines, info)
+	}
+	lines = append(lines, fmt.Sprintf(reviewCommentMarker, flag.Key))
+
+	return strings.Join(lines, "\n\n"), nil
+}
+
+// Returns the flag key of a review comment created by this action
+func ReviewCommentFlagKey(body string) (string, bool) {
+	matches := reviewCommentMarkerRegex.FindStringSubmatch(body)
+	if len(matches) < 2 {
+		return "", false
+	}
+	return matches[1], true
+}
+
+func renderComment(tmplSetup string, comment Comment) (string, error) {
+	tmpl, err := template.New("comment").Funcs(template.FuncMap{"trim": strings.TrimSpace, "isNil": isNil}).Funcs(sprig.FuncMap()).Parse(tmplSetup)
+	if err != nil {
+		return "", err
+	}
+
+	var commentBody bytes.Buffer
+	if err := tmpl.Execute(&commentBody, comment); err != nil {
+		return "", err
+	}
+
+	return html.UnescapeString(commentBody.String()), nil
+}
@@ -0,0 +1,114 @@
+package comments
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	lcr "github.com/launchdarkly/find-code-references-in-pull-request/config"
+	gha "github.com/launchdarkly/find-code-references-in-pull-request/internal/github_actions"
+)
+
+// Maximum length of a GitHub comment body, in characters
+const MaxCommentLength = 65536
+
+// Maximum length of a GitLab merge request note, in characters
+const MaxGitLabCommentLength = 1000000
+
+// Room left for the comment hash and instance markers, which are added after the comment is sized
+const markerLength = 256
+
+// How the flag tables of the comment are rendered
+type commentLayout struct {
+	collapsed  bool   // tables are wrapped in a <details> block
+	maxRows    int    // rows shown in each table, -1 for all
+	seeSummary string // where to find omitted rows, empty if there is nowhere to look
+}
+
+// Rows shown in each table of a shortened comment, tried in order until the comment fits
+var rowLimits = []int{100, 50, 20, 10, 5, 0}
+
+var defaultLayout = commentLayout{maxRows: -1}
+
+// Render the comment with the first layout that fits the provider's comment length. Longer comments
+// have their flag tables collapsed, leaving out rows until the comment fits. Returns the comment
+// and the flag keys to mark it with, which are left out if there isn't room for them.
+func fitComment(render func(commentLayout) []string, allFlagKeys []string, provider, runURL string) ([]string, []string) {
+	maxLength := maxCommentLength(provider)
+	commentStr := render(defaultLayout)
+	if fits(commentStr, allFlagKeys, maxLength) {
+		return commentStr, allFlagKeys
+	}
+
+	gha.Log("Comment is longer than %d characters, shortening it", maxLength)
+	layout := commentLayout{collapsed: true, seeSummary: seeSummary(provider, runURL)}
+	for _, maxRows := range rowLimits {
+		layout.maxRows = maxRows
+		commentStr = render(layout)
+		if fits(commentStr, allFlagKeys, maxLength) {
+			gha.SetNotice("%s", withSeeSummary(fmt.Sprintf("Comment is too long, rows after the first %d of each flag table are left out.", maxRows), seeSummary(provider, "")))
+			return commentStr, allFlagKeys
+		}
+	}
+
+	// without the flag keys, every flag is checked for stale flag links when the comment is next updated
+	gha.Log("Comment is too long to list the flags referenced, every flag will be checked for stale flag links")
+	return truncateLines(commentStr, maxLength-markerLength, layout.seeSummary), nil
+}
+
+// Maximum length of a comment posted to the provider, in characters
+func maxCommentLength(provider string) int {
+	if provider == lcr.ProviderGitLab {
+		return MaxGitLabCommentLength
+	}
+	return MaxCommentLength
+}
+
+// Whether the comment and its flags marker fit within maxLength characters
+func fits(commentStr []string, allFlagKeys []string, maxLength int) bool {
+	length := utf8.RuneCountInString(strings.Join(commentStr, "\n")) + markerLength + flagsMarkerLength(allFlagKeys)
+	return length <= maxLength
+}
+
+// Length of the flags marker, including the line break before it
+func flagsMarkerLength(allFlagKeys []string) int {
+	if len(allFlagKeys) == 0 {
+		return 0
+	}
+	return utf8.RuneCountInString(flagsMarker(allFlagKeys)) + 1
+}
+
+// Drop lines from the end of the comment until it's at most maxLength characters, ending it
+// with a note of where to find the rest
+func truncateLines(commentStr []string, maxLength int, seeSummary string) []string {
+	note := fmt.Sprintf("_%s_", withSeeSummary("Comment truncated.", seeSummary))
+	length := utf8.RuneCountInString(note) + 2
+	for i, line := range commentStr {
+		length += utf8.RuneCountInString(line) + 1
+		if length > maxLength {
+			return append(commentStr[:i:i], "", note)
+		}
+	}
+	return commentStr
+}
+
+// Sentence pointing to the job summary, which has every flag. Empty on GitLab, which has no
+// job summary.
+func seeSummary(provider, runURL string) string {
+	switch {
+	case provider == lcr.ProviderGitLab:
+		return ""
+	case runURL == "":
+		return "See the job summary for all flags."
+	default:
+		return fmt.Sprintf("See the [job summary](%s) for all flags.", runURL)
+	}
+}
+
+// Follow a note about left out flags with where to find them, if there is somewhere to look
+func withSeeSummary(note, seeSummary string) string {
+	if seeSummary == "" {
+		return note
+	}
+	return note + " " + seeSummary
+}
@@ -0,0 +1,134 @@
+package comments
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"text/template"
+
+	sprig "github.com/Masterminds/sprig/v3"
+
+	gha "github.com/launchdarkly/find-code-references-in-pull-request/internal/github_actions"
+	refs "github.com/launchdarkly/find-code-references-in-pull-request/internal/references"
+	"github.com/launchdarkly/find-code-references-in-pull-request/internal/scan"
+	"github.com/launchdarkly/find-code-references-in-pull-request/internal/utils"
+)
+
+// Data passed to a user-supplied comment template
+type TemplateData struct {
+	References   refs.ReferenceSummary // references across all projects
+	FlagsAdded   []Comment
+	FlagsRemoved []Comment
+	Projects     []ProjectTemplateData
+	TableHeader  string // header of the default flag table
+	Metadata     Metadata
+}
+
+// Flags found in a single project
+type ProjectTemplateData struct {
+	Key          string
+	References   refs.ReferenceSummary
+	FlagsAdded   []Comment
+	FlagsRemoved []Comment
+}
+
+// Details of the run
+type Metadata struct {
+	Owner        string
+	Repo         string
+	RepoURL      string // web URL of the repository, empty if unknown
+	PullRequest  int    // 0 when not triggered by a pull request
+	HeadSha      string
+	RunURL       string // web URL of the workflow run, empty if unknown
+	Provider     string // code host, "github" or "gitlab"
+	LdInstance   string
+	Projects     []string
+	Environments []string
+	Version      string
+}
+
+// Parse the comment template at path. Templates are rendered as markdown with
+// text/template, with the sprig functions and `pluralize` available.
+func LoadTemplate(path string) (*template.Template, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading comment template: %w", err)
+	}
+
+	tmpl, err := template.New("comment").
+		Funcs(sprig.TxtFuncMap()).
+		Funcs(template.FuncMap{"trim": strings.TrimSpace, "isNil": isNil, "pluralize": utils.Pluralize}).
+		Option("missingkey=error").
+		Parse(string(b))
+	if err != nil {
+		return nil, fmt.Errorf("parsing comment template: %w", err)
+	}
+	return tmpl, nil
+}
+
+// Build the data for a comment template from the scanned projects
+func NewTemplateData(projects []scan.Project, metadata Metadata) TemplateData {
+	data := TemplateData{
+		References:   scan.MergeReferences(projects),
+		FlagsAdded:   make([]Comment, 0),
+		FlagsRemoved: make([]Comment, 0),
+		Projects:     make([]ProjectTemplateData, 0, len(projects)),
+		Metadata:     metadata,
+	}
+	var environments []string
+	if len(metadata.Environments) > 1 {
+		environments = metadata.Environments
+	}
+	data.TableHeader = tableHeader(environments, metadata.RepoURL != "" && metadata.HeadSha != "")
+
+	for _, p := range projects {
+		added, removed := flagComments(p.References, p.Flags, p.Config)
+		data.FlagsAdded = append(data.FlagsAdded, added...)
+		data.FlagsRemoved = append(data.FlagsRemoved, removed...)
+		data.Projects = append(data.Projects, ProjectTemplateData{
+			Key:          p.Key,
+			References:   p.References,
+			FlagsAdded:   added,
+			FlagsRemoved: removed,
+		})
+	}
+
+	return data
+}
+
+// Render the comment template
+func RenderTemplate(tmpl *template.Template, data TemplateData) (string, error) {
+	var body bytes.Buffer
+	if err := tmpl.Execute(&body, data); err != nil {
+		return "", fmt.Errorf("rendering comment template: %w", err)
+	}
+	return body.String(), nil
+}
+
+// Build the PR comment from the comment template. Comments longer than the provider allows are
+// truncated at a line. Returns an empty comment if the existing comment is unchanged.
+func BuildTemplateComment(tmpl *template.Template, data TemplateData, existingComment string) (string, error) {
+	body, err := RenderTemplate(tmpl, data)
+	if err != nil {
+		return "", err
+	}
+	commentStr := []string{strings.TrimRight(body, "\n")}
+	if !strings.Contains(body, commentTitle) {
+		// used to find the existing comment
+		commentStr = append(commentStr, fmt.Sprintf(" <!-- %s -->", commentTitle))
+	}
+	allFlagKeys := uniqueFlagKeys(data.References.FlagsAdded, data.References.FlagsRemoved)
+	maxLength := maxCommentLength(data.Metadata.Provider)
+	if !fits(commentStr, allFlagKeys, maxLength) {
+		// the template's layout can't be changed, so the comment is cut short instead
+		gha.SetNotice("%s", withSeeSummary(fmt.Sprintf("Comment is longer than %d characters and has been truncated.", maxLength), seeSummary(data.Metadata.Provider, "")))
+		if flagsMarkerLength(allFlagKeys) > maxLength/2 {
+			gha.Log("Comment is too long to list the flags referenced, every flag will be checked for stale flag links")
+			allFlagKeys = nil
+		}
+		lines := strings.Split(strings.Join(commentStr, "\n"), "\n")
+		commentStr = truncateLines(lines, maxLength-markerLength-flagsMarkerLength(allFlagKeys), seeSummary(data.Metadata.Provider, data.Metadata.RunURL))
+	}
+	return withMarkers(commentStr, allFlagKeys, existingComment), nil
+}
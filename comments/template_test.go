@@ -0,0 +1,121 @@
+package comments
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	ldapi "github.com/launchdarkly/api-client-go/v15"
+	"github.com/launchdarkly/find-code-references-in-pull-request/config"
+	refs "github.com/launchdarkly/find-code-references-in-pull-request/internal/references"
+	"github.com/launchdarkly/find-code-references-in-pull-request/internal/scan"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeTemplate(t *testing.T, body string) string {
+	path := filepath.Join(t.TempDir(), "comment.tmpl")
+	require.NoError(t, os.WriteFile(path, []byte(body), 0o644))
+	return path
+}
+
+func newTemplateProjects() []scan.Project {
+	return []scan.Project{{
+		Key: "default",
+		Config: &config.Config{
+			LdProject:        "default",
+			LdEnvironment:    "production",
+			LdInstance:       "https://example.com/",
+			CheckExtinctions: true,
+		},
+		Flags: []ldapi.FeatureFlag{createFlag("example-flag"), createFlag("old-flag")},
+		References: refs.ReferenceSummary{
+			FlagsAdded:   refs.FlagAliasMap{"example-flag": {"exampleFlag"}},
+			FlagsRemoved: refs.FlagAliasMap{"old-flag": {}},
+			ExtinctFlags: map[string]struct{}{"old-flag": {}},
+			References: refs.FlagReferenceMap{
+				"example-flag": {{Path: "main.go", Line: 3}},
+			},
+		},
+	}}
+}
+
+func TestLoadTemplate(t *testing.T) {
+	_, err := LoadTemplate(writeTemplate(t, "{{ .FlagsAdded "))
+	assert.ErrorContains(t, err, "parsing comment template")
+
+	_, err = LoadTemplate(filepath.Join(t.TempDir(), "missing.tmpl"))
+	assert.ErrorContains(t, err, "reading comment template")
+}
+
+func TestRenderTemplate(t *testing.T) {
+	tmpl, err := LoadTemplate(writeTemplate(t, `## Flags in #{{ .Metadata.PullRequest }}
+{{ pluralize "flag" (len .FlagsAdded) }} added
+{{ range .FlagsAdded }}- {{ .FlagKey | upper }} ({{ .ProjectKey }}){{ range .Locations }} {{ .Path }}:{{ .Line }}{{ end }}
+{{ end }}{{ range .FlagsRemoved }}- removed {{ .FlagKey }}{{ if .Extinct }}, all references removed{{ end }}
+{{ end }}
+{{ .TableHeader }}
+{{ range .FlagsAdded }}{{ .Row }}
+{{ end }}`))
+	require.NoError(t, err)
+
+	data := NewTemplateData(newTemplateProjects(), Metadata{PullRequest: 7, Environments: []string{"production"}})
+	body, err := RenderTemplate(tmpl, data)
+	require.NoError(t, err)
+
+	expected := "## Flags in #7\n" +
+		"1 flag added\n" +
+		"- EXAMPLE-FLAG (default) main.go:3\n" +
+		"- removed old-flag, all references removed\n" +
+		"\n" +
+		"| Name | Key | Aliases found | Info |\n| --- | --- | --- | --- |\n" +
+		"| [example flag](https://example.com/test) | `example-flag` | `exampleFlag` | |\n"
+	assert.Equal(t, expected, body)
+
+	_, err = RenderTemplate(tmpl, TemplateData{Metadata: Metadata{}})
+	assert.NoError(t, err)
+}
+
+func TestRenderTemplate_executionError(t *testing.T) {
+	tmpl, err := LoadTemplate(writeTemplate(t, "{{ .Metadata.Unknown }}"))
+	require.NoError(t, err)
+
+	_, err = RenderTemplate(tmpl, NewTemplateData(newTemplateProjects(), Metadata{}))
+	assert.ErrorContains(t, err, "rendering comment template")
+}
+
+func TestBuildTemplateComment(t *testing.T) {
+	tmpl, err := LoadTemplate(writeTemplate(t, "Custom comment\n"))
+	require.NoError(t, err)
+	data := NewTemplateData(newTemplateProjects(), Metadata{})
+
+	comment, err := BuildTemplateComment(tmpl, data, "")
+	require.NoError(t, err)
+	expected := "Custom comment\n <!-- LaunchDarkly flag references -->\n <!-- flags:example-flag,old-flag -->\n <!-- comment hash: "
+	assert.True(t, strings.HasPrefix(comment, expected), comment)
+
+	// unchanged comment is not posted again
+	comment, err = BuildTemplateComment(tmpl, data, comment)
+	require.NoError(t, err)
+	assert.Empty(t, comment)
+}
+
+func TestBuildTemplateComment_tooLong(t *testing.T) {
+	tmpl, err := LoadTemplate(writeTemplate(t, "{{ range until 2000 }}{{ repeat 50 \"x\" }}\n{{ end }}"))
+	require.NoError(t, err)
+	data := NewTemplateData(newTemplateProjects(), Metadata{})
+
+	comment, err := BuildTemplateComment(tmpl, data, "")
+	require.NoError(t, err)
+	assert.LessOrEqual(t, utf8.RuneCountInString(comment), MaxCommentLength)
+	assert.Contains(t, comment, strings.Repeat("x", 50)+"\n\n_Comment truncated. See the job summary for all flags._\n <!-- flags:example-flag,old-flag -->")
+
+	// GitLab notes can be longer
+	data.Metadata.Provider = config.ProviderGitLab
+	comment, err = BuildTemplateComment(tmpl, data, "")
+	require.NoError(t, err)
+	assert.Greater(t, utf8.RuneCountInString(comment), MaxCommentLength)
+	assert.NotContains(t, comment, "_Comment truncated.")
+}
@@ -3,39 +3,79 @@ package config
 import (
 	"context"
 	"errors"
+	"net/http"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/google/go-github/v68/github"
 
 	"golang.org/x/oauth2"
 
 	gha "github.com/launchdarkly/find-code-references-in-pull-request/internal/github_actions"
+	"github.com/launchdarkly/find-code-references-in-pull-request/internal/httpclient"
+)
+
+// Code hosts the action can run on
+const (
+	ProviderGitHub = "github"
+	ProviderGitLab = "gitlab"
 )
 
 type Config struct {
-	LdProject            string
-	LdEnvironment        string
-	LdInstance           string
-	Owner                string
-	Repo                 string
-	ApiToken             string
-	Workspace            string
-	GHClient             *github.Client
-	MaxFlags             int
-	PlaceholderComment   bool
-	IncludeArchivedFlags bool
-	CheckExtinctions     bool
-	CreateFlagLinks      bool
+	Provider              string // code host of the repository, ProviderGitHub or ProviderGitLab
+	LdProject             string
+	LdProjects            []string // all project keys to scan, LdProject is the first
+	LdEnvironment         string
+	LdEnvironments        []string // all environment keys to show, LdEnvironment is the first
+	LdInstance            string
+	Owner                 string
+	Repo                  string
+	RepoURL               string // web URL of the repository, for links to references
+	HeadSha               string // commit scanned, for links to references. Empty if unknown.
+	BaseSha               string // commit the change is compared to, searched for flags that were already referenced. Empty if unknown.
+	RunURL                string // web URL of the workflow run, for links to the job summary. Empty if unknown.
+	ApiToken              string
+	Workspace             string
+	GHClient              *github.Client // nil on GitLab
+	GitLabAPIURL          string         // base URL of the GitLab REST API, such as https://gitlab.com/api/v4
+	GitLabProjectID       string         // project the pipeline runs in
+	GitLabToken           string         // token with the api scope, for merge request notes and commit statuses
+	HTTPClient            *http.Client   // client for LaunchDarkly and GitLab API requests, retrying failed and rate limited requests
+	MaxFlags              int
+	PlaceholderComment    bool
+	InstanceID            string // identifies the PR comment, so each workflow can own one
+	IncludeArchivedFlags  bool
+	CheckExtinctions      bool
+	CheckIntroductions    bool
+	CreateFlagLinks       bool
+	FlagsPageSize         int
+	MaxFlagPages          int
+	ReviewComments        bool
+	PrComment             bool
+	CheckRun              bool
+	CheckRunConclusion    string
+	FailOnArchivedAdded   bool
+	FailOnDeprecatedAdded bool
+	DetectUnknownFlags    bool
+	FailOnUnknownFlags    bool
+	BaseRef               string
+	HeadRef               string
+	ReportPath            string
+	ReportFormat          string
+	FlagsFile             string // JSON export or snapshot directory to read flags from instead of the API
+	CommentTemplate       string // path to a template for the PR comment
+	Concurrency           int    // number of files to scan at once, 0 for one per CPU
 }
 
 func ValidateInputandParse(ctx context.Context) (*Config, error) {
 	// mask tokens
-	if accessToken := os.Getenv("INPUT_ACCESS-TOKEN"); len(accessToken) > 0 {
+	if accessToken := getInput("access-token"); len(accessToken) > 0 {
 		gha.MaskInput(accessToken)
 	}
-	if repoToken := os.Getenv("INPUT_REPO-TOKEN"); len(repoToken) > 0 {
+	if repoToken := getInput("repo-token"); len(repoToken) > 0 {
 		gha.MaskInput(repoToken)
 	}
 
@@ -44,73 +84,330 @@ func ValidateInputandParse(ctx context.Context) (*Config, error) {
 		MaxFlags:             5,
 		IncludeArchivedFlags: true,
 		CheckExtinctions:     true,
+		CheckIntroductions:   true,
+		FlagsPageSize:        100,
+		MaxFlagPages:         100,
+		PrComment:            true,
+		CheckRunConclusion:   "neutral",
+		ReportFormat:         "json",
+		InstanceID:           "default",
+		Provider:             ProviderGitHub,
+	}
+	if os.Getenv("GITLAB_CI") == "true" {
+		config.Provider = ProviderGitLab
 	}
 
-	config.LdProject = os.Getenv("INPUT_PROJECT-KEY")
-	if config.LdProject == "" {
+	config.LdProjects = SplitList(getInput("project-key"))
+	if len(config.LdProjects) == 0 {
 		return nil, errors.New("`project-key` is required")
 	}
-	if envKey := os.Getenv("INPUT_ENVIRONMENT-KEY"); len(envKey) == 0 {
+	config.LdProject = config.LdProjects[0]
+	config.LdEnvironments = SplitList(getInput("environment-key"))
+	if len(config.LdEnvironments) == 0 {
 		return nil, errors.New("`environment-key` is required")
-	} else if strings.Contains(envKey, ",") {
-		return nil, errors.New("only one `environment-key` is allowed")
-	} else {
-		config.LdEnvironment = envKey
 	}
+	config.LdEnvironment = config.LdEnvironments[0]
 
-	config.LdInstance = os.Getenv("INPUT_BASE-URI")
+	config.LdInstance = getInput("base-uri")
 	if config.LdInstance == "" {
 		return nil, errors.New("`base-uri` is required.")
 	}
-	config.Owner = os.Getenv("GITHUB_REPOSITORY_OWNER")
-	config.Repo = strings.Split(os.Getenv("GITHUB_REPOSITORY"), "/")[1]
 
-	config.ApiToken = os.Getenv("INPUT_ACCESS-TOKEN")
-	if config.ApiToken == "" {
+	if config.Provider == ProviderGitLab {
+		if err := parseGitLabEnv(&config); err != nil {
+			return nil, err
+		}
+	} else {
+		parseGitHubEnv(&config)
+	}
+
+	if flagsFile := getInput("flags-file"); flagsFile != "" {
+		// relative to the repository
+		if !filepath.IsAbs(flagsFile) {
+			flagsFile = filepath.Join(config.Workspace, flagsFile)
+		}
+		config.FlagsFile = flagsFile
+	}
+
+	// flags can be read from flags-file without calling LaunchDarkly
+	config.ApiToken = getInput("access-token")
+	if config.ApiToken == "" && config.FlagsFile == "" {
 		return nil, errors.New("`access-token` is required")
 	}
 
-	config.Workspace = os.Getenv("GITHUB_WORKSPACE")
+	config.BaseRef = getInput("base-ref")
+	config.HeadRef = getInput("head-ref")
 
-	maxFlags, err := strconv.ParseInt(os.Getenv("INPUT_MAX-FLAGS"), 10, 32)
-	if err != nil {
-		return nil, err
+	if limit := getInput("max-flags"); limit != "" {
+		maxFlags, err := strconv.ParseInt(limit, 10, 32)
+		if err != nil {
+			return nil, err
+		}
+		if maxFlags < 0 {
+			return nil, errors.New("`max-flags` must not be negative")
+		}
+		// 0 shows all flags
+		config.MaxFlags = int(maxFlags)
 	}
-	config.MaxFlags = int(maxFlags)
 
-	if placholderComment, err := strconv.ParseBool(os.Getenv("INPUT_PLACEHOLDER-COMMENT")); err == nil {
+	if placholderComment, err := strconv.ParseBool(getInput("placeholder-comment")); err == nil {
 		// ignore error - default is false
 		config.PlaceholderComment = placholderComment
 	}
 
-	if includeArchivedFlags, err := strconv.ParseBool(os.Getenv("INPUT_INCLUDE-ARCHIVED-FLAGS")); err == nil {
+	if instanceID := getInput("instance-id"); instanceID != "" {
+		if strings.ContainsAny(instanceID, " \t\r\n") || strings.Contains(instanceID, "--") {
+			return nil, errors.New("`instance-id` must not contain whitespace or `--`")
+		}
+		config.InstanceID = instanceID
+	}
+
+	if includeArchivedFlags, err := strconv.ParseBool(getInput("include-archived-flags")); err == nil {
 		// ignore error - default is true
 		config.IncludeArchivedFlags = includeArchivedFlags
 	}
 
-	if checkExtinctions, err := strconv.ParseBool(os.Getenv("INPUT_CHECK-EXTINCTIONS")); err == nil {
+	if checkExtinctions, err := strconv.ParseBool(getInput("check-extinctions")); err == nil {
 		// ignore error - default is true
 		config.CheckExtinctions = checkExtinctions
 	}
 
-	if createFlagLinks, err := strconv.ParseBool(os.Getenv("INPUT_CREATE-FLAG-LINKS")); err == nil {
+	if checkIntroductions, err := strconv.ParseBool(getInput("check-introductions")); err == nil {
+		// ignore error - default is true
+		config.CheckIntroductions = checkIntroductions
+	}
+
+	if createFlagLinks, err := strconv.ParseBool(getInput("create-flag-links")); err == nil {
 		// ignore error - default is false
 		config.CreateFlagLinks = createFlagLinks
 	}
+	if config.CreateFlagLinks && config.ApiToken == "" {
+		gha.Debug("Not creating flag links without an access token")
+		config.CreateFlagLinks = false
+	}
+	if config.CreateFlagLinks && config.Provider == ProviderGitLab {
+		gha.Debug("Flag links are only created for GitHub pull requests")
+		config.CreateFlagLinks = false
+	}
 
-	client, err := getGithubClient(ctx)
-	if err != nil {
-		return nil, err
+	if reviewComments, err := strconv.ParseBool(getInput("review-comments")); err == nil {
+		// ignore error - default is false
+		config.ReviewComments = reviewComments
+	}
+	if config.ReviewComments && config.Provider == ProviderGitLab {
+		gha.SetWarning("`review-comments` is only supported on GitHub")
+		config.ReviewComments = false
+	}
+
+	if prComment, err := strconv.ParseBool(getInput("pr-comment")); err == nil {
+		// ignore error - default is true
+		config.PrComment = prComment
+	}
+
+	if checkRun, err := strconv.ParseBool(getInput("check-run")); err == nil {
+		// ignore error - default is false
+		config.CheckRun = checkRun
+	}
+
+	if conclusion := getInput("check-run-conclusion"); conclusion != "" {
+		switch conclusion {
+		case "success", "neutral", "failure", "action_required":
+			config.CheckRunConclusion = conclusion
+		default:
+			return nil, errors.New("`check-run-conclusion` must be one of: success, neutral, failure, action_required")
+		}
+	}
+
+	if failOnArchived, err := strconv.ParseBool(getInput("fail-on-archived-added")); err == nil {
+		// ignore error - default is false
+		config.FailOnArchivedAdded = failOnArchived
+	}
+
+	if failOnDeprecated, err := strconv.ParseBool(getInput("fail-on-deprecated-added")); err == nil {
+		// ignore error - default is false
+		config.FailOnDeprecatedAdded = failOnDeprecated
+	}
+
+	if detectUnknown, err := strconv.ParseBool(getInput("detect-unknown-flags")); err == nil {
+		// ignore error - default is false
+		config.DetectUnknownFlags = detectUnknown
+	}
+
+	if failOnUnknown, err := strconv.ParseBool(getInput("fail-on-unknown-flag-like-strings")); err == nil {
+		// ignore error - default is false
+		config.FailOnUnknownFlags = failOnUnknown
+		// unknown flags must be detected to fail on them
+		config.DetectUnknownFlags = config.DetectUnknownFlags || failOnUnknown
+	}
+
+	config.ReportPath = getInput("report-path")
+	if format := getInput("report-format"); format != "" {
+		switch format {
+		case "json", "sarif":
+			config.ReportFormat = format
+		default:
+			return nil, errors.New("`report-format` must be one of: json, sarif")
+		}
+	}
+
+	if commentTemplate := getInput("comment-template"); commentTemplate != "" {
+		// relative to the repository
+		if !filepath.IsAbs(commentTemplate) {
+			commentTemplate = filepath.Join(config.Workspace, commentTemplate)
+		}
+		config.CommentTemplate = commentTemplate
+	}
+
+	if pageSize := getInput("flags-page-size"); pageSize != "" {
+		flagsPageSize, err := strconv.ParseInt(pageSize, 10, 32)
+		if err != nil {
+			return nil, err
+		}
+		if flagsPageSize <= 0 {
+			return nil, errors.New("`flags-page-size` must be greater than 0")
+		}
+		config.FlagsPageSize = int(flagsPageSize)
+	}
+
+	if maxPages := getInput("max-flag-pages"); maxPages != "" {
+		maxFlagPages, err := strconv.ParseInt(maxPages, 10, 32)
+		if err != nil {
+			return nil, err
+		}
+		if maxFlagPages <= 0 {
+			return nil, errors.New("`max-flag-pages` must be greater than 0")
+		}
+		config.MaxFlagPages = int(maxFlagPages)
+	}
+
+	if concurrency := getInput("concurrency"); concurrency != "" {
+		workers, err := strconv.ParseInt(concurrency, 10, 32)
+		if err != nil {
+			return nil, err
+		}
+		if workers < 0 {
+			return nil, errors.New("`concurrency` must not be negative")
+		}
+		config.Concurrency = int(workers)
+	}
+
+	httpOptions := httpclient.DefaultOptions()
+	if timeout := getInput("http-timeout"); timeout != "" {
+		seconds, err := strconv.ParseInt(timeout, 10, 32)
+		if err != nil {
+			return nil, err
+		}
+		if seconds < 0 {
+			return nil, errors.New("`http-timeout` must not be negative")
+		}
+		httpOptions.Timeout = time.Duration(seconds) * time.Second
+	}
+
+	if retries := getInput("http-max-retries"); retries != "" {
+		maxRetries, err := strconv.ParseInt(retries, 10, 32)
+		if err != nil {
+			return nil, err
+		}
+		if maxRetries < 0 {
+			return nil, errors.New("`http-max-retries` must not be negative")
+		}
+		httpOptions.MaxRetries = int(maxRetries)
+	}
+
+	if wait := getInput("http-max-retry-wait"); wait != "" {
+		seconds, err := strconv.ParseInt(wait, 10, 32)
+		if err != nil {
+			return nil, err
+		}
+		if seconds < 0 {
+			return nil, errors.New("`http-max-retry-wait` must not be negative")
+		}
+		httpOptions.MaxRetryWait = time.Duration(seconds) * time.Second
+	}
+	config.HTTPClient = httpclient.NewClient(httpOptions)
+
+	if config.Provider == ProviderGitHub {
+		client, err := getGithubClient(ctx, config.HTTPClient)
+		if err != nil {
+			return nil, err
+		}
+		config.GHClient = client
 	}
-	config.GHClient = client
 
 	return &config, nil
 }
 
-func getGithubClient(ctx context.Context) (*github.Client, error) {
+// Read an input from its INPUT_ environment variable, as set by GitHub Actions. GitLab CI
+// variables can't contain hyphens, so INPUT_MAX_FLAGS is read if INPUT_MAX-FLAGS isn't set.
+func getInput(name string) string {
+	key := "INPUT_" + strings.ToUpper(name)
+	if value, ok := os.LookupEnv(key); ok {
+		return value
+	}
+	return os.Getenv(strings.ReplaceAll(key, "-", "_"))
+}
+
+func parseGitHubEnv(config *Config) {
+	config.Owner = os.Getenv("GITHUB_REPOSITORY_OWNER")
+	config.Repo = strings.Split(os.Getenv("GITHUB_REPOSITORY"), "/")[1]
+	serverURL := os.Getenv("GITHUB_SERVER_URL")
+	if serverURL == "" {
+		serverURL = "https://github.com"
+	}
+	config.RepoURL = strings.TrimSuffix(serverURL, "/") + "/" + os.Getenv("GITHUB_REPOSITORY")
+	if runID := os.Getenv("GITHUB_RUN_ID"); runID != "" {
+		config.RunURL = config.RepoURL + "/actions/runs/" + runID
+	}
+
+	config.Workspace = os.Getenv("GITHUB_WORKSPACE")
+}
+
+// Read the predefined GitLab CI variables
+func parseGitLabEnv(config *Config) error {
+	config.Owner = os.Getenv("CI_PROJECT_NAMESPACE")
+	config.Repo = os.Getenv("CI_PROJECT_NAME")
+	config.RepoURL = os.Getenv("CI_PROJECT_URL")
+	config.RunURL = os.Getenv("CI_PIPELINE_URL")
+	config.Workspace = os.Getenv("CI_PROJECT_DIR")
+
+	config.GitLabAPIURL = strings.TrimSuffix(os.Getenv("CI_API_V4_URL"), "/")
+	config.GitLabProjectID = os.Getenv("CI_PROJECT_ID")
+	if config.GitLabAPIURL == "" || config.GitLabProjectID == "" {
+		return errors.New("`CI_API_V4_URL` and `CI_PROJECT_ID` must be set when running on GitLab CI")
+	}
+
+	// CI_JOB_TOKEN can't write merge request notes or commit statuses
+	config.GitLabToken = os.Getenv("GITLAB_TOKEN")
+	if config.GitLabToken != "" {
+		gha.MaskInput(config.GitLabToken)
+	}
+	return nil
+}
+
+// Split a comma-separated input, dropping empty entries
+func SplitList(s string) []string {
+	items := make([]string, 0)
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// Copy of the config scoped to a single project
+func (c Config) ForProject(projectKey string) *Config {
+	c.LdProject = projectKey
+	c.LdProjects = []string{projectKey}
+	return &c
+}
+
+func getGithubClient(ctx context.Context, httpClient *http.Client) (*github.Client, error) {
 	ts := oauth2.StaticTokenSource(
 		&oauth2.Token{AccessToken: os.Getenv("GITHUB_TOKEN")},
 	)
+	// requests are authenticated on top of the retrying transport
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, httpClient)
 	tc := oauth2.NewClient(ctx, ts)
 	gc := github.NewClient(tc)
 
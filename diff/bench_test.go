@@ -0,0 +1,97 @@
+package diff
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	gha "github.com/launchdarkly/find-code-references-in-pull-request/internal/github_actions"
+	refs "github.com/launchdarkly/find-code-references-in-pull-request/internal/references"
+	lsearch "github.com/launchdarkly/ld-find-code-refs/v2/search"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Build a raw diff of the given number of files, referencing the given number of flags
+func syntheticDiff(files, flags int) ([]string, string) {
+	flagKeys := make([]string, 0, flags)
+	for i := range flags {
+		flagKeys = append(flagKeys, fmt.Sprintf("flag-%d", i))
+	}
+
+	var rawDiff strings.Builder
+	for i := range files {
+		path := fmt.Sprintf("src/pkg%d/file%d.go", i%50, i)
+		fmt.Fprintf(&rawDiff, "diff --git a/%s b/%s\nindex 0000000..1111111 100644\n--- a/%s\n+++ b/%s\n@@ -1,30 +1,30 @@\n", path, path, path, path)
+		for line := range 40 {
+			switch line % 4 {
+			case 0:
+				fmt.Fprintf(&rawDiff, "+  if client.BoolVariation(%q, ctx, false) {\n", flagKeys[(i+line)%flags])
+			case 1:
+				fmt.Fprintf(&rawDiff, "-  enabled := client.BoolVariation(%q, ctx, false)\n", flagKeys[(i*7+line)%flags])
+			default:
+				fmt.Fprintf(&rawDiff, "   log.Printf(\"unrelated line %d in file %d\")\n", line, i)
+			}
+		}
+	}
+	return flagKeys, rawDiff.String()
+}
+
+func scanSyntheticDiff(matcher lsearch.Matcher, rawDiff, dir string, concurrency int) (refs.ReferenceSummary, error) {
+	builder := refs.NewReferenceSummaryBuilder(false, false)
+	err := ReadDiffs(strings.NewReader(rawDiff), dir, concurrency, func(_ string, file *DiffFile) {
+		ProcessDiffs(matcher, file, builder)
+	})
+	return builder.Build(), err
+}
+
+func TestReadDiffs_concurrency(t *testing.T) {
+	gha.SetLogOutput(io.Discard)
+	t.Cleanup(func() { gha.SetLogOutput(os.Stdout) })
+
+	flagKeys, rawDiff := syntheticDiff(200, 50)
+	matcher := lsearch.Matcher{Elements: []lsearch.ElementMatcher{
+		lsearch.NewElementMatcher("default", "", `"`, flagKeys, map[string][]string{}),
+	}}
+	dir := t.TempDir()
+
+	expected, err := scanSyntheticDiff(matcher, rawDiff, dir, 1)
+	require.NoError(t, err)
+	assert.Len(t, expected.FlagsAdded, 50)
+
+	// results are the same however many files are scanned at once
+	for _, concurrency := range []int{0, 8, 1000} {
+		flagsRef, err := scanSyntheticDiff(matcher, rawDiff, dir, concurrency)
+		require.NoError(t, err)
+		assert.Equal(t, expected, flagsRef, "concurrency %d", concurrency)
+	}
+
+	flagsRef, err := scanSyntheticDiff(matcher, "", dir, 4)
+	require.NoError(t, err)
+	assert.False(t, flagsRef.AnyFound())
+}
+
+// go test ./diff -run '^$' -bench ReadDiffs -benchmem
+func BenchmarkReadDiffs(b *testing.B) {
+	gha.SetLogOutput(io.Discard)
+	b.Cleanup(func() { gha.SetLogOutput(os.Stdout) })
+
+	flagKeys, rawDiff := syntheticDiff(5000, 2000)
+	matcher := lsearch.Matcher{Elements: []lsearch.ElementMatcher{
+		lsearch.NewElementMatcher("default", "", `"'`+"`", flagKeys, map[string][]string{}),
+	}}
+	dir := b.TempDir()
+
+	for _, concurrency := range []int{1, 2, 4, 8, 0} {
+		b.Run(fmt.Sprintf("concurrency=%d", concurrency), func(b *testing.B) {
+			b.SetBytes(int64(len(rawDiff)))
+			for b.Loop() {
+				if _, err := scanSyntheticDiff(matcher, rawDiff, dir, concurrency); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -2,93 +2,246 @@ package diff
 
 import (
 	"fmt"
+	"io"
 	"os"
+	"path/filepath"
+	"runtime"
 	"strings"
+	"sync"
 
 	i "github.com/launchdarkly/find-code-references-in-pull-request/ignore"
 	gha "github.com/launchdarkly/find-code-references-in-pull-request/internal/github_actions"
 	refs "github.com/launchdarkly/find-code-references-in-pull-request/internal/references"
+	"github.com/launchdarkly/find-code-references-in-pull-request/internal/unknownflags"
+	"github.com/launchdarkly/find-code-references-in-pull-request/internal/utils"
 	diff_util "github.com/launchdarkly/find-code-references-in-pull-request/internal/utils/diff_util"
 	"github.com/launchdarkly/ld-find-code-refs/v2/aliases"
 	lsearch "github.com/launchdarkly/ld-find-code-refs/v2/search"
 	"github.com/sourcegraph/go-diff/diff"
 )
 
-func PreprocessDiffs(dir string, multiFiles []*diff.FileDiff) aliases.FileContentsMap {
-	diffMap := make(map[string][]byte, len(multiFiles))
+// A file in the diff along with the hunks to scan for references
+type DiffFile struct {
+	Path     string // path relative to the workspace
+	OrigPath string // path before the file was renamed or copied, empty otherwise
+	Hunks    []*diff.Hunk
+}
 
-	for _, parsedDiff := range multiFiles {
-		filePath, ignore := checkDiffFile(parsedDiff, dir)
-		if ignore {
-			continue
+// Diff files keyed by their full path in the workspace
+type DiffFileMap map[string]*DiffFile
+
+// Concatenated hunk bodies keyed by full path, used to generate aliases from file patterns
+func (m DiffFileMap) Contents() aliases.FileContentsMap {
+	contents := make(aliases.FileContentsMap, len(m))
+	for filePath, file := range m {
+		contents[filePath] = make([]byte, 0)
+		for _, hunk := range file.Hunks {
+			contents[filePath] = append(contents[filePath], hunk.Body...)
+		}
+	}
+	return contents
+}
+
+// Diff files in dir, relative to the workspace
+func (m DiffFileMap) InDir(dir string) DiffFileMap {
+	filtered := make(DiffFileMap, len(m))
+	for filePath, file := range m {
+		if utils.InDir(file.Path, dir) {
+			filtered[filePath] = file
 		}
+	}
+	return filtered
+}
+
+// Read the diff from r file by file, calling process with each file to scan on up to
+// concurrency goroutines. A concurrency of 0 or less uses one goroutine per CPU.
+// Files are only kept in memory until they are processed.
+func ReadDiffs(r io.Reader, dir string, concurrency int, process func(filePath string, file *DiffFile)) error {
+	if concurrency <= 0 {
+		concurrency = runtime.NumCPU()
+	}
+
+	type pendingFile struct {
+		filePath string
+		file     *DiffFile
+	}
+	files := make(chan pendingFile)
+	var wg sync.WaitGroup
+	for range concurrency {
+		wg.Go(func() {
+			for f := range files {
+				process(f.filePath, f.file)
+			}
+		})
+	}
+
+	err := readDiffs(r, dir, func(filePath string, file *DiffFile) {
+		files <- pendingFile{filePath, file}
+	})
+	close(files)
+	wg.Wait()
+	return err
+}
 
-		if _, ok := diffMap[filePath]; !ok {
-			diffMap[filePath] = make([]byte, 0)
+func readDiffs(r io.Reader, dir string, send func(filePath string, file *DiffFile)) error {
+	reader := diff.NewMultiFileDiffReader(r)
+	count := 0
+	for {
+		parsedDiff, err := reader.ReadFile()
+		if err == io.EOF {
+			gha.Debug("Got %d diff files", count)
+			return nil
+		}
+		if err != nil {
+			return err
 		}
+		count++
 
-		for _, hunk := range parsedDiff.Hunks {
-			diffMap[filePath] = append(diffMap[filePath], hunk.Body...)
+		filePath, ignore := checkDiffFile(parsedDiff, dir)
+		if ignore {
+			continue
 		}
+		send(filePath, &DiffFile{
+			Path:     relativePath(dir, filePath),
+			OrigPath: renamedFrom(parsedDiff),
+			Hunks:    parsedDiff.Hunks,
+		})
 	}
+}
 
-	return diffMap
+func relativePath(dir, filePath string) string {
+	rel, err := filepath.Rel(dir, filePath)
+	if err != nil {
+		return filePath
+	}
+	return filepath.ToSlash(rel)
 }
 
 func checkDiffFile(parsedDiff *diff.FileDiff, workspace string) (filePath string, ignore bool) {
+	// Nothing to scan for pure renames, copies and mode changes, which may not have
+	// file names. Files that are renamed and edited are scanned under their new path.
+	if len(parsedDiff.Hunks) == 0 {
+		return "", true
+	}
+
 	allIgnores := i.NewIgnore(workspace)
 
-	// If file is being renamed we don't want to check it for flags.
 	parsedFileA := strings.SplitN(parsedDiff.OrigName, "/", 2)
 	parsedFileB := strings.SplitN(parsedDiff.NewName, "/", 2)
 	fullPathToA := workspace + "/" + parsedFileA[1]
 	fullPathToB := workspace + "/" + parsedFileB[1]
 	info, err := os.Stat(fullPathToB)
 	if err != nil {
-		fmt.Println(err)
+		gha.Debug("%s", err)
 	}
 	var isDir bool
-	// If there is no 'b' parse 'a', means file is deleted.
-	if info == nil {
-		isDir = false
-		filePath = fullPathToA
-	} else {
+	switch {
+	case info != nil:
 		isDir = info.IsDir()
 		filePath = fullPathToB
+	case renamedFrom(parsedDiff) != "":
+		// renamed files use their new path, even if it isn't checked out
+		filePath = fullPathToB
+	default:
+		// If there is no 'b' parse 'a', means file is deleted.
+		filePath = fullPathToA
 	}
 	// Similar to ld-find-code-refs do not match dotfiles, and read in ignore files.
 	if strings.HasPrefix(parsedFileB[1], ".") && strings.HasPrefix(parsedFileA[1], ".") || allIgnores.Match(filePath, isDir) {
 		return filePath, true
 	}
-	// We don't want to run on renaming of files.
-	if (parsedFileA[1] != parsedFileB[1]) && (!strings.Contains(parsedFileB[1], "dev/null") && !strings.Contains(parsedFileA[1], "dev/null")) {
-		return filePath, true
-	}
-
 	return filePath, false
 }
 
-func ProcessDiffs(matcher lsearch.Matcher, contents []byte, builder *refs.ReferenceSummaryBuilder) {
-	if builder.MaxReferences() {
-		return
+// Path the file was renamed or copied from, relative to the workspace. Empty if the
+// file was not renamed or copied.
+func renamedFrom(parsedDiff *diff.FileDiff) string {
+	parsedFileA := strings.SplitN(parsedDiff.OrigName, "/", 2)
+	parsedFileB := strings.SplitN(parsedDiff.NewName, "/", 2)
+	if len(parsedFileA) < 2 || len(parsedFileB) < 2 || parsedFileA[1] == parsedFileB[1] {
+		return ""
 	}
+	if strings.Contains(parsedFileA[1], "dev/null") || strings.Contains(parsedFileB[1], "dev/null") {
+		return ""
+	}
+	return parsedFileA[1]
+}
 
-	diffLines := strings.Split(string(contents), "\n")
-	for _, line := range diffLines {
-		op := diff_util.LineOperation(line)
-		if op == diff_util.OperationEqual {
-			continue
-		}
+func ProcessDiffs(matcher lsearch.Matcher, file *DiffFile, builder *refs.ReferenceSummaryBuilder) {
+	for _, hunk := range file.Hunks {
+		processHunk(matcher, file, hunk, builder)
+	}
+}
 
-		// only one for now
-		elementMatcher := matcher.Elements[0]
+// Scan a single hunk for references to flags
+func processHunk(matcher lsearch.Matcher, file *DiffFile, hunk *diff.Hunk, builder *refs.ReferenceSummaryBuilder) {
+	// only one for now
+	elementMatcher := matcher.Elements[0]
+	changedLines(file, hunk, func(line string, op diff_util.Operation, location refs.ReferenceLocation) {
 		for _, flagKey := range elementMatcher.FindMatches(line) {
 			aliasMatches := elementMatcher.FindAliases(line, flagKey)
-			gha.Debug("Found (%s) reference to flag %s with aliases %v", op, flagKey, aliasMatches)
-			err := builder.AddReference(flagKey, op, aliasMatches)
+			gha.Debug("Found (%s) reference to flag %s at %s with aliases %v", op, flagKey, location, aliasMatches)
+			err := builder.AddReference(flagKey, op, aliasMatches, location)
 			if err != nil {
-				fmt.Println(err)
+				gha.LogError(err)
 			}
 		}
+	})
+}
+
+// Scan the added lines of the file for keys evaluated by SDK calls that don't match a flag
+func DetectUnknownFlags(detector *unknownflags.Detector, file *DiffFile, builder *refs.ReferenceSummaryBuilder) {
+	for _, hunk := range file.Hunks {
+		changedLines(file, hunk, func(line string, op diff_util.Operation, location refs.ReferenceLocation) {
+			if op != diff_util.OperationAdd {
+				return
+			}
+			for _, key := range detector.FindUnknownKeys(line) {
+				gha.Debug("Found unknown flag key %s at %s", key, location)
+				builder.AddUnknownFlag(key, detector.Suggest(key), location)
+			}
+		})
+	}
+}
+
+// Call fn with each added or removed line of the hunk and its location, tracking line
+// numbers in the original and new file from the hunk header
+func changedLines(file *DiffFile, hunk *diff.Hunk, fn func(line string, op diff_util.Operation, location refs.ReferenceLocation)) {
+	header := hunkHeader(hunk)
+	origLine, newLine := int(hunk.OrigStartLine), int(hunk.NewStartLine)
+
+	diffLines := strings.Split(strings.TrimSuffix(string(hunk.Body), "\n"), "\n")
+	for _, line := range diffLines {
+		// "\ No newline at end of file" markers are not part of either file
+		if strings.HasPrefix(line, "\\") {
+			continue
+		}
+
+		op := diff_util.LineOperation(line)
+		location := refs.ReferenceLocation{Path: file.Path, OrigPath: file.OrigPath, Hunk: header}
+		switch op {
+		case diff_util.OperationAdd:
+			location.Line = newLine
+			location.HeadLine = newLine
+			newLine++
+		case diff_util.OperationDelete:
+			location.Line = origLine
+			location.HeadLine = max(newLine, 1)
+			origLine++
+		default:
+			origLine++
+			newLine++
+			continue
+		}
+
+		fn(line, op, location)
+	}
+}
+
+func hunkHeader(hunk *diff.Hunk) string {
+	header := fmt.Sprintf("@@ -%d,%d +%d,%d @@", hunk.OrigStartLine, hunk.OrigLines, hunk.NewStartLine, hunk.NewLines)
+	if hunk.Section != "" {
+		header += " " + hunk.Section
 	}
+	return header
 }
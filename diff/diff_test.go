@@ -1,14 +1,21 @@
 package diff
 
 import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	ldapi "github.com/launchdarkly/api-client-go/v15"
 	"github.com/launchdarkly/find-code-references-in-pull-request/config"
 	refs "github.com/launchdarkly/find-code-references-in-pull-request/internal/references"
+	"github.com/launchdarkly/find-code-references-in-pull-request/internal/unknownflags"
+	"github.com/launchdarkly/find-code-references-in-pull-request/internal/utils/diff_util"
 	lsearch "github.com/launchdarkly/ld-find-code-refs/v2/search"
 	"github.com/sourcegraph/go-diff/diff"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func ptr[T any](t T) *T { return &t }
@@ -55,7 +62,7 @@ func newProcessFlagAccEnv() *testProcessor {
 	flags := ldapi.FeatureFlags{}
 	flags.Items = append(flags.Items, flag)
 	flags.Items = append(flags.Items, flag2)
-	builder := refs.NewReferenceSummaryBuilder(5, false)
+	builder := refs.NewReferenceSummaryBuilder(false, false)
 	config := config.Config{
 		LdEnvironment: "production",
 		LdInstance:    "https://example.com/",
@@ -67,6 +74,13 @@ func newProcessFlagAccEnv() *testProcessor {
 	}
 }
 
+func newDiffFile(path, body string) *DiffFile {
+	return &DiffFile{
+		Path:  path,
+		Hunks: []*diff.Hunk{{OrigStartLine: 1, NewStartLine: 1, Body: []byte(body)}},
+	}
+}
+
 func Test_checkDiffFile(t *testing.T) {
 	cases := []struct {
 		name     string
@@ -89,6 +103,13 @@ func Test_checkDiffFile(t *testing.T) {
 			newName:  "b/.testignore",
 			skip:     true,
 		},
+		{
+			name:     "renamed file uses new path",
+			fileName: "new_name.go",
+			origName: "a/old_name.go",
+			newName:  "b/new_name.go",
+			skip:     false,
+		},
 	}
 
 	for _, tc := range cases {
@@ -114,6 +135,12 @@ func Test_checkDiffFile(t *testing.T) {
 }
 
 func TestProcessDiffs_BuildReferences(t *testing.T) {
+	// every sample is a single hunk of the file "test"
+	location := func(line, headLine int, op diff_util.Operation) refs.ReferenceLocation {
+		return refs.ReferenceLocation{Path: "test", Line: line, HeadLine: headLine, Operation: op, Hunk: "@@ -1,0 +1,0 @@"}
+	}
+	add, remove := diff_util.OperationAdd, diff_util.OperationDelete
+
 	cases := []struct {
 		name       string
 		sampleBody string
@@ -126,6 +153,8 @@ func TestProcessDiffs_BuildReferences(t *testing.T) {
 			expected: refs.ReferenceSummary{
 				FlagsAdded:   refs.FlagAliasMap{"example-flag": []string{}},
 				FlagsRemoved: refs.FlagAliasMap{},
+				MovedFlags:   map[string]struct{}{},
+				References:   refs.FlagReferenceMap{"example-flag": {location(5, 5, add)}},
 			},
 			aliases: map[string][]string{},
 			sampleBody: `
@@ -142,6 +171,8 @@ func TestProcessDiffs_BuildReferences(t *testing.T) {
 			expected: refs.ReferenceSummary{
 				FlagsAdded:   refs.FlagAliasMap{},
 				FlagsRemoved: refs.FlagAliasMap{"example-flag": []string{}},
+				MovedFlags:   map[string]struct{}{},
+				References:   refs.FlagReferenceMap{"example-flag": {location(5, 3, remove)}},
 			},
 			aliases: map[string][]string{},
 			sampleBody: `
@@ -158,6 +189,11 @@ func TestProcessDiffs_BuildReferences(t *testing.T) {
 			expected: refs.ReferenceSummary{
 				FlagsAdded:   refs.FlagAliasMap{"sample-flag": []string{}},
 				FlagsRemoved: refs.FlagAliasMap{"example-flag": []string{}},
+				MovedFlags:   map[string]struct{}{},
+				References: refs.FlagReferenceMap{
+					"example-flag": {location(5, 3, remove)},
+					"sample-flag":  {location(3, 3, add)},
+				},
 			},
 			aliases: map[string][]string{},
 			sampleBody: `
@@ -175,6 +211,8 @@ func TestProcessDiffs_BuildReferences(t *testing.T) {
 			expected: refs.ReferenceSummary{
 				FlagsAdded:   refs.FlagAliasMap{"example-flag": []string{}},
 				FlagsRemoved: refs.FlagAliasMap{},
+				MovedFlags:   map[string]struct{}{},
+				References:   refs.FlagReferenceMap{"example-flag": {location(5, 3, remove), location(6, 6, add)}},
 			},
 			aliases: map[string][]string{},
 			sampleBody: `
@@ -193,6 +231,8 @@ func TestProcessDiffs_BuildReferences(t *testing.T) {
 			expected: refs.ReferenceSummary{
 				FlagsAdded:   refs.FlagAliasMap{"example-flag": []string{"exampleFlag"}},
 				FlagsRemoved: refs.FlagAliasMap{},
+				MovedFlags:   map[string]struct{}{},
+				References:   refs.FlagReferenceMap{"example-flag": {location(5, 5, add), location(6, 6, add)}},
 			},
 			aliases: map[string][]string{"example-flag": {"exampleFlag"}},
 			sampleBody: `
@@ -208,6 +248,8 @@ func TestProcessDiffs_BuildReferences(t *testing.T) {
 			expected: refs.ReferenceSummary{
 				FlagsAdded:   refs.FlagAliasMap{},
 				FlagsRemoved: refs.FlagAliasMap{},
+				MovedFlags:   map[string]struct{}{},
+				References:   refs.FlagReferenceMap{},
 			},
 			delimiters: "'\"",
 			aliases:    map[string][]string{},
@@ -223,6 +265,8 @@ func TestProcessDiffs_BuildReferences(t *testing.T) {
 			expected: refs.ReferenceSummary{
 				FlagsAdded:   refs.FlagAliasMap{"example-flag": []string{}},
 				FlagsRemoved: refs.FlagAliasMap{},
+				MovedFlags:   map[string]struct{}{},
+				References:   refs.FlagReferenceMap{"example-flag": {location(5, 5, add)}},
 			},
 			delimiters: "'\"",
 			aliases:    map[string][]string{},
@@ -243,51 +287,186 @@ func TestProcessDiffs_BuildReferences(t *testing.T) {
 			matcher := lsearch.Matcher{
 				Elements: elements,
 			}
-			ProcessDiffs(matcher, []byte(tc.sampleBody), processor.Builder)
+			ProcessDiffs(matcher, newDiffFile("test", tc.sampleBody), processor.Builder)
 			flagsRef := processor.Builder.Build()
 			assert.Equal(t, tc.expected, flagsRef)
 		})
 	}
 }
 
-func TestProcessDiffs_finishesFileBeforeMaxFlagsCap(t *testing.T) {
+func TestProcessDiffs_findsAllFlags(t *testing.T) {
 	processor := newProcessFlagAccEnv()
-	processor.Builder = refs.NewReferenceSummaryBuilder(1, false)
 
 	elements := []lsearch.ElementMatcher{
 		lsearch.NewElementMatcher("default", "", "", processor.flagKeys(), map[string][]string{}),
 	}
 	matcher := lsearch.Matcher{Elements: elements}
 
-	// With max-flags=1, an early mid-file stop would classify example-flag as removed
-	// before the re-add line is scanned.
-	body := `
--example-flag
-+example-flag
-+sample-flag
-`
-	ProcessDiffs(matcher, []byte(body), processor.Builder)
+	// flags are found in every file, however many there are
+	ProcessDiffs(matcher, newDiffFile("a", "-example-flag\n+example-flag\n"), processor.Builder)
+	ProcessDiffs(matcher, newDiffFile("b", "+sample-flag\n"), processor.Builder)
 	flagsRef := processor.Builder.Build()
 
 	assert.Contains(t, flagsRef.FlagsAdded, "example-flag")
 	assert.NotContains(t, flagsRef.FlagsRemoved, "example-flag")
+	assert.Contains(t, flagsRef.FlagsAdded, "sample-flag")
 }
 
-func TestProcessDiffs_skipsSubsequentFilesAtMaxFlags(t *testing.T) {
+func TestProcessDiffs_referenceLocations(t *testing.T) {
 	processor := newProcessFlagAccEnv()
-	processor.Builder = refs.NewReferenceSummaryBuilder(1, false)
-
 	elements := []lsearch.ElementMatcher{
 		lsearch.NewElementMatcher("default", "", "", processor.flagKeys(), map[string][]string{}),
 	}
 	matcher := lsearch.Matcher{Elements: elements}
 
-	ProcessDiffs(matcher, []byte("+example-flag\n"), processor.Builder)
-	assert.True(t, processor.Builder.MaxReferences())
-
-	ProcessDiffs(matcher, []byte("+sample-flag\n"), processor.Builder)
+	file := &DiffFile{
+		Path: "src/app.go",
+		Hunks: []*diff.Hunk{
+			{
+				OrigStartLine: 10,
+				OrigLines:     4,
+				NewStartLine:  10,
+				NewLines:      4,
+				Body:          []byte(" unchanged\n-example-flag\n+sample-flag\n unchanged\n+example-flag\n"),
+			},
+			{
+				OrigStartLine: 40,
+				OrigLines:     2,
+				NewStartLine:  41,
+				NewLines:      2,
+				Section:       "func main() {",
+				Body:          []byte(" unchanged\n-sample-flag\n"),
+			},
+		},
+	}
+	ProcessDiffs(matcher, file, processor.Builder)
 	flagsRef := processor.Builder.Build()
 
-	assert.Contains(t, flagsRef.FlagsAdded, "example-flag")
-	assert.NotContains(t, flagsRef.FlagsAdded, "sample-flag")
+	assert.Equal(t, []refs.ReferenceLocation{
+		{Path: "src/app.go", Line: 11, HeadLine: 11, Operation: diff_util.OperationDelete, Hunk: "@@ -10,4 +10,4 @@"},
+		{Path: "src/app.go", Line: 13, HeadLine: 13, Operation: diff_util.OperationAdd, Hunk: "@@ -10,4 +10,4 @@"},
+	}, flagsRef.Locations("example-flag"))
+	assert.Equal(t, []refs.ReferenceLocation{
+		{Path: "src/app.go", Line: 11, HeadLine: 11, Operation: diff_util.OperationAdd, Hunk: "@@ -10,4 +10,4 @@"},
+		{Path: "src/app.go", Line: 41, HeadLine: 42, Operation: diff_util.OperationDelete, Hunk: "@@ -40,2 +41,2 @@ func main() {"},
+	}, flagsRef.Locations("sample-flag"))
+}
+
+func TestReadDiffs_relativePaths(t *testing.T) {
+	rawDiff := `diff --git a/test b/test
+index 0000000..1111111 100644
+--- a/test
++++ b/test
+@@ -1,0 +1,1 @@
++example-flag
+diff --git a/.hidden b/.hidden
+index 0000000..1111111 100644
+--- a/.hidden
++++ b/.hidden
+@@ -1,0 +1,1 @@
++example-flag
+`
+
+	files := make(DiffFileMap)
+	err := ReadDiffs(strings.NewReader(rawDiff), "../testdata", 1, func(filePath string, file *DiffFile) {
+		files[filePath] = file
+	})
+	require.NoError(t, err)
+
+	// dotfiles are skipped
+	assert.Len(t, files, 1)
+	file := files["../testdata/test"]
+	if assert.NotNil(t, file) {
+		assert.Equal(t, "test", file.Path)
+		assert.Len(t, file.Hunks, 1)
+	}
+	assert.Equal(t, []byte("+example-flag\n"), files.Contents()["../testdata/test"])
+}
+
+func TestReadDiffs_fixtures(t *testing.T) {
+	cases := []struct {
+		fixture  string
+		expected []refs.ReferenceLocation
+	}{
+		{
+			fixture: "rename-edit.diff",
+			expected: []refs.ReferenceLocation{
+				{Path: "src/new_name.go", OrigPath: "src/old_name.go", Line: 4, HeadLine: 4, Operation: diff_util.OperationDelete, Hunk: "@@ -1,5 +1,5 @@"},
+				{Path: "src/new_name.go", OrigPath: "src/old_name.go", Line: 4, HeadLine: 4, Operation: diff_util.OperationAdd, Hunk: "@@ -1,5 +1,5 @@"},
+			},
+		},
+		{
+			// nothing to scan in a pure rename
+			fixture:  "rename.diff",
+			expected: []refs.ReferenceLocation{},
+		},
+		{
+			// only the lines that differ from the original are scanned
+			fixture: "copy.diff",
+			expected: []refs.ReferenceLocation{
+				{Path: "src/copy.go", OrigPath: "src/original.go", Line: 4, HeadLine: 4, Operation: diff_util.OperationAdd, Hunk: "@@ -1,3 +1,4 @@"},
+			},
+		},
+		{
+			fixture: "mode-change.diff",
+			expected: []refs.ReferenceLocation{
+				{Path: "scripts/check.sh", Line: 2, HeadLine: 2, Operation: diff_util.OperationDelete, Hunk: "@@ -1,2 +1,2 @@"},
+				{Path: "scripts/check.sh", Line: 2, HeadLine: 2, Operation: diff_util.OperationAdd, Hunk: "@@ -1,2 +1,2 @@"},
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.fixture, func(t *testing.T) {
+			rawDiff, err := os.ReadFile(filepath.Join("../testdata/diffs", tc.fixture))
+			require.NoError(t, err)
+
+			processor := newProcessFlagAccEnv()
+			matcher := lsearch.Matcher{Elements: []lsearch.ElementMatcher{
+				lsearch.NewElementMatcher("default", "", `"`, processor.flagKeys(), map[string][]string{}),
+			}}
+			err = ReadDiffs(bytes.NewReader(rawDiff), t.TempDir(), 1, func(_ string, file *DiffFile) {
+				ProcessDiffs(matcher, file, processor.Builder)
+			})
+			require.NoError(t, err)
+
+			flagsRef := processor.Builder.Build()
+			locations := make([]refs.ReferenceLocation, 0)
+			for _, key := range []string{"example-flag", "sample-flag"} {
+				locations = append(locations, flagsRef.Locations(key)...)
+			}
+			assert.Equal(t, tc.expected, locations)
+		})
+	}
+}
+
+func TestDetectUnknownFlags(t *testing.T) {
+	file := &DiffFile{Path: "main.go", Hunks: []*diff.Hunk{{
+		OrigStartLine: 10,
+		OrigLines:     2,
+		NewStartLine:  10,
+		NewLines:      3,
+		Body: []byte(` if client.BoolVariation("example-flag", ctx, false) {
+-	client.BoolVariation("old-flag", ctx, false)
++	client.BoolVariation("exmaple-flag", ctx, false)
++	client.BoolVariation("example-flag", ctx, false)
+`),
+	}}}
+	builder := refs.NewReferenceSummaryBuilder(false, false)
+
+	DetectUnknownFlags(unknownflags.NewDetector([]string{"example-flag"}), file, builder)
+
+	// removed lines are not checked
+	assert.Equal(t, []refs.UnknownFlag{{
+		Key:         "exmaple-flag",
+		Suggestions: []string{"example-flag"},
+		Locations: []refs.ReferenceLocation{
+			{Path: "main.go", Line: 11, HeadLine: 11, Operation: diff_util.OperationAdd, Hunk: "@@ -10,2 +10,3 @@"},
+		},
+	}}, builder.Build().UnknownFlags)
+}
+
+func TestReadDiffs_invalidDiff(t *testing.T) {
+	err := ReadDiffs(strings.NewReader("diff --git a/test b/test\n--- a/test\n+++ b/test\n@@ invalid @@\n"), "../testdata", 2, func(string, *DiffFile) {})
+	assert.Error(t, err)
 }
@@ -3,6 +3,7 @@ package errors
 import "errors"
 
 var (
-	UnauthorizedError = errors.New("`repo-token` lacks required permissions")
-	NoGitError        = errors.New("`git` not installed")
+	UnauthorizedError       = errors.New("`repo-token` lacks required permissions")
+	GitLabUnauthorizedError = errors.New("`GITLAB_TOKEN` is missing or lacks the api scope")
+	NoGitError              = errors.New("`git` not installed")
 )
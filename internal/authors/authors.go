@@ -0,0 +1,45 @@
+// Package authors identifies comments on GitHub that were written by the action.
+package authors
+
+import (
+	"context"
+
+	"github.com/google/go-github/v68/github"
+
+	lcr "github.com/launchdarkly/find-code-references-in-pull-request/config"
+	gha "github.com/launchdarkly/find-code-references-in-pull-request/internal/github_actions"
+)
+
+// Login of the bot that writes comments with the workflow's GITHUB_TOKEN
+const githubActionsLogin = "github-actions[bot]"
+
+// Matches comments written by github-actions[bot] or by the user the token belongs to. Other
+// bots and GitHub Apps are never matched, since their tokens can't look up their own login.
+// The user is looked up once, when a comment by another author is found.
+type Matcher struct {
+	ctx      context.Context
+	config   *lcr.Config
+	login    string
+	resolved bool
+}
+
+func NewMatcher(ctx context.Context, config *lcr.Config) *Matcher {
+	return &Matcher{ctx: ctx, config: config}
+}
+
+// Whether a comment by user could have been written by the action
+func (m *Matcher) Matches(user *github.User) bool {
+	if user.GetLogin() == githubActionsLogin {
+		return true
+	}
+	if !m.resolved {
+		m.resolved = true
+		// tokens for GitHub Apps, including GITHUB_TOKEN, can't look up their user
+		if self, _, err := m.config.GHClient.Users.Get(m.ctx, ""); err == nil {
+			m.login = self.GetLogin()
+		} else {
+			gha.Debug("Unable to get the user the token belongs to: %s", err)
+		}
+	}
+	return m.login != "" && user.GetLogin() == m.login
+}
@@ -0,0 +1,46 @@
+package authors
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/google/go-github/v68/github"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/launchdarkly/find-code-references-in-pull-request/internal/testutil"
+)
+
+func user(login, userType string) *github.User {
+	return &github.User{Login: github.Ptr(login), Type: github.Ptr(userType)}
+}
+
+func TestMatcher(t *testing.T) {
+	lookups := 0
+	config := testutil.GitHubConfig(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lookups++
+		_ = json.NewEncoder(w).Encode(github.User{Login: github.Ptr("ci-user")})
+	}))
+
+	m := NewMatcher(context.Background(), config)
+	assert.True(t, m.Matches(user("github-actions[bot]", "Bot")))
+	assert.Equal(t, 0, lookups)
+
+	// other bots and apps aren't the action
+	assert.False(t, m.Matches(user("dependabot[bot]", "Bot")))
+	assert.True(t, m.Matches(user("ci-user", "User")))
+	assert.False(t, m.Matches(user("octocat", "User")))
+	assert.Equal(t, 1, lookups)
+}
+
+func TestMatcher_appToken(t *testing.T) {
+	config := testutil.GitHubConfig(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"message": "Resource not accessible by integration"}`, http.StatusForbidden)
+	}))
+
+	m := NewMatcher(context.Background(), config)
+	assert.False(t, m.Matches(user("my-app[bot]", "Bot")))
+	assert.False(t, m.Matches(user("octocat", "User")))
+	assert.True(t, m.Matches(user("github-actions[bot]", "Bot")))
+}
@@ -0,0 +1,164 @@
+package checks
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v68/github"
+
+	ldapi "github.com/launchdarkly/api-client-go/v15"
+	lcr "github.com/launchdarkly/find-code-references-in-pull-request/config"
+	gha "github.com/launchdarkly/find-code-references-in-pull-request/internal/github_actions"
+	refs "github.com/launchdarkly/find-code-references-in-pull-request/internal/references"
+	"github.com/launchdarkly/find-code-references-in-pull-request/internal/scan"
+	"github.com/launchdarkly/find-code-references-in-pull-request/internal/utils"
+	"github.com/launchdarkly/find-code-references-in-pull-request/internal/utils/diff_util"
+)
+
+const (
+	checkRunName = "LaunchDarkly flag references"
+	// GitHub accepts at most 50 annotations per request
+	maxAnnotationsPerRequest = 50
+	// GitHub limits the check run summary to 65535 characters
+	maxSummaryLength = 65535
+	// GitHub limits annotation titles to 255 characters and messages to 64 KB
+	maxAnnotationTitleLength   = 255
+	maxAnnotationMessageLength = 65535
+)
+
+// Create a completed check run on the head commit of the pull request, with the
+// comment markdown as its summary and an annotation for each reference in each project
+func CreateCheckRun(ctx context.Context, config *lcr.Config, headSha string, projects []scan.Project, summary string) error {
+	if headSha == "" {
+		gha.Debug("No head commit found in event")
+		return nil
+	}
+
+	flagsRef := scan.MergeReferences(projects)
+	conclusion := "success"
+	if flagsRef.AnyFound() {
+		conclusion = config.CheckRunConclusion
+	}
+
+	output := &github.CheckRunOutput{
+		Title:   github.Ptr(OutputTitle(flagsRef)),
+		Summary: github.Ptr(utils.Truncate(summary, maxSummaryLength)),
+	}
+	annotations := make([]*github.CheckRunAnnotation, 0)
+	for _, p := range projects {
+		annotations = append(annotations, BuildAnnotations(p.References, p.Flags)...)
+	}
+	batch, remaining := nextBatch(annotations)
+	output.Annotations = batch
+
+	opts := github.CreateCheckRunOptions{
+		Name:        checkRunName,
+		HeadSHA:     headSha,
+		Status:      github.Ptr("completed"),
+		Conclusion:  github.Ptr(conclusion),
+		CompletedAt: &github.Timestamp{Time: time.Now()},
+		Output:      output,
+	}
+	checkRun, _, err := config.GHClient.Checks.CreateCheckRun(ctx, config.Owner, config.Repo, opts)
+	if err != nil {
+		return err
+	}
+	gha.Log("Created check run %s", checkRun.GetHTMLURL())
+
+	// additional annotations are appended to the check run in batches
+	for len(remaining) > 0 {
+		batch, remaining = nextBatch(remaining)
+		update := github.UpdateCheckRunOptions{
+			Name: checkRunName,
+			Output: &github.CheckRunOutput{
+				Title:       output.Title,
+				Summary:     output.Summary,
+				Annotations: batch,
+			},
+		}
+		if _, _, err := config.GHClient.Checks.UpdateCheckRun(ctx, config.Owner, config.Repo, checkRun.GetID(), update); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Build an annotation for each line referencing an added or removed flag. Archived or
+// deprecated flags that are added are annotated as warnings, everything else as notices.
+func BuildAnnotations(flagsRef refs.ReferenceSummary, flags []ldapi.FeatureFlag) []*github.CheckRunAnnotation {
+	flagsByKey := make(map[string]ldapi.FeatureFlag, len(flags))
+	for _, flag := range flags {
+		flagsByKey[flag.Key] = flag
+	}
+
+	annotations := make([]*github.CheckRunAnnotation, 0)
+	for _, flagKey := range flagsRef.AddedKeys() {
+		flag := flagsByKey[flagKey]
+		level, message := "notice", fmt.Sprintf("Reference to flag `%s` added", flagKey)
+		if flag.Archived {
+			level, message = "warning", fmt.Sprintf("Reference to archived flag `%s` added", flagKey)
+		} else if flag.Deprecated {
+			level, message = "warning", fmt.Sprintf("Reference to deprecated flag `%s` added", flagKey)
+		}
+		for _, location := range flagsRef.LocationsByOperation(flagKey, diff_util.OperationAdd) {
+			annotations = append(annotations, newAnnotation(location, flagTitle(flag, flagKey), level, message))
+		}
+	}
+
+	for _, flagKey := range flagsRef.RemovedKeys() {
+		message := fmt.Sprintf("Reference to flag `%s` removed", flagKey)
+		if flagsRef.IsExtinct(flagKey) {
+			message += ", all references removed"
+		}
+		for _, location := range flagsRef.LocationsByOperation(flagKey, diff_util.OperationDelete) {
+			annotations = append(annotations, newAnnotation(location, flagTitle(flagsByKey[flagKey], flagKey), "notice", message))
+		}
+	}
+
+	return annotations
+}
+
+func newAnnotation(location refs.ReferenceLocation, title, level, message string) *github.CheckRunAnnotation {
+	return &github.CheckRunAnnotation{
+		Path:            github.Ptr(location.Path),
+		StartLine:       github.Ptr(location.HeadLine),
+		EndLine:         github.Ptr(location.HeadLine),
+		AnnotationLevel: github.Ptr(level),
+		Title:           github.Ptr(utils.Truncate(title, maxAnnotationTitleLength)),
+		Message:         github.Ptr(utils.Truncate(message, maxAnnotationMessageLength)),
+	}
+}
+
+func flagTitle(flag ldapi.FeatureFlag, flagKey string) string {
+	name := flag.Name
+	if name == "" {
+		name = flagKey
+	}
+	return fmt.Sprintf("LaunchDarkly flag: %s", name)
+}
+
+// Title of the check run, summarizing the flag references found
+func OutputTitle(flagsRef refs.ReferenceSummary) string {
+	if !flagsRef.AnyFound() {
+		return "No flag references found"
+	}
+
+	parts := make([]string, 0, 2)
+	if n := len(flagsRef.FlagsAdded); n > 0 {
+		parts = append(parts, fmt.Sprintf("%s added or modified", utils.Pluralize("flag", n)))
+	}
+	if n := len(flagsRef.FlagsRemoved); n > 0 {
+		parts = append(parts, fmt.Sprintf("%s removed", utils.Pluralize("flag", n)))
+	}
+	return strings.Join(parts, ", ")
+}
+
+func nextBatch(annotations []*github.CheckRunAnnotation) ([]*github.CheckRunAnnotation, []*github.CheckRunAnnotation) {
+	if len(annotations) <= maxAnnotationsPerRequest {
+		return annotations, nil
+	}
+	return annotations[:maxAnnotationsPerRequest], annotations[maxAnnotationsPerRequest:]
+}
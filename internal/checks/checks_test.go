@@ -0,0 +1,183 @@
+package checks
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/google/go-github/v68/github"
+	ldapi "github.com/launchdarkly/api-client-go/v15"
+	refs "github.com/launchdarkly/find-code-references-in-pull-request/internal/references"
+	"github.com/launchdarkly/find-code-references-in-pull-request/internal/scan"
+	"github.com/launchdarkly/find-code-references-in-pull-request/internal/testutil"
+	"github.com/launchdarkly/find-code-references-in-pull-request/internal/utils/diff_util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Minimal stand-in for the GitHub checks API
+type checksServer struct {
+	created *github.CreateCheckRunOptions
+	updates []github.UpdateCheckRunOptions
+}
+
+func (s *checksServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == "/repos/owner/repo/check-runs":
+		s.created = &github.CreateCheckRunOptions{}
+		_ = json.NewDecoder(r.Body).Decode(s.created)
+		_, _ = w.Write([]byte(`{"id": 42}`))
+	case r.Method == http.MethodPatch && r.URL.Path == "/repos/owner/repo/check-runs/42":
+		var update github.UpdateCheckRunOptions
+		_ = json.NewDecoder(r.Body).Decode(&update)
+		s.updates = append(s.updates, update)
+		_, _ = w.Write([]byte(`{"id": 42}`))
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestBuildAnnotations(t *testing.T) {
+	builder := refs.NewReferenceSummaryBuilder(true, false)
+	require.NoError(t, builder.AddReference("example-flag", diff_util.OperationAdd, nil, refs.ReferenceLocation{Path: "main.go", Line: 7, HeadLine: 7}))
+	require.NoError(t, builder.AddReference("archived-flag", diff_util.OperationAdd, nil, refs.ReferenceLocation{Path: "main.go", Line: 10, HeadLine: 10}))
+	require.NoError(t, builder.AddReference("deprecated-flag", diff_util.OperationAdd, nil, refs.ReferenceLocation{Path: "app.go", Line: 1, HeadLine: 1}))
+	require.NoError(t, builder.AddReference("removed-flag", diff_util.OperationDelete, nil, refs.ReferenceLocation{Path: "app.go", Line: 20, HeadLine: 18}))
+	flags := []ldapi.FeatureFlag{
+		{Key: "example-flag", Name: "Example flag"},
+		{Key: "archived-flag", Name: "Archived flag", Archived: true},
+		{Key: "deprecated-flag", Name: "Deprecated flag", Deprecated: true},
+		{Key: "removed-flag", Name: "Removed flag"},
+	}
+
+	annotations := BuildAnnotations(builder.Build(), flags)
+
+	require.Len(t, annotations, 4)
+
+	byTitle := make(map[string]*github.CheckRunAnnotation, len(annotations))
+	for _, a := range annotations {
+		byTitle[a.GetTitle()] = a
+	}
+
+	archived := byTitle["LaunchDarkly flag: Archived flag"]
+	require.NotNil(t, archived)
+	assert.Equal(t, "warning", archived.GetAnnotationLevel())
+	assert.Equal(t, "Reference to archived flag `archived-flag` added", archived.GetMessage())
+	assert.Equal(t, 10, archived.GetStartLine())
+
+	deprecated := byTitle["LaunchDarkly flag: Deprecated flag"]
+	require.NotNil(t, deprecated)
+	assert.Equal(t, "warning", deprecated.GetAnnotationLevel())
+
+	added := byTitle["LaunchDarkly flag: Example flag"]
+	require.NotNil(t, added)
+	assert.Equal(t, "notice", added.GetAnnotationLevel())
+	assert.Equal(t, "main.go", added.GetPath())
+
+	removed := byTitle["LaunchDarkly flag: Removed flag"]
+	require.NotNil(t, removed)
+	assert.Equal(t, "notice", removed.GetAnnotationLevel())
+	assert.Equal(t, "Reference to flag `removed-flag` removed, all references removed", removed.GetMessage())
+	assert.Equal(t, 18, removed.GetStartLine())
+}
+
+func TestBuildAnnotations_truncatesTitle(t *testing.T) {
+	name := strings.Repeat("é", 300)
+	flagsRef := refs.ReferenceSummary{
+		FlagsAdded: refs.FlagAliasMap{"long-flag": {}},
+		References: refs.FlagReferenceMap{
+			"long-flag": {{Path: "main.go", Line: 1, HeadLine: 1, Operation: diff_util.OperationAdd}},
+		},
+	}
+
+	annotations := BuildAnnotations(flagsRef, []ldapi.FeatureFlag{{Key: "long-flag", Name: name}})
+
+	require.Len(t, annotations, 1)
+	title := annotations[0].GetTitle()
+	assert.True(t, utf8.ValidString(title))
+	assert.Equal(t, 255, utf8.RuneCountInString(title))
+	assert.True(t, strings.HasPrefix(title, "LaunchDarkly flag: éé"))
+}
+
+func TestCreateCheckRun(t *testing.T) {
+	server := &checksServer{}
+	config := testutil.GitHubConfig(t, server)
+	config.CheckRunConclusion = "neutral"
+
+	builder := refs.NewReferenceSummaryBuilder(false, false)
+	require.NoError(t, builder.AddReference("new-flag", diff_util.OperationAdd, nil, refs.ReferenceLocation{Path: "main.go", Line: 12, HeadLine: 12}))
+	require.NoError(t, builder.AddReference("old-flag", diff_util.OperationDelete, nil, refs.ReferenceLocation{Path: "main.go", Line: 30, HeadLine: 31}))
+	projects := []scan.Project{{Key: "default", Flags: []ldapi.FeatureFlag{{Key: "new-flag"}, {Key: "old-flag"}}, References: builder.Build()}}
+
+	err := CreateCheckRun(context.Background(), config, "abc123", projects, "## LaunchDarkly flag references")
+	require.NoError(t, err)
+
+	require.NotNil(t, server.created)
+	assert.Equal(t, "abc123", server.created.HeadSHA)
+	assert.Equal(t, "neutral", server.created.GetConclusion())
+	assert.Equal(t, "1 flag added or modified, 1 flag removed", server.created.Output.GetTitle())
+	assert.Equal(t, "## LaunchDarkly flag references", server.created.Output.GetSummary())
+	assert.Len(t, server.created.Output.Annotations, 2)
+	assert.Empty(t, server.updates)
+}
+
+func TestCreateCheckRun_noFlags(t *testing.T) {
+	server := &checksServer{}
+	config := testutil.GitHubConfig(t, server)
+	config.CheckRunConclusion = "neutral"
+
+	err := CreateCheckRun(context.Background(), config, "abc123", []scan.Project{{Key: "default"}}, "no flags")
+	require.NoError(t, err)
+
+	require.NotNil(t, server.created)
+	assert.Equal(t, "success", server.created.GetConclusion())
+	assert.Equal(t, "No flag references found", server.created.Output.GetTitle())
+}
+
+func TestCreateCheckRun_batchesAnnotations(t *testing.T) {
+	server := &checksServer{}
+	config := testutil.GitHubConfig(t, server)
+	config.CheckRunConclusion = "neutral"
+
+	builder := refs.NewReferenceSummaryBuilder(false, false)
+	for i := 1; i <= 120; i++ {
+		require.NoError(t, builder.AddReference("example-flag", diff_util.OperationAdd, nil, refs.ReferenceLocation{Path: fmt.Sprintf("file%d.go", i), Line: i, HeadLine: i}))
+	}
+	projects := []scan.Project{{Key: "default", Flags: []ldapi.FeatureFlag{{Key: "example-flag"}}, References: builder.Build()}}
+
+	err := CreateCheckRun(context.Background(), config, "abc123", projects, "summary")
+	require.NoError(t, err)
+
+	require.NotNil(t, server.created)
+	assert.Len(t, server.created.Output.Annotations, 50)
+	require.Len(t, server.updates, 2)
+	assert.Len(t, server.updates[0].Output.Annotations, 50)
+	assert.Len(t, server.updates[1].Output.Annotations, 20)
+}
+
+func TestCreateCheckRun_multipleProjects(t *testing.T) {
+	server := &checksServer{}
+	config := testutil.GitHubConfig(t, server)
+	config.CheckRunConclusion = "neutral"
+
+	web := refs.NewReferenceSummaryBuilder(false, false)
+	require.NoError(t, web.AddReference("web-flag", diff_util.OperationAdd, nil, refs.ReferenceLocation{Path: "web/app.go", Line: 4, HeadLine: 4}))
+	require.NoError(t, web.AddReference("old-web-flag", diff_util.OperationDelete, nil, refs.ReferenceLocation{Path: "web/app.go", Line: 9, HeadLine: 8}))
+	mobile := refs.NewReferenceSummaryBuilder(false, false)
+	require.NoError(t, mobile.AddReference("mobile-flag", diff_util.OperationAdd, nil, refs.ReferenceLocation{Path: "mobile/app.go", Line: 1, HeadLine: 1}))
+	projects := []scan.Project{
+		{Key: "web", Flags: []ldapi.FeatureFlag{{Key: "web-flag"}, {Key: "old-web-flag"}}, References: web.Build()},
+		{Key: "mobile", Flags: []ldapi.FeatureFlag{{Key: "mobile-flag"}}, References: mobile.Build()},
+	}
+
+	err := CreateCheckRun(context.Background(), config, "abc123", projects, "summary")
+	require.NoError(t, err)
+
+	require.NotNil(t, server.created)
+	assert.Equal(t, "2 flags added or modified, 1 flag removed", server.created.Output.GetTitle())
+	assert.Len(t, server.created.Output.Annotations, 3)
+}
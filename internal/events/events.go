@@ -0,0 +1,206 @@
+package events
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/google/go-github/v68/github"
+)
+
+// git reports this as the `before` commit when a branch is created
+const nullSha = "0000000000000000000000000000000000000000"
+
+// The triggering workflow event, reduced to what is needed to compute the diff
+type Event struct {
+	Name string
+	// Set for events with a pull request payload, such as `pull_request`
+	PullRequest *github.PullRequestEvent
+	// Set for GitLab merge request pipelines
+	MergeRequest *MergeRequest
+	// Refs to diff with local git. Empty for pull requests, whose diff is fetched from the
+	// API, unless both are overridden by the `base-ref` and `head-ref` inputs.
+	BaseRef string
+	HeadRef string
+}
+
+// GitLab merge request a pipeline runs for
+type MergeRequest struct {
+	IID       int    // number of the merge request in its project
+	ProjectID string // project the merge request is in, which may differ from the pipeline's for forks
+	HeadSha   string
+	BaseSha   string // merge base of the source and target branches, empty if unknown
+}
+
+func (e *Event) IsPullRequest() bool {
+	return e.PullRequest != nil && e.PullRequest.PullRequest != nil && e.PullRequest.PullRequest.Number != nil
+}
+
+// Whether the diff should be computed from local git rather than the pull request API
+func (e *Event) UseGitDiff() bool {
+	return e.BaseRef != "" && e.HeadRef != ""
+}
+
+// Head commit of the event. May be a ref rather than a SHA when set by the `head-ref` input.
+func (e *Event) HeadSha() string {
+	if e.HeadRef != "" {
+		return e.HeadRef
+	}
+	if e.IsPullRequest() {
+		return e.PullRequest.GetPullRequest().GetHead().GetSHA()
+	}
+	if e.MergeRequest != nil {
+		return e.MergeRequest.HeadSha
+	}
+	return ""
+}
+
+// Base commit of the event, which the head is compared to. May be a ref rather than a SHA when
+// set by the `base-ref` input. Empty if unknown.
+func (e *Event) BaseSha() string {
+	if e.BaseRef != "" {
+		return e.BaseRef
+	}
+	if e.IsPullRequest() {
+		return e.PullRequest.GetPullRequest().GetBase().GetSHA()
+	}
+	if e.MergeRequest != nil {
+		return e.MergeRequest.BaseSha
+	}
+	return ""
+}
+
+// Parse the event payload at path. baseRef and headRef take precedence over any refs
+// found in the payload; defaultHead is used when the payload has no head commit.
+func Parse(name, path, baseRef, headRef, defaultHead string) (*Event, error) {
+	event := &Event{Name: name}
+
+	switch name {
+	case "push":
+		var evt github.PushEvent
+		if err := readEvent(path, &evt); err != nil {
+			return nil, err
+		}
+		event.BaseRef = evt.GetBefore()
+		event.HeadRef = evt.GetAfter()
+		if event.BaseRef == nullSha {
+			// new branch, compare against the default branch
+			event.BaseRef = ""
+			if defaultBranch := evt.GetRepo().GetDefaultBranch(); defaultBranch != "" {
+				event.BaseRef = "origin/" + defaultBranch
+			}
+		}
+	case "merge_group":
+		var evt github.MergeGroupEvent
+		if err := readEvent(path, &evt); err != nil {
+			return nil, err
+		}
+		event.BaseRef = evt.GetMergeGroup().GetBaseSHA()
+		event.HeadRef = evt.GetMergeGroup().GetHeadSHA()
+	default:
+		// pull_request, pull_request_target and other events with a pull request payload
+		if path != "" {
+			var evt github.PullRequestEvent
+			if err := readEvent(path, &evt); err != nil {
+				return nil, err
+			}
+			event.PullRequest = &evt
+		}
+	}
+
+	return resolveRefs(event, baseRef, headRef, defaultHead)
+}
+
+// Parse a GitLab CI pipeline from its predefined variables. Merge request pipelines are
+// diffed with the merge request API, and other pipelines with local git like `push` events.
+// baseRef and headRef take precedence over the commits of the pipeline.
+func ParseGitLab(baseRef, headRef string) (*Event, error) {
+	event := &Event{Name: os.Getenv("CI_PIPELINE_SOURCE")}
+
+	if iid := os.Getenv("CI_MERGE_REQUEST_IID"); iid != "" {
+		number, err := strconv.Atoi(iid)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CI_MERGE_REQUEST_IID %q: %w", iid, err)
+		}
+		event.MergeRequest = &MergeRequest{
+			IID:       number,
+			ProjectID: firstNonEmpty(os.Getenv("CI_MERGE_REQUEST_PROJECT_ID"), os.Getenv("CI_PROJECT_ID")),
+			// merged results pipelines run on a merge commit rather than the source branch
+			HeadSha: firstNonEmpty(os.Getenv("CI_MERGE_REQUEST_SOURCE_BRANCH_SHA"), os.Getenv("CI_COMMIT_SHA")),
+			BaseSha: os.Getenv("CI_MERGE_REQUEST_DIFF_BASE_SHA"),
+		}
+	} else {
+		event.BaseRef = os.Getenv("CI_COMMIT_BEFORE_SHA")
+		if event.BaseRef == nullSha {
+			// new branch, compare against the default branch
+			event.BaseRef = ""
+			if defaultBranch := os.Getenv("CI_DEFAULT_BRANCH"); defaultBranch != "" {
+				event.BaseRef = "origin/" + defaultBranch
+			}
+		}
+	}
+
+	return resolveRefs(event, baseRef, headRef, os.Getenv("CI_COMMIT_SHA"))
+}
+
+// Apply the refs from inputs, and check the refs to diff are known
+func resolveRefs(event *Event, baseRef, headRef, defaultHead string) (*Event, error) {
+	if baseRef != "" {
+		event.BaseRef = baseRef
+	}
+	if headRef != "" {
+		event.HeadRef = headRef
+	}
+
+	if event.IsPullRequest() || event.MergeRequest != nil {
+		// only override the pull request diff if both refs are set
+		if !event.UseGitDiff() {
+			event.BaseRef, event.HeadRef = "", ""
+		}
+		return event, nil
+	}
+
+	if event.HeadRef == "" {
+		event.HeadRef = defaultHead
+	}
+	if event.BaseRef == "" {
+		return nil, fmt.Errorf("unable to determine base commit for %q event, set the `base-ref` input", event.Name)
+	}
+	if event.HeadRef == "" {
+		return nil, errors.New("unable to determine head commit, set the `head-ref` input")
+	}
+	if strings.HasPrefix(event.BaseRef, "-") || strings.HasPrefix(event.HeadRef, "-") {
+		return nil, errors.New("`base-ref` and `head-ref` must not start with '-'")
+	}
+
+	return event, nil
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// pulled from ld-find-code-refs github action
+func readEvent(path string, evt any) error {
+	/* #nosec */
+	eventJsonFile, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer eventJsonFile.Close()
+
+	eventJsonBytes, err := io.ReadAll(eventJsonFile)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(eventJsonBytes, evt)
+}
@@ -0,0 +1,165 @@
+package events
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	cases := []struct {
+		name          string
+		eventName     string
+		path          string
+		baseRef       string
+		headRef       string
+		isPullRequest bool
+		useGitDiff    bool
+		expectedBase  string
+		expectedHead  string
+	}{
+		{
+			name:          "pull request",
+			eventName:     "pull_request",
+			path:          "../../testdata/act/pull-request.json",
+			isPullRequest: true,
+		},
+		{
+			name:          "pull request with explicit refs",
+			eventName:     "pull_request",
+			path:          "../../testdata/act/pull-request.json",
+			baseRef:       "main",
+			headRef:       "feature",
+			isPullRequest: true,
+			useGitDiff:    true,
+			expectedBase:  "main",
+			expectedHead:  "feature",
+		},
+		{
+			name:          "pull request with only base ref",
+			eventName:     "pull_request",
+			path:          "../../testdata/act/pull-request.json",
+			baseRef:       "main",
+			isPullRequest: true,
+		},
+		{
+			name:         "push",
+			eventName:    "push",
+			path:         "../../testdata/act/push.json",
+			useGitDiff:   true,
+			expectedBase: "1111111111111111111111111111111111111111",
+			expectedHead: "2222222222222222222222222222222222222222",
+		},
+		{
+			name:         "push to new branch",
+			eventName:    "push",
+			path:         "../../testdata/act/push-new-branch.json",
+			useGitDiff:   true,
+			expectedBase: "origin/main",
+			expectedHead: "2222222222222222222222222222222222222222",
+		},
+		{
+			name:         "merge group",
+			eventName:    "merge_group",
+			path:         "../../testdata/act/merge-group.json",
+			useGitDiff:   true,
+			expectedBase: "3333333333333333333333333333333333333333",
+			expectedHead: "4444444444444444444444444444444444444444",
+		},
+		{
+			name:         "workflow dispatch",
+			eventName:    "workflow_dispatch",
+			path:         "../../testdata/act/workflow-dispatch.json",
+			baseRef:      "v1.0.0",
+			useGitDiff:   true,
+			expectedBase: "v1.0.0",
+			expectedHead: "5555555555555555555555555555555555555555",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			event, err := Parse(tc.eventName, tc.path, tc.baseRef, tc.headRef, "5555555555555555555555555555555555555555")
+			require.NoError(t, err)
+
+			assert.Equal(t, tc.eventName, event.Name)
+			assert.Equal(t, tc.isPullRequest, event.IsPullRequest())
+			assert.Equal(t, tc.useGitDiff, event.UseGitDiff())
+			assert.Equal(t, tc.expectedBase, event.BaseRef)
+			assert.Equal(t, tc.expectedHead, event.HeadRef)
+		})
+	}
+}
+
+func TestParse_workflowDispatchRequiresBaseRef(t *testing.T) {
+	_, err := Parse("workflow_dispatch", "../../testdata/act/workflow-dispatch.json", "", "", "5555555555555555555555555555555555555555")
+	assert.ErrorContains(t, err, "set the `base-ref` input")
+}
+
+func TestParse_rejectsOptionLikeRefs(t *testing.T) {
+	_, err := Parse("workflow_dispatch", "../../testdata/act/workflow-dispatch.json", "--output=/tmp/x", "", "5555555555555555555555555555555555555555")
+	assert.Error(t, err)
+}
+
+func TestEvent_HeadSha(t *testing.T) {
+	event, err := Parse("push", "../../testdata/act/push.json", "", "", "")
+	require.NoError(t, err)
+	assert.Equal(t, "2222222222222222222222222222222222222222", event.HeadSha())
+
+	event, err = Parse("pull_request", "../../testdata/act/pull-request.json", "", "", "")
+	require.NoError(t, err)
+	assert.Equal(t, 23, event.PullRequest.GetPullRequest().GetNumber())
+	assert.Equal(t, "", event.HeadSha())
+}
+
+func TestParseGitLab(t *testing.T) {
+	t.Setenv("CI_PIPELINE_SOURCE", "merge_request_event")
+	t.Setenv("CI_PROJECT_ID", "7")
+	t.Setenv("CI_COMMIT_SHA", "3333333333333333333333333333333333333333")
+	t.Setenv("CI_MERGE_REQUEST_IID", "12")
+	t.Setenv("CI_MERGE_REQUEST_PROJECT_ID", "5")
+	t.Setenv("CI_MERGE_REQUEST_SOURCE_BRANCH_SHA", "")
+
+	event, err := ParseGitLab("", "")
+	require.NoError(t, err)
+	assert.Equal(t, &MergeRequest{IID: 12, ProjectID: "5", HeadSha: "3333333333333333333333333333333333333333"}, event.MergeRequest)
+	assert.False(t, event.IsPullRequest())
+	assert.False(t, event.UseGitDiff())
+	assert.Equal(t, "3333333333333333333333333333333333333333", event.HeadSha())
+
+	// merged results pipelines report the source branch commit
+	t.Setenv("CI_MERGE_REQUEST_SOURCE_BRANCH_SHA", "4444444444444444444444444444444444444444")
+	event, err = ParseGitLab("", "")
+	require.NoError(t, err)
+	assert.Equal(t, "4444444444444444444444444444444444444444", event.HeadSha())
+
+	t.Setenv("CI_MERGE_REQUEST_IID", "twelve")
+	_, err = ParseGitLab("", "")
+	assert.ErrorContains(t, err, "invalid CI_MERGE_REQUEST_IID")
+}
+
+func TestParseGitLab_branchPipeline(t *testing.T) {
+	t.Setenv("CI_PIPELINE_SOURCE", "push")
+	t.Setenv("CI_MERGE_REQUEST_IID", "")
+	t.Setenv("CI_COMMIT_SHA", "2222222222222222222222222222222222222222")
+	t.Setenv("CI_COMMIT_BEFORE_SHA", "1111111111111111111111111111111111111111")
+	t.Setenv("CI_DEFAULT_BRANCH", "main")
+
+	event, err := ParseGitLab("", "")
+	require.NoError(t, err)
+	assert.Nil(t, event.MergeRequest)
+	assert.True(t, event.UseGitDiff())
+	assert.Equal(t, "1111111111111111111111111111111111111111", event.BaseRef)
+	assert.Equal(t, "2222222222222222222222222222222222222222", event.HeadRef)
+
+	// new branch
+	t.Setenv("CI_COMMIT_BEFORE_SHA", "0000000000000000000000000000000000000000")
+	event, err = ParseGitLab("", "")
+	require.NoError(t, err)
+	assert.Equal(t, "origin/main", event.BaseRef)
+
+	t.Setenv("CI_DEFAULT_BRANCH", "")
+	_, err = ParseGitLab("", "")
+	assert.ErrorContains(t, err, "set the `base-ref` input")
+}
@@ -3,12 +3,14 @@ package extinctions
 import (
 	gha "github.com/launchdarkly/find-code-references-in-pull-request/internal/github_actions"
 	refs "github.com/launchdarkly/find-code-references-in-pull-request/internal/references"
+	"github.com/launchdarkly/find-code-references-in-pull-request/internal/utils"
 	"github.com/launchdarkly/find-code-references-in-pull-request/search"
 	"github.com/launchdarkly/ld-find-code-refs/v2/options"
 	ld_search "github.com/launchdarkly/ld-find-code-refs/v2/search"
 )
 
-func CheckExtinctions(opts options.Options, builder *refs.ReferenceSummaryBuilder) error {
+// Check whether removed flags are still referenced in dir, relative to the workspace
+func CheckExtinctions(opts options.Options, dir string, builder *refs.ReferenceSummaryBuilder) error {
 	flagKeys := builder.RemovedFlagKeys()
 	if len(flagKeys) == 0 {
 		return nil
@@ -29,6 +31,9 @@ func CheckExtinctions(opts options.Options, builder *refs.ReferenceSummaryBuilde
 	gha.Debug("Found %d references to removed flags", len(references))
 
 	for _, ref := range references {
+		if !utils.InDir(ref.Path, dir) {
+			continue
+		}
 		for _, hunk := range ref.Hunks {
 			gha.Debug("Flag '%s' is not extinct", hunk.FlagKey)
 			builder.AddHeadFlag(hunk.FlagKey)
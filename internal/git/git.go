@@ -0,0 +1,116 @@
+package git
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	e "github.com/launchdarkly/find-code-references-in-pull-request/errors"
+)
+
+// Run `git diff` in dir for the given revisions, detecting renames. The diff is read
+// from git's output as it is written; Close returns an error if git failed.
+func Diff(dir string, revisions ...string) (io.ReadCloser, error) {
+	// check that git is installed
+	if _, gitCmdErr := exec.Command("git", "-v").CombinedOutput(); gitCmdErr != nil {
+		return nil, e.NoGitError
+	}
+
+	// revisions may come from user input, so they are never read as options
+	args := append([]string{"diff", "--find-renames", "--end-of-options"}, revisions...)
+	cmd := exec.Command("git", args...) // #nosec G204
+	cmd.Dir = dir
+	output := &diffOutput{cmd: cmd}
+	cmd.Stderr = &output.stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to run git diff: %w", err)
+	}
+	output.ReadCloser = stdout
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to run git diff: %w", err)
+	}
+	return output, nil
+}
+
+type diffOutput struct {
+	io.ReadCloser
+	cmd    *exec.Cmd
+	stderr bytes.Buffer
+}
+
+func (o *diffOutput) Close() error {
+	// closing the pipe first stops git if the diff wasn't read to the end
+	_ = o.ReadCloser.Close()
+	err := o.cmd.Wait()
+	// git diff return exit status 1 if there is a diff, so that does
+	// not indicate an error
+	var exitErr *exec.ExitError
+	if err == nil || errors.As(err, &exitErr) && exitErr.ExitCode() == 1 {
+		return nil
+	}
+	if stderr := strings.TrimSpace(o.stderr.String()); stderr != "" {
+		return fmt.Errorf("failed to run git diff: %w: %s", err, stderr)
+	}
+	return fmt.Errorf("failed to run git diff: %w", err)
+}
+
+// Resolve a revision in dir to a commit SHA
+func RevParse(dir, revision string) (string, error) {
+	cmd := exec.Command("git", "rev-parse", "--verify", "--end-of-options", revision+"^{commit}") // #nosec G204
+	cmd.Dir = dir
+	sha, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve %q: %w", revision, err)
+	}
+	return strings.TrimSpace(string(sha)), nil
+}
+
+// Check out revision to a temporary worktree of the repository in dir, fetching it from origin
+// if it isn't available locally, as in shallow clones. Returns the path of dir within the
+// worktree and a function that removes the worktree.
+func AddWorktree(dir, revision string) (string, func(), error) {
+	sha, err := RevParse(dir, revision)
+	if err != nil {
+		fetch := exec.Command("git", "fetch", "--quiet", "--no-tags", "--depth=1", "--end-of-options", "origin", revision) // #nosec G204
+		fetch.Dir = dir
+		if output, fetchErr := fetch.CombinedOutput(); fetchErr != nil {
+			return "", nil, fmt.Errorf("failed to fetch %q: %w: %s", revision, fetchErr, strings.TrimSpace(string(output)))
+		}
+		if sha, err = RevParse(dir, "FETCH_HEAD"); err != nil {
+			return "", nil, err
+		}
+	}
+
+	// the workspace may be a subdirectory of the repository
+	showPrefix := exec.Command("git", "rev-parse", "--show-prefix")
+	showPrefix.Dir = dir
+	prefix, err := showPrefix.Output()
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to find repository root: %w", err)
+	}
+
+	root, err := os.MkdirTemp("", "launchdarkly-base-*")
+	if err != nil {
+		return "", nil, err
+	}
+	remove := func() {
+		cmd := exec.Command("git", "worktree", "remove", "--force", root) // #nosec G204
+		cmd.Dir = dir
+		_ = cmd.Run()
+		_ = os.RemoveAll(root)
+	}
+
+	cmd := exec.Command("git", "worktree", "add", "--detach", "--end-of-options", root, sha) // #nosec G204
+	cmd.Dir = dir
+	if output, err := cmd.CombinedOutput(); err != nil {
+		remove()
+		return "", nil, fmt.Errorf("failed to check out %q: %w: %s", revision, err, strings.TrimSpace(string(output)))
+	}
+	return filepath.Join(root, strings.TrimSpace(string(prefix))), remove, nil
+}
@@ -2,10 +2,19 @@ package github_actions
 
 import (
 	"fmt"
+	"io"
 	"log"
 	"os"
 )
 
+var logOutput io.Writer = os.Stdout
+
+// Set where workflow commands and logs are written. Defaults to stdout, which is
+// where the GitHub Actions runner reads workflow commands from.
+func SetLogOutput(w io.Writer) {
+	logOutput = w
+}
+
 func SetOutput(name, value string) {
 	if err := setOutput(name, value); err != nil {
 		SetError("Failed to set outputs.%s\n", name)
@@ -15,6 +24,10 @@ func SetOutput(name, value string) {
 func setOutput(name, value string) error {
 	Debug("setting output %s=%s", name, value)
 	output := os.Getenv("GITHUB_OUTPUT")
+	if output == "" {
+		// not running in GitHub Actions
+		return nil
+	}
 
 	f, err := os.OpenFile(output, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 	if err != nil {
@@ -25,12 +38,41 @@ func setOutput(name, value string) error {
 	return err
 }
 
+// Append markdown to the job summary
+func AppendStepSummary(markdown string) {
+	if err := appendStepSummary(markdown); err != nil {
+		SetWarning("Failed to write job summary")
+		LogError(err)
+	}
+}
+
+func appendStepSummary(markdown string) error {
+	summary := os.Getenv("GITHUB_STEP_SUMMARY")
+	if summary == "" {
+		Debug("GITHUB_STEP_SUMMARY is not set, skipping job summary")
+		return nil
+	}
+
+	f, err := os.OpenFile(summary, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = fmt.Fprintf(f, "%s\n", markdown)
+	return err
+}
+
+// Mask a secret in the workflow log. Outside of GitHub Actions the command would print the
+// secret instead, so nothing is written.
 func MaskInput(input string) {
-	fmt.Printf("::add-mask::%s\n", input)
+	if os.Getenv("GITHUB_ACTIONS") != "true" {
+		return
+	}
+	fmt.Fprintf(logOutput, "::add-mask::%s\n", input)
 }
 
 func Log(format string, a ...any) {
-	fmt.Printf(format, a...)
+	fmt.Fprintf(logOutput, format, a...)
 }
 
 func LogError(err error) {
@@ -38,25 +80,25 @@ func LogError(err error) {
 }
 
 func SetNotice(format string, a ...any) {
-	fmt.Printf("::notice::%s\n", fmt.Sprintf(format, a...))
+	fmt.Fprintf(logOutput, "::notice::%s\n", fmt.Sprintf(format, a...))
 }
 
 func SetWarning(format string, a ...any) {
-	fmt.Printf("::warning::%s\n", fmt.Sprintf(format, a...))
+	fmt.Fprintf(logOutput, "::warning::%s\n", fmt.Sprintf(format, a...))
 }
 
 func SetError(format string, a ...any) {
-	fmt.Printf("::error::%s\n", fmt.Sprintf(format, a...))
+	fmt.Fprintf(logOutput, "::error::%s\n", fmt.Sprintf(format, a...))
 }
 
 func Debug(format string, a ...any) {
-	fmt.Printf("::debug::%s\n", fmt.Sprintf(format, a...))
+	fmt.Fprintf(logOutput, "::debug::%s\n", fmt.Sprintf(format, a...))
 }
 
 func StartLogGroup(format string, a ...any) {
-	fmt.Printf("::group::%s\n", fmt.Sprintf(format, a...))
+	fmt.Fprintf(logOutput, "::group::%s\n", fmt.Sprintf(format, a...))
 }
 
 func EndLogGroup() {
-	fmt.Println("::endgroup::")
+	fmt.Fprintln(logOutput, "::endgroup::")
 }
@@ -0,0 +1,51 @@
+package github_actions
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAppendStepSummary(t *testing.T) {
+	summary := filepath.Join(t.TempDir(), "summary.md")
+	t.Setenv("GITHUB_STEP_SUMMARY", summary)
+
+	AppendStepSummary("## First")
+	AppendStepSummary("## Second")
+
+	contents, err := os.ReadFile(summary)
+	require.NoError(t, err)
+	assert.Equal(t, "## First\n## Second\n", string(contents))
+}
+
+func TestAppendStepSummary_unset(t *testing.T) {
+	t.Setenv("GITHUB_STEP_SUMMARY", "")
+
+	assert.NoError(t, appendStepSummary("## Summary"))
+}
+
+func TestSetOutput_unset(t *testing.T) {
+	t.Setenv("GITHUB_OUTPUT", "")
+
+	assert.NoError(t, setOutput("any-modified", "true"))
+}
+
+func TestMaskInput(t *testing.T) {
+	var log bytes.Buffer
+	SetLogOutput(&log)
+	defer SetLogOutput(os.Stdout)
+
+	t.Setenv("GITHUB_ACTIONS", "true")
+	MaskInput("secret")
+	assert.Equal(t, "::add-mask::secret\n", log.String())
+
+	// outside of GitHub Actions the secret would be printed
+	log.Reset()
+	t.Setenv("GITHUB_ACTIONS", "")
+	MaskInput("secret")
+	assert.Empty(t, log.String())
+}
@@ -0,0 +1,313 @@
+package httpclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/go-github/v68/github"
+
+	gha "github.com/launchdarkly/find-code-references-in-pull-request/internal/github_actions"
+)
+
+// Timeouts and retry budget for HTTP requests
+type Options struct {
+	Timeout      time.Duration // timeout of a single attempt, 0 for none
+	MaxRetries   int           // number of retries after the first attempt
+	MaxRetryWait time.Duration // longest delay before a retry. Responses asking to wait longer are returned without retrying.
+	BaseDelay    time.Duration // delay before the first retry when the response does not say how long to wait, doubled for each retry
+}
+
+func DefaultOptions() Options {
+	return Options{
+		Timeout:      30 * time.Second,
+		MaxRetries:   3,
+		MaxRetryWait: 60 * time.Second,
+		BaseDelay:    time.Second,
+	}
+}
+
+func NewClient(opts Options) *http.Client {
+	return &http.Client{Transport: NewTransport(http.DefaultTransport, opts)}
+}
+
+// Transport retries requests that were rate limited or failed with a server error,
+// waiting for the time requested by `Retry-After` or `X-RateLimit-Reset` headers
+// when present, and otherwise backing off exponentially.
+//
+// Requests that may not be idempotent (POST and PATCH) are only retried when rate
+// limited, unless they are made with a context from WithIdempotentRequest or have an
+// `Idempotency-Key` or `X-Idempotency-Key` header, following the convention of net/http.
+type Transport struct {
+	Base    http.RoundTripper
+	Options Options
+
+	sleep func(ctx context.Context, d time.Duration) error
+	now   func() time.Time
+}
+
+func NewTransport(base http.RoundTripper, opts Options) *Transport {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &Transport{Base: base, Options: opts, sleep: sleep, now: time.Now}
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		r := req
+		if attempt > 0 {
+			var err error
+			if r, err = rewind(req); err != nil {
+				return nil, err
+			}
+		}
+
+		resp, err := t.roundTrip(r)
+		delay, retry := t.retryDelay(req, resp, err, attempt)
+		if !retry {
+			return resp, err
+		}
+
+		if err != nil {
+			gha.Debug("[%s %s] request failed, retrying in %s: %s", req.Method, req.URL.Redacted(), delay, err)
+		} else {
+			gha.Debug("[%s %s] status %d, retrying in %s", req.Method, req.URL.Redacted(), resp.StatusCode, delay)
+			// drain the body so the connection can be reused
+			_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+			resp.Body.Close()
+		}
+
+		if err := t.sleep(req.Context(), delay); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// Make a single attempt, applying the per-attempt timeout
+func (t *Transport) roundTrip(req *http.Request) (*http.Response, error) {
+	if t.Options.Timeout <= 0 {
+		return t.Base.RoundTrip(req)
+	}
+
+	if streamingBody(req.Context()) {
+		return t.roundTripStreaming(req)
+	}
+
+	ctx, cancel := context.WithTimeout(req.Context(), t.Options.Timeout)
+	resp, err := t.Base.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	// the timeout also covers reading the body
+	resp.Body = &cancelBody{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+// Make a single attempt, applying the timeout until the response headers are received
+func (t *Transport) roundTripStreaming(req *http.Request) (*http.Response, error) {
+	ctx, cancel := context.WithCancel(req.Context())
+	timer := time.AfterFunc(t.Options.Timeout, cancel)
+	resp, err := t.Base.RoundTrip(req.WithContext(ctx))
+	timedOut := !timer.Stop()
+	if err != nil {
+		cancel()
+		if timedOut && req.Context().Err() == nil {
+			return nil, fmt.Errorf("timeout awaiting response headers after %s: %w", t.Options.Timeout, err)
+		}
+		return nil, err
+	}
+	resp.Body = &cancelBody{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+type streamingBodyKey struct{}
+
+// Mark requests made with ctx as having a response body that is read while it is
+// processed, such as a large diff. The timeout then only applies until the response
+// headers are received, so slow processing doesn't time out the request.
+func WithStreamingBody(ctx context.Context) context.Context {
+	return context.WithValue(ctx, streamingBodyKey{}, true)
+}
+
+func streamingBody(ctx context.Context) bool {
+	streaming, _ := ctx.Value(streamingBodyKey{}).(bool)
+	return streaming
+}
+
+type idempotentRequestKey struct{}
+
+// Mark requests made with ctx as safe to send more than once, such as a POST that creates a
+// resource with a unique key, so they are retried after server errors like GET requests
+func WithIdempotentRequest(ctx context.Context) context.Context {
+	return context.WithValue(ctx, idempotentRequestKey{}, true)
+}
+
+// Whether the request should be retried, and how long to wait first
+func (t *Transport) retryDelay(req *http.Request, resp *http.Response, err error, attempt int) (time.Duration, bool) {
+	if attempt >= t.Options.MaxRetries || req.Context().Err() != nil {
+		return 0, false
+	}
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		// body can't be sent again
+		return 0, false
+	}
+
+	var delay time.Duration
+	switch {
+	case err != nil:
+		if !isIdempotent(req) {
+			return 0, false
+		}
+		delay = t.backoff(attempt)
+	case isRateLimited(resp):
+		delay = t.rateLimitDelay(resp, attempt)
+	case isServerError(resp.StatusCode):
+		if !isIdempotent(req) {
+			return 0, false
+		}
+		delay = t.retryAfter(resp, attempt)
+	default:
+		return 0, false
+	}
+
+	if t.Options.MaxRetryWait > 0 && delay > t.Options.MaxRetryWait {
+		gha.Debug("[%s %s] not retrying, server asked to wait %s", req.Method, req.URL.Redacted(), delay)
+		return 0, false
+	}
+	return delay, true
+}
+
+// Exponential backoff with jitter
+func (t *Transport) backoff(attempt int) time.Duration {
+	base := t.Options.BaseDelay
+	if base <= 0 {
+		base = time.Second
+	}
+	d := base << attempt
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// Delay from the `Retry-After` header, falling back to backoff
+func (t *Transport) retryAfter(resp *http.Response, attempt int) time.Duration {
+	value := resp.Header.Get("Retry-After")
+	if value == "" {
+		return t.backoff(attempt)
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return max(time.Duration(seconds)*time.Second, 0)
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return max(date.Sub(t.now()), 0)
+	}
+	return t.backoff(attempt)
+}
+
+// Delay for a rate limited response. `Retry-After` takes precedence over
+// `X-RateLimit-Reset`, which GitHub sends in epoch seconds and LaunchDarkly in
+// epoch milliseconds.
+func (t *Transport) rateLimitDelay(resp *http.Response, attempt int) time.Duration {
+	if resp.Header.Get("Retry-After") != "" {
+		return t.retryAfter(resp, attempt)
+	}
+	if reset, err := strconv.ParseInt(resp.Header.Get("X-RateLimit-Reset"), 10, 64); err == nil && reset > 0 {
+		var resetAt time.Time
+		if reset > 1e12 {
+			resetAt = time.UnixMilli(reset)
+		} else {
+			resetAt = time.Unix(reset, 0)
+		}
+		return max(resetAt.Sub(t.now()), 0)
+	}
+	return t.backoff(attempt)
+}
+
+// GitHub responds to both primary and secondary rate limits with 403 or 429
+func isRateLimited(resp *http.Response) bool {
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests:
+		return true
+	case http.StatusForbidden:
+		return resp.Header.Get("Retry-After") != "" || resp.Header.Get("X-RateLimit-Remaining") == "0"
+	}
+	return false
+}
+
+func isServerError(status int) bool {
+	switch status {
+	case http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+func isIdempotent(req *http.Request) bool {
+	switch req.Method {
+	case "", http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	if idempotent, _ := req.Context().Value(idempotentRequestKey{}).(bool); idempotent {
+		return true
+	}
+	_, ok := req.Header["Idempotency-Key"]
+	if !ok {
+		_, ok = req.Header["X-Idempotency-Key"]
+	}
+	return ok
+}
+
+func rewind(req *http.Request) (*http.Request, error) {
+	r := req.Clone(req.Context())
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		r.Body = body
+	}
+	return r, nil
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+// Describe GitHub rate limit errors that remain after retrying. Returns false for other errors.
+func RateLimitMessage(err error) (string, bool) {
+	var rateLimitErr *github.RateLimitError
+	if errors.As(err, &rateLimitErr) {
+		return fmt.Sprintf("GitHub API rate limit exceeded, resets at %s", rateLimitErr.Rate.Reset.Format(time.RFC3339)), true
+	}
+	var abuseErr *github.AbuseRateLimitError
+	if errors.As(err, &abuseErr) {
+		if retryAfter := abuseErr.GetRetryAfter(); retryAfter > 0 {
+			return fmt.Sprintf("GitHub API secondary rate limit exceeded, retry after %s", retryAfter), true
+		}
+		return "GitHub API secondary rate limit exceeded", true
+	}
+	return "", false
+}
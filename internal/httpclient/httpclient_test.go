@@ -0,0 +1,329 @@
+package httpclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v68/github"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Server that responds with the given handlers in order, then 200 OK
+type sequenceServer struct {
+	handlers []http.HandlerFunc
+	requests int32
+	bodies   []string
+}
+
+func (s *sequenceServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	i := int(atomic.AddInt32(&s.requests, 1)) - 1
+	body, _ := io.ReadAll(r.Body)
+	s.bodies = append(s.bodies, string(body))
+	if i < len(s.handlers) {
+		s.handlers[i](w, r)
+		return
+	}
+	_, _ = w.Write([]byte("ok"))
+}
+
+func status(code int, headers ...string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		for i := 0; i+1 < len(headers); i += 2 {
+			w.Header().Set(headers[i], headers[i+1])
+		}
+		w.WriteHeader(code)
+	}
+}
+
+// Client that records delays instead of sleeping
+func newTestClient(opts Options) (*http.Client, *[]time.Duration) {
+	delays := make([]time.Duration, 0)
+	transport := NewTransport(nil, opts)
+	transport.now = func() time.Time { return time.Unix(1700000000, 0) }
+	transport.sleep = func(ctx context.Context, d time.Duration) error {
+		delays = append(delays, d)
+		return ctx.Err()
+	}
+	return &http.Client{Transport: transport}, &delays
+}
+
+func testOptions() Options {
+	return Options{MaxRetries: 3, MaxRetryWait: time.Minute, BaseDelay: 100 * time.Millisecond}
+}
+
+func TestTransport_retriesServerErrors(t *testing.T) {
+	server := &sequenceServer{handlers: []http.HandlerFunc{
+		status(http.StatusBadGateway),
+		status(http.StatusServiceUnavailable),
+	}}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	client, delays := newTestClient(testOptions())
+	resp, err := client.Get(ts.URL)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, int32(3), server.requests)
+	require.Len(t, *delays, 2)
+	// exponential backoff with jitter
+	assert.GreaterOrEqual(t, (*delays)[0], 50*time.Millisecond)
+	assert.LessOrEqual(t, (*delays)[0], 100*time.Millisecond)
+	assert.GreaterOrEqual(t, (*delays)[1], 100*time.Millisecond)
+	assert.LessOrEqual(t, (*delays)[1], 200*time.Millisecond)
+}
+
+func TestTransport_honorsRetryAfter(t *testing.T) {
+	server := &sequenceServer{handlers: []http.HandlerFunc{
+		status(http.StatusTooManyRequests, "Retry-After", "7"),
+	}}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	client, delays := newTestClient(testOptions())
+	resp, err := client.Get(ts.URL)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, []time.Duration{7 * time.Second}, *delays)
+}
+
+func TestTransport_honorsRateLimitReset(t *testing.T) {
+	server := &sequenceServer{handlers: []http.HandlerFunc{
+		// LaunchDarkly, epoch milliseconds
+		status(http.StatusTooManyRequests, "X-Ratelimit-Reset", strconv.FormatInt(1700000000*1000+1500, 10)),
+		// GitHub primary rate limit, epoch seconds
+		status(http.StatusForbidden, "X-RateLimit-Remaining", "0", "X-RateLimit-Reset", "1700000003"),
+		// GitHub secondary rate limit
+		status(http.StatusForbidden, "Retry-After", "2"),
+	}}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	client, delays := newTestClient(testOptions())
+	resp, err := client.Get(ts.URL)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, []time.Duration{1500 * time.Millisecond, 3 * time.Second, 2 * time.Second}, *delays)
+}
+
+func TestTransport_givesUpAfterMaxRetries(t *testing.T) {
+	server := &sequenceServer{handlers: []http.HandlerFunc{
+		status(http.StatusServiceUnavailable),
+		status(http.StatusServiceUnavailable),
+		status(http.StatusServiceUnavailable),
+	}}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	opts := testOptions()
+	opts.MaxRetries = 2
+	client, delays := newTestClient(opts)
+	resp, err := client.Get(ts.URL)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	assert.Equal(t, int32(3), server.requests)
+	assert.Len(t, *delays, 2)
+}
+
+func TestTransport_givesUpWhenWaitTooLong(t *testing.T) {
+	server := &sequenceServer{handlers: []http.HandlerFunc{
+		status(http.StatusTooManyRequests, "Retry-After", "3600"),
+	}}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	client, delays := newTestClient(testOptions())
+	resp, err := client.Get(ts.URL)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	assert.Empty(t, *delays)
+}
+
+func TestTransport_doesNotRetryOtherErrors(t *testing.T) {
+	server := &sequenceServer{handlers: []http.HandlerFunc{
+		status(http.StatusForbidden),
+	}}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	client, _ := newTestClient(testOptions())
+	resp, err := client.Get(ts.URL)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	assert.Equal(t, int32(1), server.requests)
+}
+
+func TestTransport_post(t *testing.T) {
+	server := &sequenceServer{handlers: []http.HandlerFunc{
+		status(http.StatusBadGateway),
+		status(http.StatusTooManyRequests),
+		status(http.StatusBadGateway),
+	}}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	client, _ := newTestClient(testOptions())
+
+	// server errors are not retried, the request may have been processed
+	resp, err := client.Post(ts.URL, "application/json", strings.NewReader(`{"a": 1}`))
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusBadGateway, resp.StatusCode)
+
+	// rate limited requests are retried with the same body, as are server errors for idempotent requests
+	req, err := http.NewRequestWithContext(WithIdempotentRequest(context.Background()), http.MethodPost, ts.URL, strings.NewReader(`{"b": 2}`))
+	require.NoError(t, err)
+	resp, err = client.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, []string{`{"a": 1}`, `{"b": 2}`, `{"b": 2}`, `{"b": 2}`}, server.bodies)
+}
+
+func TestTransport_timeout(t *testing.T) {
+	var requests int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requests, 1) == 1 {
+			select {
+			case <-r.Context().Done():
+			case <-time.After(time.Second):
+			}
+			return
+		}
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer ts.Close()
+
+	opts := testOptions()
+	opts.Timeout = 50 * time.Millisecond
+	client, delays := newTestClient(opts)
+	resp, err := client.Get(ts.URL)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "ok", string(body))
+	assert.Len(t, *delays, 1)
+}
+
+func TestTransport_streamingBody(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("first "))
+		w.(http.Flusher).Flush()
+		select {
+		case <-r.Context().Done():
+			return
+		case <-time.After(200 * time.Millisecond):
+		}
+		_, _ = w.Write([]byte("second"))
+	}))
+	defer ts.Close()
+
+	opts := testOptions()
+	opts.Timeout = 50 * time.Millisecond
+	client, _ := newTestClient(opts)
+
+	// the timeout covers reading the body
+	resp, err := client.Get(ts.URL)
+	require.NoError(t, err)
+	_, err = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	// unless the body is streamed
+	req, err := http.NewRequestWithContext(WithStreamingBody(context.Background()), http.MethodGet, ts.URL, nil)
+	require.NoError(t, err)
+	resp, err = client.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "first second", string(body))
+}
+
+func TestTransport_streamingBodyTimeout(t *testing.T) {
+	var requests int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requests, 1) == 1 {
+			select {
+			case <-r.Context().Done():
+			case <-time.After(time.Second):
+			}
+			return
+		}
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer ts.Close()
+
+	opts := testOptions()
+	opts.Timeout = 50 * time.Millisecond
+	client, delays := newTestClient(opts)
+	req, err := http.NewRequestWithContext(WithStreamingBody(context.Background()), http.MethodGet, ts.URL, nil)
+	require.NoError(t, err)
+	resp, err := client.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "ok", string(body))
+	assert.Len(t, *delays, 1)
+}
+
+func TestTransport_contextCanceled(t *testing.T) {
+	server := &sequenceServer{handlers: []http.HandlerFunc{
+		status(http.StatusServiceUnavailable),
+	}}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	transport := NewTransport(nil, Options{MaxRetries: 3, BaseDelay: time.Hour})
+	client := &http.Client{Transport: transport}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, ts.URL, nil)
+	require.NoError(t, err)
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	_, err = client.Do(req)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, int32(1), server.requests)
+}
+
+func TestRateLimitMessage(t *testing.T) {
+	rateLimitErr := &github.RateLimitError{Rate: github.Rate{Reset: github.Timestamp{Time: time.Unix(1700000000, 0).UTC()}}}
+	message, ok := RateLimitMessage(fmt.Errorf("listing comments: %w", rateLimitErr))
+	assert.True(t, ok)
+	assert.Equal(t, "GitHub API rate limit exceeded, resets at 2023-11-14T22:13:20Z", message)
+
+	retryAfter := 30 * time.Second
+	message, ok = RateLimitMessage(&github.AbuseRateLimitError{RetryAfter: &retryAfter})
+	assert.True(t, ok)
+	assert.Equal(t, "GitHub API secondary rate limit exceeded, retry after 30s", message)
+
+	_, ok = RateLimitMessage(errors.New("not found"))
+	assert.False(t, ok)
+}
@@ -0,0 +1,60 @@
+package introductions
+
+import (
+	"strings"
+
+	gha "github.com/launchdarkly/find-code-references-in-pull-request/internal/github_actions"
+	refs "github.com/launchdarkly/find-code-references-in-pull-request/internal/references"
+	"github.com/launchdarkly/find-code-references-in-pull-request/internal/utils"
+	"github.com/launchdarkly/find-code-references-in-pull-request/search"
+	"github.com/launchdarkly/ld-find-code-refs/v2/options"
+	ld_search "github.com/launchdarkly/ld-find-code-refs/v2/search"
+)
+
+// Check whether added flags were already referenced in dir, relative to the workspace, before the change.
+// baseDir is the workspace checked out at the base ref, which is searched like CheckExtinctions searches
+// HEAD. File pattern aliases are generated from the files at the base ref.
+//
+// If baseDir is empty, HEAD is searched instead: references on lines that were not added in the diff were
+// also there at the base ref. This relies on the line numbers of the diff matching the workspace, which
+// isn't the case when the workspace is a merge commit, as on pull_request events.
+func CheckIntroductions(opts options.Options, dir, baseDir string, builder *refs.ReferenceSummaryBuilder) error {
+	flagKeys := builder.AddedFlagKeys()
+	if len(flagKeys) == 0 {
+		return nil
+	}
+	gha.StartLogGroup("Checking for introduced flags...")
+	defer gha.EndLogGroup()
+
+	if baseDir != "" {
+		opts.Dir = baseDir
+	}
+	matcher, err := search.GetMatcher(opts, flagKeys, nil)
+	if err != nil {
+		return err
+	}
+
+	gha.Debug("Searching for existing references to %d added flags...", len(flagKeys))
+	references, err := ld_search.SearchForRefs(opts.Dir, opts.Subdirectory, matcher)
+	if err != nil {
+		return err
+	}
+	gha.Debug("Found %d references to added flags", len(references))
+
+	for _, ref := range references {
+		if !utils.InDir(ref.Path, dir) {
+			continue
+		}
+		for _, hunk := range ref.Hunks {
+			if baseDir != "" {
+				builder.AddBaseFlag(hunk.FlagKey)
+				continue
+			}
+			// without context lines, every line of the hunk references the flag
+			for i := range strings.Split(hunk.Lines, "\n") {
+				builder.AddHeadReference(hunk.FlagKey, ref.Path, hunk.StartingLineNumber+i)
+			}
+		}
+	}
+	return nil
+}
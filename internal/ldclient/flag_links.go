@@ -2,25 +2,32 @@ package ldapi
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
+	neturl "net/url"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/google/go-github/v68/github"
 
 	ldapi "github.com/launchdarkly/api-client-go/v15"
 	lcr "github.com/launchdarkly/find-code-references-in-pull-request/config"
 	gha "github.com/launchdarkly/find-code-references-in-pull-request/internal/github_actions"
+	"github.com/launchdarkly/find-code-references-in-pull-request/internal/httpclient"
 	"github.com/launchdarkly/find-code-references-in-pull-request/internal/utils"
 	"github.com/launchdarkly/find-code-references-in-pull-request/internal/version"
 
 	flags "github.com/launchdarkly/find-code-references-in-pull-request/internal/references"
 )
 
-func CreateFlagLinks(config *lcr.Config, flagsRef flags.ReferenceSummary, event *github.PullRequestEvent) {
+// Create or update links from each flag referenced in the pull request, and delete the pull
+// request's links from staleKeys, flags that may have been linked by an earlier run but that the
+// pull request no longer references
+func SyncFlagLinks(ctx context.Context, config *lcr.Config, flagsRef flags.ReferenceSummary, event *github.PullRequestEvent, staleKeys []string) {
 	pr := event.PullRequest
 	if pr == nil || pr.HTMLURL == nil || pr.ID == nil {
 		gha.Debug("No pull request found in event")
@@ -31,9 +38,9 @@ func CreateFlagLinks(config *lcr.Config, flagsRef flags.ReferenceSummary, event
 	numRemoved := len(flagsRef.FlagsRemoved)
 
 	for key, aliases := range flagsRef.FlagsAdded {
-		message := buildLinkMessage(key, aliases, "added", numAdded, numRemoved)
-		link := makeFlagLinkRep(event, key, message)
-		postFlagLink(config, *link, key)
+		message := buildLinkMessage(key, aliases, "added", numAdded, numRemoved, flagsRef.FileCounts(key))
+		link := makeFlagLinkRep(config, event, key, message, flagsRef.Locations(key))
+		postFlagLink(ctx, config, *link, key)
 	}
 
 	for key, aliases := range flagsRef.FlagsRemoved {
@@ -41,13 +48,19 @@ func CreateFlagLinks(config *lcr.Config, flagsRef flags.ReferenceSummary, event
 		if flagsRef.IsExtinct(key) {
 			action = "extinct"
 		}
-		message := buildLinkMessage(key, aliases, action, numAdded, numRemoved)
-		link := makeFlagLinkRep(event, key, message)
-		postFlagLink(config, *link, key)
+		message := buildLinkMessage(key, aliases, action, numAdded, numRemoved, flagsRef.FileCounts(key))
+		link := makeFlagLinkRep(config, event, key, message, flagsRef.Locations(key))
+		postFlagLink(ctx, config, *link, key)
+	}
+
+	for _, key := range staleKeys {
+		deleteStaleFlagLink(ctx, config, event, key)
 	}
 }
 
-func postFlagLink(config *lcr.Config, link ldapi.FlagLinkPost, flagKey string) {
+// Create the flag link. A link that already exists is updated, so that its message and the state
+// of the pull request are up to date.
+func postFlagLink(ctx context.Context, config *lcr.Config, link ldapi.FlagLinkPost, flagKey string) {
 	requestBody, err := json.Marshal(link)
 	if err != nil {
 		gha.SetWarning("Failed to create flag link for %s", flagKey)
@@ -55,48 +68,163 @@ func postFlagLink(config *lcr.Config, link ldapi.FlagLinkPost, flagKey string) {
 		return
 	}
 
-	url := fmt.Sprintf("%s/api/v2/flag-links/projects/%s/flags/%s", config.LdInstance, config.LdProject, flagKey)
+	url := flagLinksURL(config, flagKey)
+	status, _, err := sendFlagLinkRequest(ctx, config, http.MethodPost, url, requestBody)
+	if err != nil {
+		gha.SetWarning("Failed to create flag link for %s", flagKey)
+		gha.Debug("Error when sending flag link request:\n\n%s", err.Error())
+		return
+	}
 
-	gha.Debug("[POST %s]\n\n%s", url, string(requestBody))
+	switch status {
+	case http.StatusCreated:
+		gha.Log("[POST %s] Flag link created [url=%s]", url, *link.DeepLink)
+	case http.StatusConflict:
+		gha.Log("[POST %s] Flag link already exists, updating [url=%s]", url, *link.DeepLink)
+		patchFlagLink(ctx, config, link, flagKey)
+	default:
+		gha.SetWarning("Failed to create flag link for %s", flagKey)
+		gha.Log("[POST %s] Flag link request failed [status=%d]", url, status)
+	}
+}
 
-	req, err := http.NewRequest(http.MethodPost, url, bytes.NewBuffer(requestBody))
+// Replace the fields of an existing flag link with those of link. If the update fails, the
+// existing link is left as it was.
+func patchFlagLink(ctx context.Context, config *lcr.Config, link ldapi.FlagLinkPost, flagKey string) {
+	patch := []ldapi.PatchOperation{
+		{Op: "replace", Path: "/deepLink", Value: link.DeepLink},
+		{Op: "replace", Path: "/title", Value: link.Title},
+		{Op: "replace", Path: "/description", Value: link.Description},
+		{Op: "replace", Path: "/metadata", Value: link.Metadata},
+	}
+	if link.Timestamp != nil {
+		patch = append(patch, ldapi.PatchOperation{Op: "replace", Path: "/timestamp", Value: link.Timestamp})
+	}
+	requestBody, err := json.Marshal(patch)
 	if err != nil {
-		gha.SetWarning("Could not to create flag link request")
+		gha.SetWarning("Failed to update flag link for %s", flagKey)
+		gha.Debug("Unable to construct flag link payload")
 		return
 	}
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("LD-API-Version", "beta")
-	req.Header.Set("Authorization", config.ApiToken)
-	req.Header.Add("User-Agent", fmt.Sprintf("find-code-references-pr/%s", version.Version))
 
-	client := new(http.Client)
-	resp, err := client.Do(req)
+	url := flagLinksURL(config, flagKey) + "/" + neturl.PathEscape(*link.Key)
+	status, _, err := sendFlagLinkRequest(ctx, config, http.MethodPatch, url, requestBody)
 	if err != nil {
-		gha.SetWarning("Failed to create flag link for %s", flagKey)
+		gha.SetWarning("Failed to update flag link for %s, it still shows the pull request as it was when last updated", flagKey)
 		gha.Debug("Error when sending flag link request:\n\n%s", err.Error())
 		return
 	}
+	if status != http.StatusOK {
+		gha.SetWarning("Failed to update flag link for %s, it still shows the pull request as it was when last updated", flagKey)
+		gha.Log("[PATCH %s] Flag link request failed [status=%d]", url, status)
+		return
+	}
+	gha.Log("[PATCH %s] Flag link updated [url=%s]", url, *link.DeepLink)
+}
 
-	defer resp.Body.Close()
-	body, err := io.ReadAll(resp.Body)
+// Delete the flag's link to the pull request, if the flag links API lists one. Only links
+// created by this integration for this pull request are deleted.
+func deleteStaleFlagLink(ctx context.Context, config *lcr.Config, event *github.PullRequestEvent, flagKey string) {
+	url := flagLinksURL(config, flagKey)
+	status, respBody, err := sendFlagLinkRequest(ctx, config, http.MethodGet, url, nil)
 	if err != nil {
-		gha.Debug("Could not parse flag link request")
+		gha.SetWarning("Failed to list flag links for %s", flagKey)
+		gha.Debug("Error when sending flag link request:\n\n%s", err.Error())
+		return
 	}
 
-	switch resp.StatusCode {
-	case http.StatusCreated:
-		gha.Log("[POST %s] Flag link created [url=%s]", url, *link.DeepLink)
-	case http.StatusConflict:
-		gha.Log("[POST %s] Flag link already exists [url=%s]", url, *link.DeepLink)
+	switch status {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		gha.Debug("[GET %s] Flag not found", url)
+		return
 	default:
-		gha.SetWarning("Failed to create flag link for %s", flagKey)
-		gha.Log("[POST %s] Flag link request failed [status=%d]", url, resp.StatusCode)
+		gha.SetWarning("Failed to list flag links for %s", flagKey)
+		gha.Log("[GET %s] Flag link request failed [status=%d]", url, status)
+		return
 	}
 
-	gha.Debug("Response:\n\n%s", string(body))
+	var links ldapi.FlagLinkCollectionRep
+	if err := json.Unmarshal(respBody, &links); err != nil {
+		gha.SetWarning("Failed to list flag links for %s", flagKey)
+		gha.Debug("Could not parse flag link response")
+		return
+	}
+
+	linkKey := flagLinkKey(event, flagKey)
+	for _, link := range links.Items {
+		if utils.SafeString(link.IntegrationKey) == flagLinkIntegration && utils.SafeString(link.Key) == linkKey {
+			deleteFlagLink(ctx, config, flagKey, linkKey)
+			return
+		}
+	}
+	gha.Debug("[GET %s] No flag link to delete", url)
 }
 
-func makeFlagLinkRep(event *github.PullRequestEvent, flagKey, message string) *ldapi.FlagLinkPost {
+// Delete the flag link with the given key. A link that doesn't exist is treated as deleted.
+func deleteFlagLink(ctx context.Context, config *lcr.Config, flagKey, linkKey string) {
+	url := flagLinksURL(config, flagKey) + "/" + neturl.PathEscape(linkKey)
+	status, _, err := sendFlagLinkRequest(ctx, config, http.MethodDelete, url, nil)
+	if err != nil {
+		gha.SetWarning("Failed to delete flag link for %s", flagKey)
+		gha.Debug("Error when sending flag link request:\n\n%s", err.Error())
+		return
+	}
+
+	switch status {
+	case http.StatusOK, http.StatusNoContent:
+		gha.Log("[DELETE %s] Flag link deleted", url)
+	case http.StatusNotFound:
+		gha.Debug("[DELETE %s] Flag link not found", url)
+	default:
+		gha.SetWarning("Failed to delete flag link for %s", flagKey)
+		gha.Log("[DELETE %s] Flag link request failed [status=%d]", url, status)
+	}
+}
+
+func flagLinksURL(config *lcr.Config, flagKey string) string {
+	return fmt.Sprintf("%s/api/v2/flag-links/projects/%s/flags/%s", config.LdInstance, config.LdProject, flagKey)
+}
+
+// Send a request to the flag links API, returning the response status and body
+func sendFlagLinkRequest(ctx context.Context, config *lcr.Config, method, url string, requestBody []byte) (int, []byte, error) {
+	var body io.Reader
+	if requestBody != nil {
+		body = bytes.NewReader(requestBody)
+		gha.Debug("[%s %s]\n\n%s", method, url, string(requestBody))
+	}
+
+	// links have a unique key and updates replace whole fields, so a retried request can't
+	// create a duplicate or apply a change twice
+	req, err := http.NewRequestWithContext(httpclient.WithIdempotentRequest(ctx), method, url, body)
+	if err != nil {
+		return 0, nil, err
+	}
+	if requestBody != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("LD-API-Version", "beta")
+	req.Header.Set("Authorization", config.ApiToken)
+	req.Header.Add("User-Agent", fmt.Sprintf("find-code-references-pr/%s", version.Version))
+
+	resp, err := httpClient(config).Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		gha.Debug("Could not parse flag link response")
+	}
+	gha.Debug("Response:\n\n%s", string(respBody))
+
+	return resp.StatusCode, respBody, nil
+}
+
+// Build the link from the flag to the pull request. The link leads to the first reference added
+// in the head commit, or to the pull request if no references were added.
+func makeFlagLinkRep(config *lcr.Config, event *github.PullRequestEvent, flagKey, message string, locations []flags.ReferenceLocation) *ldapi.FlagLinkPost {
 	pr := event.PullRequest
 	if pr == nil || pr.HTMLURL == nil || pr.ID == nil {
 		return nil
@@ -113,6 +241,18 @@ func makeFlagLinkRep(event *github.PullRequestEvent, flagKey, message string) *l
 		"repoName":  utils.SafeString(event.Repo.FullName),
 		"repoUrl":   utils.SafeString(event.Repo.HTMLURL),
 	}
+	deepLink := pr.HTMLURL
+	if urls := referenceURLs(config, event, locations); len(urls) > 0 {
+		metadata["referenceUrls"] = strings.Join(urls, "\n")
+		deepLink = &urls[0]
+	}
+	if pr.GetMerged() {
+		metadata["state"] = "merged"
+		metadata["mergeCommitSha"] = pr.GetMergeCommitSHA()
+		if pr.MergedAt != nil {
+			metadata["mergedAt"] = pr.MergedAt.UTC().Format(time.RFC3339)
+		}
+	}
 
 	if pr.User.Name != nil {
 		metadata["authorName"] = utils.SafeString(pr.User.Name)
@@ -128,10 +268,8 @@ func makeFlagLinkRep(event *github.PullRequestEvent, flagKey, message string) *l
 		timestamp = &m
 	}
 
-	integration := "github"
-	id := strconv.FormatInt(*pr.ID, 10)
-	// key must be unique
-	key := fmt.Sprintf("github-pr-%s-%s", id, flagKey)
+	integration := flagLinkIntegration
+	key := flagLinkKey(event, flagKey)
 
 	description := utils.SafeString(pr.Body)
 	// Flag links require a description
@@ -139,7 +277,7 @@ func makeFlagLinkRep(event *github.PullRequestEvent, flagKey, message string) *l
 		description = "Empty PR Body"
 	}
 	return &ldapi.FlagLinkPost{
-		DeepLink:       pr.HTMLURL,
+		DeepLink:       deepLink,
 		Key:            &key,
 		IntegrationKey: &integration,
 		Timestamp:      timestamp,
@@ -149,6 +287,34 @@ func makeFlagLinkRep(event *github.PullRequestEvent, flagKey, message string) *l
 	}
 }
 
+// URLs of the added references in the head commit of the pull request
+func referenceURLs(config *lcr.Config, event *github.PullRequestEvent, locations []flags.ReferenceLocation) []string {
+	repoURL, headSha := config.RepoURL, config.HeadSha
+	if repoURL == "" {
+		repoURL = event.GetRepo().GetHTMLURL()
+	}
+	if headSha == "" {
+		headSha = event.GetPullRequest().GetHead().GetSHA()
+	}
+
+	urls := make([]string, 0, len(locations))
+	for _, l := range locations {
+		if url := l.BlobURL(repoURL, headSha); url != "" {
+			urls = append(urls, url)
+		}
+	}
+	return urls
+}
+
+// Integration key of the links created by the action
+const flagLinkIntegration = "github"
+
+// Key of the link from the flag to the pull request. Keys must be unique.
+func flagLinkKey(event *github.PullRequestEvent, flagKey string) string {
+	id := strconv.FormatInt(event.GetPullRequest().GetID(), 10)
+	return fmt.Sprintf("github-pr-%s-%s", id, flagKey)
+}
+
 func getLinkTitle(event *github.PullRequestEvent) *string {
 	builder := new(strings.Builder)
 	builder.WriteString(fmt.Sprintf("[%s]", utils.SafeString(event.Repo.FullName)))
@@ -171,13 +337,21 @@ func getLinkTitle(event *github.PullRequestEvent) *string {
 	return &title
 }
 
-func buildLinkMessage(key string, aliases []string, action string, added, removed int) string {
+func buildLinkMessage(key string, aliases []string, action string, added, removed int, files []flags.FileCount) string {
 	builder := new(strings.Builder)
 	builder.WriteString(fmt.Sprintf("Flag %s", action))
 	if len(aliases) > 0 {
 		builder.WriteString(fmt.Sprintf(" (aliases: %s)", strings.Join(aliases, ", ")))
 	}
 
+	for _, file := range files {
+		references := "references"
+		if file.Count == 1 {
+			references = "reference"
+		}
+		builder.WriteString(fmt.Sprintf("\n\t- %d %s in %s", file.Count, references, file.Path))
+	}
+
 	if added > 0 {
 		count := added
 		if action == "added" {
@@ -0,0 +1,254 @@
+package ldapi
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v68/github"
+	ldapi "github.com/launchdarkly/api-client-go/v15"
+	flags "github.com/launchdarkly/find-code-references-in-pull-request/internal/references"
+	"github.com/launchdarkly/find-code-references-in-pull-request/internal/utils/diff_util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Stands in for the LaunchDarkly flag links API of project `test`, storing links by flag key and link key
+type flagLinkServer struct {
+	mu          sync.Mutex
+	links       map[string]map[string]ldapi.FlagLinkPost
+	requests    []string
+	failUpdates bool // respond to PATCH requests with a server error
+}
+
+func newFlagLinkServer() *flagLinkServer {
+	return &flagLinkServer{links: make(map[string]map[string]ldapi.FlagLinkPost)}
+}
+
+func (s *flagLinkServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests = append(s.requests, r.Method+" "+r.URL.Path)
+
+	path, ok := strings.CutPrefix(r.URL.Path, "/api/v2/flag-links/projects/test/flags/")
+	if !ok || r.Header.Get("LD-API-Version") != "beta" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	flagKey, linkKey, _ := strings.Cut(path, "/")
+
+	switch {
+	case r.Method == http.MethodPost && linkKey == "":
+		var link ldapi.FlagLinkPost
+		if err := json.NewDecoder(r.Body).Decode(&link); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if _, ok := s.links[flagKey][*link.Key]; ok {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		if s.links[flagKey] == nil {
+			s.links[flagKey] = make(map[string]ldapi.FlagLinkPost)
+		}
+		s.links[flagKey][*link.Key] = link
+		w.WriteHeader(http.StatusCreated)
+	case r.Method == http.MethodGet && linkKey == "":
+		links := ldapi.FlagLinkCollectionRep{Items: make([]ldapi.FlagLinkRep, 0)}
+		for _, link := range s.links[flagKey] {
+			links.Items = append(links.Items, ldapi.FlagLinkRep{Key: link.Key, IntegrationKey: link.IntegrationKey, Metadata: link.Metadata})
+		}
+		_ = json.NewEncoder(w).Encode(links)
+	case r.Method == http.MethodPatch && linkKey != "":
+		if s.failUpdates {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		link, ok := s.links[flagKey][linkKey]
+		var patch []ldapi.PatchOperation
+		if !ok || json.NewDecoder(r.Body).Decode(&patch) != nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		for _, op := range patch {
+			value, _ := json.Marshal(op.Value)
+			switch op.Path {
+			case "/deepLink":
+				_ = json.Unmarshal(value, &link.DeepLink)
+			case "/title":
+				_ = json.Unmarshal(value, &link.Title)
+			case "/description":
+				_ = json.Unmarshal(value, &link.Description)
+			case "/metadata":
+				_ = json.Unmarshal(value, &link.Metadata)
+			case "/timestamp":
+				_ = json.Unmarshal(value, &link.Timestamp)
+			}
+		}
+		s.links[flagKey][linkKey] = link
+		w.WriteHeader(http.StatusOK)
+	case r.Method == http.MethodDelete && linkKey != "":
+		if _, ok := s.links[flagKey][linkKey]; !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		delete(s.links[flagKey], linkKey)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// metadata of the flag's link to PR 1, nil if there is no link
+func (s *flagLinkServer) metadata(flagKey string) map[string]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	link, ok := s.links[flagKey]["github-pr-1-"+flagKey]
+	if !ok {
+		return nil
+	}
+	return *link.Metadata
+}
+
+func testPullRequestEvent(action string) *github.PullRequestEvent {
+	return &github.PullRequestEvent{
+		Action: github.Ptr(action),
+		PullRequest: &github.PullRequest{
+			ID:      github.Ptr(int64(1)),
+			Number:  github.Ptr(12),
+			Title:   github.Ptr("Add flags"),
+			State:   github.Ptr("open"),
+			HTMLURL: github.Ptr("https://github.com/org/repo/pull/12"),
+			User:    &github.User{Login: github.Ptr("octocat")},
+		},
+		Repo: &github.Repository{FullName: github.Ptr("org/repo")},
+	}
+}
+
+func TestSyncFlagLinks_lifecycle(t *testing.T) {
+	stub := newFlagLinkServer()
+	server := httptest.NewServer(stub)
+	defer server.Close()
+	config := newTestConfig(server.URL)
+	ctx := context.Background()
+
+	// opened
+	opened := flags.ReferenceSummary{
+		FlagsAdded:   flags.FlagAliasMap{"flag-a": {}, "flag-b": {}},
+		FlagsRemoved: flags.FlagAliasMap{},
+	}
+	SyncFlagLinks(ctx, config, opened, testPullRequestEvent("opened"), nil)
+	require.NotNil(t, stub.metadata("flag-a"))
+	require.NotNil(t, stub.metadata("flag-b"))
+	assert.Equal(t, "open", stub.metadata("flag-a")["state"])
+	assert.Equal(t, "Flag added\n\t- Added 1 other flags", stub.metadata("flag-a")["message"])
+
+	// flag-c is linked to another pull request
+	other := testPullRequestEvent("opened")
+	other.PullRequest.ID = github.Ptr(int64(2))
+	SyncFlagLinks(ctx, config, flags.ReferenceSummary{FlagsAdded: flags.FlagAliasMap{"flag-c": {}}}, other, nil)
+
+	// synchronize: flag-b is no longer referenced and flag-a's message changes
+	synchronized := flags.ReferenceSummary{
+		FlagsAdded:   flags.FlagAliasMap{"flag-a": {"flagA"}},
+		FlagsRemoved: flags.FlagAliasMap{},
+	}
+	SyncFlagLinks(ctx, config, synchronized, testPullRequestEvent("synchronize"), []string{"flag-b", "flag-c", "flag-d"})
+	assert.Equal(t, "Flag added (aliases: flagA)", stub.metadata("flag-a")["message"])
+	assert.Nil(t, stub.metadata("flag-b"))
+	assert.Contains(t, stub.links["flag-c"], "github-pr-2-flag-c")
+
+	// merged
+	merged := testPullRequestEvent("closed")
+	merged.PullRequest.State = github.Ptr("closed")
+	merged.PullRequest.Merged = github.Ptr(true)
+	merged.PullRequest.MergeCommitSHA = github.Ptr("abc123")
+	merged.PullRequest.MergedAt = &github.Timestamp{Time: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)}
+	SyncFlagLinks(ctx, config, synchronized, merged, nil)
+	metadata := stub.metadata("flag-a")
+	assert.Equal(t, "merged", metadata["state"])
+	assert.Equal(t, "abc123", metadata["mergeCommitSha"])
+	assert.Equal(t, "2024-05-01T12:00:00Z", metadata["mergedAt"])
+
+	// existing links are updated, and only the pull request's own links are deleted
+	assert.Equal(t, []string{
+		"POST /api/v2/flag-links/projects/test/flags/flag-a",
+		"PATCH /api/v2/flag-links/projects/test/flags/flag-a/github-pr-1-flag-a",
+		"GET /api/v2/flag-links/projects/test/flags/flag-b",
+		"DELETE /api/v2/flag-links/projects/test/flags/flag-b/github-pr-1-flag-b",
+		"GET /api/v2/flag-links/projects/test/flags/flag-c",
+		"GET /api/v2/flag-links/projects/test/flags/flag-d",
+		"POST /api/v2/flag-links/projects/test/flags/flag-a",
+		"PATCH /api/v2/flag-links/projects/test/flags/flag-a/github-pr-1-flag-a",
+	}, stub.requests[3:])
+}
+
+func TestSyncFlagLinks_updateFailed(t *testing.T) {
+	stub := newFlagLinkServer()
+	server := httptest.NewServer(stub)
+	defer server.Close()
+	config := newTestConfig(server.URL)
+
+	flagsRef := flags.ReferenceSummary{FlagsAdded: flags.FlagAliasMap{"flag-a": {}}}
+	SyncFlagLinks(context.Background(), config, flagsRef, testPullRequestEvent("opened"), nil)
+
+	// the existing link is kept when it can't be updated
+	stub.failUpdates = true
+	SyncFlagLinks(context.Background(), config, flagsRef, testPullRequestEvent("closed"), nil)
+	assert.Equal(t, "open", stub.metadata("flag-a")["state"])
+	assert.NotContains(t, stub.requests, "DELETE /api/v2/flag-links/projects/test/flags/flag-a/github-pr-1-flag-a")
+}
+
+func TestSyncFlagLinks_closed(t *testing.T) {
+	stub := newFlagLinkServer()
+	server := httptest.NewServer(stub)
+	defer server.Close()
+
+	closed := testPullRequestEvent("closed")
+	closed.PullRequest.State = github.Ptr("closed")
+	flagsRef := flags.ReferenceSummary{FlagsAdded: flags.FlagAliasMap{"flag-a": {}}}
+	SyncFlagLinks(context.Background(), newTestConfig(server.URL), flagsRef, closed, nil)
+
+	metadata := stub.metadata("flag-a")
+	assert.Equal(t, "closed", metadata["state"])
+	assert.NotContains(t, metadata, "mergeCommitSha")
+	assert.NotContains(t, metadata, "mergedAt")
+}
+
+func TestSyncFlagLinks_deepLinks(t *testing.T) {
+	stub := newFlagLinkServer()
+	server := httptest.NewServer(stub)
+	defer server.Close()
+	config := newTestConfig(server.URL)
+	config.RepoURL = "https://github.com/org/repo"
+	config.HeadSha = "abc123"
+
+	flagsRef := flags.ReferenceSummary{
+		FlagsAdded:   flags.FlagAliasMap{"flag-a": {}},
+		FlagsRemoved: flags.FlagAliasMap{"flag-b": {}},
+		References: flags.FlagReferenceMap{
+			"flag-a": {
+				{Path: "app.go", Line: 4, Operation: diff_util.OperationAdd},
+				{Path: "app.go", Line: 9, Operation: diff_util.OperationDelete},
+				{Path: "web/index.ts", Line: 2, Operation: diff_util.OperationAdd},
+			},
+			"flag-b": {{Path: "old.go", Line: 3, Operation: diff_util.OperationDelete}},
+		},
+	}
+	SyncFlagLinks(context.Background(), config, flagsRef, testPullRequestEvent("opened"), nil)
+
+	link := stub.links["flag-a"]["github-pr-1-flag-a"]
+	assert.Equal(t, "https://github.com/org/repo/blob/abc123/app.go#L4", *link.DeepLink)
+	assert.Equal(t, "https://github.com/org/repo/blob/abc123/app.go#L4\nhttps://github.com/org/repo/blob/abc123/web/index.ts#L2", (*link.Metadata)["referenceUrls"])
+	assert.True(t, strings.HasPrefix((*link.Metadata)["message"], "Flag added\n\t- 2 references in app.go\n\t- 1 reference in web/index.ts"), (*link.Metadata)["message"])
+
+	// removed references aren't in the head commit, so the link leads to the pull request
+	link = stub.links["flag-b"]["github-pr-1-flag-b"]
+	assert.Equal(t, "https://github.com/org/repo/pull/12", *link.DeepLink)
+	assert.NotContains(t, *link.Metadata, "referenceUrls")
+}
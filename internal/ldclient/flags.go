@@ -1,10 +1,12 @@
 package ldapi
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/url"
+	"strconv"
 
 	ldapi "github.com/launchdarkly/api-client-go/v15"
 	lcr "github.com/launchdarkly/find-code-references-in-pull-request/config"
@@ -13,11 +15,21 @@ import (
 	"github.com/pkg/errors"
 )
 
-func GetAllFlags(config *lcr.Config) ([]ldapi.FeatureFlag, error) {
+func GetAllFlags(ctx context.Context, config *lcr.Config) ([]ldapi.FeatureFlag, error) {
+	if config.FlagsFile != "" {
+		return ReadFlagsFile(config)
+	}
+
 	gha.Debug("Fetching all flags for project")
 	params := url.Values{}
-	params.Add("env", config.LdEnvironment)
-	activeFlags, err := getFlags(config, params)
+	environments := config.LdEnvironments
+	if len(environments) == 0 {
+		environments = []string{config.LdEnvironment}
+	}
+	for _, env := range environments {
+		params.Add("env", env)
+	}
+	activeFlags, err := getFlags(ctx, config, params)
 	if err != nil {
 		return []ldapi.FeatureFlag{}, err
 	}
@@ -27,7 +39,7 @@ func GetAllFlags(config *lcr.Config) ([]ldapi.FeatureFlag, error) {
 
 	if config.IncludeArchivedFlags {
 		params.Add("filter", "state:archived")
-		archivedFlags, err := getFlags(config, params)
+		archivedFlags, err := getFlags(ctx, config, params)
 		if err != nil {
 			return []ldapi.FeatureFlag{}, err
 		}
@@ -38,21 +50,53 @@ func GetAllFlags(config *lcr.Config) ([]ldapi.FeatureFlag, error) {
 	return flags, nil
 }
 
-func getFlags(config *lcr.Config, params url.Values) ([]ldapi.FeatureFlag, error) {
-	url := fmt.Sprintf("%s/api/v2/flags/%s", config.LdInstance, config.LdProject)
-	client := &http.Client{}
-	req, err := http.NewRequest(http.MethodGet, url, nil)
+// Fetch every page of flags matching params, following `_links.next` until
+// exhausted or until the configured page cap is reached
+func getFlags(ctx context.Context, config *lcr.Config, params url.Values) ([]ldapi.FeatureFlag, error) {
+	query := url.Values{}
+	for k, v := range params {
+		query[k] = v
+	}
+	if config.FlagsPageSize > 0 {
+		query.Set("limit", strconv.Itoa(config.FlagsPageSize))
+	}
+
+	next := fmt.Sprintf("%s/api/v2/flags/%s?%s", config.LdInstance, config.LdProject, query.Encode())
+	flags := make([]ldapi.FeatureFlag, 0)
+	for page := 1; next != ""; page++ {
+		if config.MaxFlagPages > 0 && page > config.MaxFlagPages {
+			gha.SetWarning("Stopped fetching flags after %d pages. Some flags may not be searched for.", config.MaxFlagPages)
+			break
+		}
+
+		gha.Debug("Fetching page %d of flags", page)
+		resp, err := getFlagsPage(ctx, config, next)
+		if err != nil {
+			return []ldapi.FeatureFlag{}, err
+		}
+		flags = append(flags, resp.Items...)
+
+		next, err = nextPageURL(config, query, resp, len(flags))
+		if err != nil {
+			return []ldapi.FeatureFlag{}, err
+		}
+	}
+
+	return flags, nil
+}
+
+func getFlagsPage(ctx context.Context, config *lcr.Config, url string) (ldapi.FeatureFlags, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	if err != nil {
-		return []ldapi.FeatureFlag{}, err
+		return ldapi.FeatureFlags{}, err
 	}
-	req.URL.RawQuery = params.Encode()
 	req.Header.Add("Authorization", config.ApiToken)
 	req.Header.Add("LD-API-Version", "20220603")
 	req.Header.Add("User-Agent", fmt.Sprintf("find-code-references-pr/%s", version.Version))
 
-	resp, err := client.Do(req)
+	resp, err := httpClient(config).Do(req)
 	if err != nil {
-		return []ldapi.FeatureFlag{}, err
+		return ldapi.FeatureFlags{}, err
 	}
 	defer resp.Body.Close()
 	decoder := json.NewDecoder(resp.Body)
@@ -60,17 +104,65 @@ func getFlags(config *lcr.Config, params url.Values) ([]ldapi.FeatureFlag, error
 	if resp.StatusCode != http.StatusOK {
 		var r interface{}
 		if err := decoder.Decode(&r); err != nil {
-			return []ldapi.FeatureFlag{}, errors.Wrapf(err, "unexpected status code: %d. unable to parse response", resp.StatusCode)
+			return ldapi.FeatureFlags{}, errors.Wrapf(err, "unexpected status code: %d. unable to parse response", resp.StatusCode)
 		}
 		err := fmt.Errorf("unexpected status code: %d with response: %#v", resp.StatusCode, r)
-		return []ldapi.FeatureFlag{}, err
+		return ldapi.FeatureFlags{}, err
 	}
 
 	flags := ldapi.FeatureFlags{}
 	err = decoder.Decode(&flags)
 	if err != nil {
-		return []ldapi.FeatureFlag{}, err
+		return ldapi.FeatureFlags{}, err
 	}
 
-	return flags.Items, nil
+	return flags, nil
+}
+
+// Determine the URL of the next page of flags. Prefer the `next` link returned by the API,
+// falling back to offset pagination when the response only reports a total count.
+// Returns an empty string when there are no more pages.
+func nextPageURL(config *lcr.Config, query url.Values, resp ldapi.FeatureFlags, fetched int) (string, error) {
+	if len(resp.Items) == 0 {
+		return "", nil
+	}
+
+	if link, ok := resp.Links["next"]; ok && link.Href != nil && *link.Href != "" {
+		base, err := url.Parse(config.LdInstance)
+		if err != nil {
+			return "", err
+		}
+		ref, err := url.Parse(*link.Href)
+		if err != nil {
+			return "", errors.Wrapf(err, "unable to parse next page link %q", *link.Href)
+		}
+		next := base.ResolveReference(ref)
+		// the API omits query parameters like `env` from pagination links
+		nextQuery := next.Query()
+		for k, v := range query {
+			if _, ok := nextQuery[k]; !ok {
+				nextQuery[k] = v
+			}
+		}
+		next.RawQuery = nextQuery.Encode()
+		return next.String(), nil
+	}
+
+	if resp.TotalCount != nil && fetched < int(*resp.TotalCount) {
+		nextQuery := url.Values{}
+		for k, v := range query {
+			nextQuery[k] = v
+		}
+		nextQuery.Set("offset", strconv.Itoa(fetched))
+		return fmt.Sprintf("%s/api/v2/flags/%s?%s", config.LdInstance, config.LdProject, nextQuery.Encode()), nil
+	}
+
+	return "", nil
+}
+
+func httpClient(config *lcr.Config) *http.Client {
+	if config.HTTPClient != nil {
+		return config.HTTPClient
+	}
+	return http.DefaultClient
 }
@@ -0,0 +1,215 @@
+package ldapi
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	ldapi "github.com/launchdarkly/api-client-go/v15"
+	lcr "github.com/launchdarkly/find-code-references-in-pull-request/config"
+	"github.com/launchdarkly/find-code-references-in-pull-request/internal/httpclient"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func ptr[T any](t T) *T { return &t }
+
+// Serves `total` active flags and `archived` archived flags for project `test`,
+// paginated according to the `limit` and `offset` query parameters. The first
+// `throttled` requests are rate limited.
+type flagServer struct {
+	total       int
+	archived    int
+	useNextLink bool
+	throttled   int
+	requests    []string
+}
+
+func (s *flagServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.requests = append(s.requests, r.URL.RequestURI())
+	if len(s.requests) <= s.throttled {
+		w.WriteHeader(http.StatusTooManyRequests)
+		_, _ = w.Write([]byte(`{"code":"rate_limited","message":"rate limited"}`))
+		return
+	}
+	if r.URL.Path != "/api/v2/flags/test" {
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte(`{"message":"not found"}`))
+		return
+	}
+
+	query := r.URL.Query()
+	limit, _ := strconv.Atoi(query.Get("limit"))
+	if limit == 0 {
+		limit = 20
+	}
+	offset, _ := strconv.Atoi(query.Get("offset"))
+
+	prefix, total := "flag", s.total
+	if query.Get("filter") == "state:archived" {
+		prefix, total = "archived", s.archived
+	}
+
+	items := make([]ldapi.FeatureFlag, 0, limit)
+	for i := offset; i < total && i < offset+limit; i++ {
+		items = append(items, ldapi.FeatureFlag{Key: fmt.Sprintf("%s-%d", prefix, i)})
+	}
+
+	resp := ldapi.FeatureFlags{
+		Items:      items,
+		Links:      map[string]ldapi.Link{},
+		TotalCount: ptr(int32(total)),
+	}
+	if s.useNextLink && offset+limit < total {
+		next := fmt.Sprintf("/api/v2/flags/test?limit=%d&offset=%d", limit, offset+limit)
+		if filter := query.Get("filter"); filter != "" {
+			next += "&filter=" + filter
+		}
+		resp.Links["next"] = ldapi.Link{Href: ptr(next)}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+func newTestConfig(url string) *lcr.Config {
+	return &lcr.Config{
+		LdProject:     "test",
+		LdEnvironment: "production",
+		LdInstance:    url,
+		ApiToken:      "api-token",
+		FlagsPageSize: 10,
+		MaxFlagPages:  100,
+	}
+}
+
+func flagKeys(flags []ldapi.FeatureFlag) []string {
+	keys := make([]string, 0, len(flags))
+	for _, f := range flags {
+		keys = append(keys, f.Key)
+	}
+	return keys
+}
+
+func TestGetAllFlags_followsNextLinks(t *testing.T) {
+	handler := &flagServer{total: 25, archived: 12, useNextLink: true}
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	config := newTestConfig(server.URL)
+	config.IncludeArchivedFlags = true
+
+	flags, err := GetAllFlags(context.Background(), config)
+	require.NoError(t, err)
+
+	keys := flagKeys(flags)
+	assert.Len(t, keys, 37)
+	assert.Contains(t, keys, "flag-0")
+	assert.Contains(t, keys, "flag-24")
+	assert.Contains(t, keys, "archived-11")
+	// 3 pages of active flags, 2 pages of archived flags
+	assert.Len(t, handler.requests, 5)
+	for _, req := range handler.requests {
+		assert.Contains(t, req, "env=production")
+	}
+}
+
+func TestGetAllFlags_multipleEnvironments(t *testing.T) {
+	handler := &flagServer{total: 25, useNextLink: true}
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	config := newTestConfig(server.URL)
+	config.LdEnvironments = []string{"production", "staging"}
+
+	flags, err := GetAllFlags(context.Background(), config)
+	require.NoError(t, err)
+
+	assert.Len(t, flags, 25)
+	require.Len(t, handler.requests, 3)
+	for _, req := range handler.requests {
+		assert.Contains(t, req, "env=production&env=staging")
+	}
+}
+
+func TestGetAllFlags_offsetWithoutNextLink(t *testing.T) {
+	handler := &flagServer{total: 30}
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	flags, err := GetAllFlags(context.Background(), newTestConfig(server.URL))
+	require.NoError(t, err)
+
+	assert.Len(t, flags, 30)
+	assert.Len(t, handler.requests, 3)
+	assert.Equal(t, "flag-29", flags[29].Key)
+}
+
+func TestGetAllFlags_stopsAtMaxPages(t *testing.T) {
+	handler := &flagServer{total: 50, useNextLink: true}
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	config := newTestConfig(server.URL)
+	config.MaxFlagPages = 2
+
+	flags, err := GetAllFlags(context.Background(), config)
+	require.NoError(t, err)
+
+	assert.Len(t, flags, 20)
+	assert.Len(t, handler.requests, 2)
+}
+
+func TestGetAllFlags_singlePage(t *testing.T) {
+	handler := &flagServer{total: 3, useNextLink: true}
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	flags, err := GetAllFlags(context.Background(), newTestConfig(server.URL))
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"flag-0", "flag-1", "flag-2"}, flagKeys(flags))
+	assert.Len(t, handler.requests, 1)
+}
+
+func TestGetAllFlags_errorStatus(t *testing.T) {
+	handler := &flagServer{total: 3}
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	config := newTestConfig(server.URL)
+	config.LdProject = "missing"
+
+	_, err := GetAllFlags(context.Background(), config)
+	assert.ErrorContains(t, err, "unexpected status code: 404")
+}
+
+func TestGetAllFlags_retriesRateLimited(t *testing.T) {
+	handler := &flagServer{total: 15, throttled: 2}
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	config := newTestConfig(server.URL)
+	config.HTTPClient = httpclient.NewClient(httpclient.Options{MaxRetries: 3, BaseDelay: time.Millisecond})
+
+	flags, err := GetAllFlags(context.Background(), config)
+	require.NoError(t, err)
+
+	assert.Len(t, flags, 15)
+	// 2 rate limited requests, then 2 pages
+	assert.Len(t, handler.requests, 4)
+}
+
+func TestGetAllFlags_rateLimitedWithoutRetries(t *testing.T) {
+	handler := &flagServer{total: 15, throttled: 1}
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	_, err := GetAllFlags(context.Background(), newTestConfig(server.URL))
+	assert.ErrorContains(t, err, "unexpected status code: 429")
+}
@@ -0,0 +1,71 @@
+package ldapi
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+
+	ldapi "github.com/launchdarkly/api-client-go/v15"
+	lcr "github.com/launchdarkly/find-code-references-in-pull-request/config"
+	gha "github.com/launchdarkly/find-code-references-in-pull-request/internal/github_actions"
+	"github.com/pkg/errors"
+)
+
+// Read the project's flags from config.FlagsFile instead of the API. The file is a JSON
+// export in the shape of the API's flag list response, used for every project, or a
+// snapshot directory with a <project-key>.json file for each project.
+func ReadFlagsFile(config *lcr.Config) ([]ldapi.FeatureFlag, error) {
+	path := config.FlagsFile
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading flags file")
+	}
+	if info.IsDir() {
+		path = SnapshotPath(path, config.LdProject)
+	}
+
+	gha.Debug("Reading flags for project %s from %s", config.LdProject, path)
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading flags file")
+	}
+	var featureFlags ldapi.FeatureFlags
+	if err := json.Unmarshal(b, &featureFlags); err != nil {
+		return nil, errors.Wrapf(err, "parsing flags file %s", path)
+	}
+
+	flags := make([]ldapi.FeatureFlag, 0, len(featureFlags.Items))
+	for _, flag := range featureFlags.Items {
+		if flag.Archived && !config.IncludeArchivedFlags {
+			continue
+		}
+		flags = append(flags, flag)
+	}
+
+	gha.Debug("Read %d flags", len(flags))
+	return flags, nil
+}
+
+// Path of the project's flags in a snapshot directory
+func SnapshotPath(dir, projectKey string) string {
+	return filepath.Join(dir, projectKey+".json")
+}
+
+// Write the project's flags to a snapshot directory, in the shape read by ReadFlagsFile
+func WriteSnapshot(dir, projectKey string, flags []ldapi.FeatureFlag) (string, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+
+	totalCount := int32(len(flags))
+	b, err := json.MarshalIndent(ldapi.FeatureFlags{Items: flags, TotalCount: &totalCount}, "", "  ")
+	if err != nil {
+		return "", err
+	}
+
+	path := SnapshotPath(dir, projectKey)
+	if err := os.WriteFile(path, append(b, '\n'), 0o644); err != nil {
+		return "", err
+	}
+	return path, nil
+}
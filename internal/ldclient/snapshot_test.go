@@ -0,0 +1,63 @@
+package ldapi
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	ldapi "github.com/launchdarkly/api-client-go/v15"
+	lcr "github.com/launchdarkly/find-code-references-in-pull-request/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadFlagsFile_snapshot(t *testing.T) {
+	dir := t.TempDir()
+	flags := []ldapi.FeatureFlag{
+		{Key: "active-flag", Name: "Active flag"},
+		{Key: "archived-flag", Archived: true},
+	}
+	path, err := WriteSnapshot(dir, "test", flags)
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(dir, "test.json"), path)
+
+	config := &lcr.Config{LdProject: "test", FlagsFile: dir, IncludeArchivedFlags: true}
+	got, err := GetAllFlags(context.Background(), config)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"active-flag", "archived-flag"}, flagKeys(got))
+	assert.Equal(t, "Active flag", got[0].Name)
+
+	config.IncludeArchivedFlags = false
+	got, err = GetAllFlags(context.Background(), config)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"active-flag"}, flagKeys(got))
+
+	// other projects aren't in the snapshot
+	_, err = GetAllFlags(context.Background(), config.ForProject("other"))
+	assert.Error(t, err)
+}
+
+func TestReadFlagsFile_export(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "flags.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{"items":[{"key":"flag-1"},{"key":"flag-2"}],"totalCount":2}`), 0o644))
+
+	// an export is used for every project
+	for _, project := range []string{"test", "other"} {
+		got, err := ReadFlagsFile(&lcr.Config{LdProject: project, FlagsFile: path, IncludeArchivedFlags: true})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"flag-1", "flag-2"}, flagKeys(got))
+	}
+}
+
+func TestReadFlagsFile_errors(t *testing.T) {
+	dir := t.TempDir()
+
+	_, err := ReadFlagsFile(&lcr.Config{LdProject: "test", FlagsFile: filepath.Join(dir, "missing.json")})
+	assert.ErrorContains(t, err, "reading flags file")
+
+	invalid := filepath.Join(dir, "invalid.json")
+	require.NoError(t, os.WriteFile(invalid, []byte(`[`), 0o644))
+	_, err = ReadFlagsFile(&lcr.Config{LdProject: "test", FlagsFile: invalid})
+	assert.ErrorContains(t, err, "parsing flags file")
+}
@@ -0,0 +1,69 @@
+package policies
+
+import (
+	"fmt"
+	"strings"
+
+	ldapi "github.com/launchdarkly/api-client-go/v15"
+	lcr "github.com/launchdarkly/find-code-references-in-pull-request/config"
+	refs "github.com/launchdarkly/find-code-references-in-pull-request/internal/references"
+)
+
+// A policy that failed, along with the flags that caused it to fail
+type Violation struct {
+	Policy   string // name of the input enabling the policy
+	Message  string
+	FlagKeys []string
+}
+
+func (v Violation) String() string {
+	return fmt.Sprintf("%s: %s (%s)", v.Message, strings.Join(v.FlagKeys, ", "), v.Policy)
+}
+
+// Evaluate the enabled policies against the flag references found in the diff
+func Evaluate(config *lcr.Config, flagsRef refs.ReferenceSummary, flags []ldapi.FeatureFlag) []Violation {
+	flagsByKey := make(map[string]ldapi.FeatureFlag, len(flags))
+	for _, flag := range flags {
+		flagsByKey[flag.Key] = flag
+	}
+
+	var archived, deprecated []string
+	for _, flagKey := range flagsRef.AddedKeys() {
+		flag, ok := flagsByKey[flagKey]
+		if !ok {
+			continue
+		}
+		// a flag can be both, counting toward each policy
+		if flag.Archived {
+			archived = append(archived, flagKey)
+		}
+		if flag.Deprecated {
+			deprecated = append(deprecated, flagKey)
+		}
+	}
+
+	violations := make([]Violation, 0)
+	if config.FailOnArchivedAdded && len(archived) > 0 {
+		violations = append(violations, Violation{
+			Policy:   "fail-on-archived-added",
+			Message:  "References to archived flags added",
+			FlagKeys: archived,
+		})
+	}
+	if config.FailOnDeprecatedAdded && len(deprecated) > 0 {
+		violations = append(violations, Violation{
+			Policy:   "fail-on-deprecated-added",
+			Message:  "References to deprecated flags added",
+			FlagKeys: deprecated,
+		})
+	}
+	if config.FailOnUnknownFlags && len(flagsRef.UnknownFlags) > 0 {
+		violations = append(violations, Violation{
+			Policy:   "fail-on-unknown-flag-like-strings",
+			Message:  "Flag keys evaluated that don't exist in LaunchDarkly",
+			FlagKeys: flagsRef.UnknownKeys(),
+		})
+	}
+
+	return violations
+}
@@ -0,0 +1,92 @@
+package policies
+
+import (
+	"testing"
+
+	ldapi "github.com/launchdarkly/api-client-go/v15"
+	lcr "github.com/launchdarkly/find-code-references-in-pull-request/config"
+	refs "github.com/launchdarkly/find-code-references-in-pull-request/internal/references"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEvaluate_unknownFlags(t *testing.T) {
+	flagsRef := refs.ReferenceSummary{
+		UnknownFlags: []refs.UnknownFlag{{Key: "exmaple-flag", Suggestions: []string{"example-flag"}}},
+	}
+
+	assert.Empty(t, Evaluate(&lcr.Config{DetectUnknownFlags: true}, flagsRef, nil))
+	assert.Equal(t, []Violation{
+		{Policy: "fail-on-unknown-flag-like-strings", Message: "Flag keys evaluated that don't exist in LaunchDarkly", FlagKeys: []string{"exmaple-flag"}},
+	}, Evaluate(&lcr.Config{FailOnUnknownFlags: true}, flagsRef, nil))
+}
+
+func TestEvaluate(t *testing.T) {
+	flags := []ldapi.FeatureFlag{
+		{Key: "example-flag"},
+		{Key: "archived-flag", Archived: true},
+		{Key: "deprecated-flag", Deprecated: true},
+		{Key: "archived-deprecated-flag", Archived: true, Deprecated: true},
+	}
+	flagsRef := refs.ReferenceSummary{
+		FlagsAdded: refs.FlagAliasMap{
+			"example-flag":             {},
+			"archived-flag":            {},
+			"deprecated-flag":          {},
+			"archived-deprecated-flag": {},
+		},
+	}
+
+	cases := []struct {
+		name     string
+		config   lcr.Config
+		expected []Violation
+	}{
+		{
+			name:     "no policies enabled",
+			expected: []Violation{},
+		},
+		{
+			name:   "fail on archived",
+			config: lcr.Config{FailOnArchivedAdded: true},
+			expected: []Violation{
+				{Policy: "fail-on-archived-added", Message: "References to archived flags added", FlagKeys: []string{"archived-deprecated-flag", "archived-flag"}},
+			},
+		},
+		{
+			name:   "fail on deprecated",
+			config: lcr.Config{FailOnDeprecatedAdded: true},
+			expected: []Violation{
+				{Policy: "fail-on-deprecated-added", Message: "References to deprecated flags added", FlagKeys: []string{"archived-deprecated-flag", "deprecated-flag"}},
+			},
+		},
+		{
+			name:   "fail on archived and deprecated",
+			config: lcr.Config{FailOnArchivedAdded: true, FailOnDeprecatedAdded: true},
+			expected: []Violation{
+				{Policy: "fail-on-archived-added", Message: "References to archived flags added", FlagKeys: []string{"archived-deprecated-flag", "archived-flag"}},
+				{Policy: "fail-on-deprecated-added", Message: "References to deprecated flags added", FlagKeys: []string{"archived-deprecated-flag", "deprecated-flag"}},
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, Evaluate(&tc.config, flagsRef, flags))
+		})
+	}
+}
+
+func TestEvaluate_removedFlagsIgnored(t *testing.T) {
+	flagsRef := refs.ReferenceSummary{
+		FlagsRemoved: refs.FlagAliasMap{"archived-flag": {}, "deprecated-flag": {}},
+	}
+	flags := []ldapi.FeatureFlag{{Key: "archived-flag", Archived: true}, {Key: "deprecated-flag", Deprecated: true}}
+	config := lcr.Config{FailOnArchivedAdded: true, FailOnDeprecatedAdded: true}
+
+	assert.Empty(t, Evaluate(&config, flagsRef, flags))
+}
+
+func TestViolation_String(t *testing.T) {
+	v := Violation{Policy: "fail-on-archived-added", Message: "References to archived flags added", FlagKeys: []string{"a", "b"}}
+	assert.Equal(t, "References to archived flags added: a, b (fail-on-archived-added)", v.String())
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"sort"
 	"strings"
+	"sync"
 
 	"github.com/launchdarkly/find-code-references-in-pull-request/internal/utils/diff_util"
 )
@@ -13,34 +14,41 @@ type refCounts struct {
 	deletes int
 }
 
+// Builds a ReferenceSummary from references found in the diff. Safe for concurrent use.
 type ReferenceSummaryBuilder struct {
-	max                int  // maximum number of flags to find
-	includeExtinctions bool // include extinctions in summary
-	flagsAdded         map[string][]string
-	flagsRemoved       map[string][]string
-	flagsFoundAtHead   map[string]struct{}
-	foundFlags         map[string]struct{}
-	counts             map[string]refCounts
+	mu                   sync.Mutex
+	includeExtinctions   bool // include extinctions in summary
+	includeIntroductions bool // include introduced flags in summary
+	flagsAdded           map[string][]string
+	flagsRemoved         map[string][]string
+	flagsFoundAtHead     map[string]struct{}
+	flagsFoundAtBase     map[string]struct{}
+	foundFlags           map[string]struct{}
+	counts               map[string]refCounts
+	references           map[string][]ReferenceLocation
+	unknownFlags         map[string]*UnknownFlag
 }
 
-func NewReferenceSummaryBuilder(max int, includeExtinctions bool) *ReferenceSummaryBuilder {
+func NewReferenceSummaryBuilder(includeExtinctions, includeIntroductions bool) *ReferenceSummaryBuilder {
 	return &ReferenceSummaryBuilder{
-		flagsAdded:         make(map[string][]string),
-		flagsRemoved:       make(map[string][]string),
-		foundFlags:         make(map[string]struct{}),
-		flagsFoundAtHead:   make(map[string]struct{}),
-		counts:             make(map[string]refCounts),
-		max:                max,
-		includeExtinctions: includeExtinctions,
+		flagsAdded:           make(map[string][]string),
+		flagsRemoved:         make(map[string][]string),
+		foundFlags:           make(map[string]struct{}),
+		flagsFoundAtHead:     make(map[string]struct{}),
+		flagsFoundAtBase:     make(map[string]struct{}),
+		counts:               make(map[string]refCounts),
+		references:           make(map[string][]ReferenceLocation),
+		unknownFlags:         make(map[string]*UnknownFlag),
+		includeExtinctions:   includeExtinctions,
+		includeIntroductions: includeIntroductions,
 	}
 }
 
-func (b *ReferenceSummaryBuilder) MaxReferences() bool {
-	return len(b.foundFlags) >= b.max
-}
-
 // Add a found flag in diff by operation
-func (b *ReferenceSummaryBuilder) AddReference(flagKey string, op diff_util.Operation, aliases []string) error {
+func (b *ReferenceSummaryBuilder) AddReference(flagKey string, op diff_util.Operation, aliases []string, location ReferenceLocation) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
 	switch op {
 	case diff_util.OperationAdd:
 		b.addedFlag(flagKey, aliases)
@@ -50,16 +58,58 @@ func (b *ReferenceSummaryBuilder) AddReference(flagKey string, op diff_util.Oper
 		return fmt.Errorf("invalid operation=%s", op.String())
 	}
 
+	location.Operation = op
+	b.references[flagKey] = append(b.references[flagKey], location)
+
 	return nil
 }
 
+// Add a key evaluated in an added line that doesn't match a flag
+func (b *ReferenceSummaryBuilder) AddUnknownFlag(key string, suggestions []string, location ReferenceLocation) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	unknown, ok := b.unknownFlags[key]
+	if !ok {
+		unknown = &UnknownFlag{Key: key, Suggestions: suggestions}
+		b.unknownFlags[key] = unknown
+	}
+	location.Operation = diff_util.OperationAdd
+	unknown.Locations = append(unknown.Locations, location)
+}
+
 // Flag found in HEAD ref
 func (b *ReferenceSummaryBuilder) AddHeadFlag(flagKey string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
 	if _, ok := b.flagsFoundAtHead[flagKey]; !ok {
 		b.flagsFoundAtHead[flagKey] = struct{}{}
 	}
 }
 
+// Flag found in the base ref, so it was already referenced before the change
+func (b *ReferenceSummaryBuilder) AddBaseFlag(flagKey string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.flagsFoundAtBase[flagKey] = struct{}{}
+}
+
+// Reference to flag found in HEAD ref at path and line. A reference on a line that was
+// not added in the diff was already there before the change.
+func (b *ReferenceSummaryBuilder) AddHeadReference(flagKey, path string, line int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, location := range b.references[flagKey] {
+		if location.Operation == diff_util.OperationAdd && location.Path == path && location.Line == line {
+			return
+		}
+	}
+	b.flagsFoundAtBase[flagKey] = struct{}{}
+}
+
 func (b *ReferenceSummaryBuilder) foundFlag(flagKey string) {
 	if _, ok := b.foundFlags[flagKey]; !ok {
 		b.foundFlags[flagKey] = struct{}{}
@@ -92,6 +142,9 @@ func (b *ReferenceSummaryBuilder) removedFlag(flagKey string, aliases []string)
 
 // Returns a list of removed flag keys
 func (b *ReferenceSummaryBuilder) RemovedFlagKeys() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
 	keys := make([]string, 0, len(b.flagsRemoved))
 	for k, counts := range b.counts {
 		if counts.deletes > 0 && counts.adds == 0 {
@@ -101,10 +154,30 @@ func (b *ReferenceSummaryBuilder) RemovedFlagKeys() []string {
 	return keys
 }
 
+// Returns a list of flag keys with references added and none removed, which may be new to the codebase
+func (b *ReferenceSummaryBuilder) AddedFlagKeys() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	keys := make([]string, 0, len(b.flagsAdded))
+	for k, counts := range b.counts {
+		if counts.adds > 0 && counts.deletes == 0 {
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
+
 func (b *ReferenceSummaryBuilder) Build() ReferenceSummary {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
 	added := make(map[string][]string, len(b.flagsAdded))
 	removed := make(map[string][]string, len(b.flagsRemoved))
 	extinctions := make(map[string]struct{}, len(b.flagsRemoved))
+	introduced := make(map[string]struct{}, len(b.flagsAdded))
+	moved := make(map[string]struct{})
+	references := make(FlagReferenceMap, len(b.foundFlags))
 
 	for flagKey := range b.foundFlags {
 		counts := b.counts[flagKey]
@@ -114,6 +187,13 @@ func (b *ReferenceSummaryBuilder) Build() ReferenceSummary {
 			aliases = uniqueStrs(aliases)
 			sort.Strings(aliases)
 			added[flagKey] = aliases
+			if counts.deletes == 0 {
+				if _, ok := b.flagsFoundAtBase[flagKey]; !ok {
+					introduced[flagKey] = struct{}{}
+				}
+			} else if isMoved(b.references[flagKey]) {
+				moved[flagKey] = struct{}{}
+			}
 		case counts.deletes > 0:
 			aliases := uniqueStrs(b.flagsRemoved[flagKey])
 			sort.Strings(aliases)
@@ -122,20 +202,76 @@ func (b *ReferenceSummaryBuilder) Build() ReferenceSummary {
 				extinctions[flagKey] = struct{}{}
 			}
 		}
+
+		if locations := b.references[flagKey]; len(locations) > 0 {
+			references[flagKey] = sortedLocations(locations)
+		}
 	}
 
 	summary := ReferenceSummary{
 		FlagsAdded:   added,
 		FlagsRemoved: removed,
+		MovedFlags:   moved,
+		UnknownFlags: b.buildUnknownFlags(),
+		References:   references,
 	}
 
 	if b.includeExtinctions {
 		summary.ExtinctFlags = extinctions
 	}
+	if b.includeIntroductions {
+		summary.IntroducedFlags = introduced
+	}
 
 	return summary
 }
 
+// unknown flags sorted by key, with sorted locations
+func (b *ReferenceSummaryBuilder) buildUnknownFlags() []UnknownFlag {
+	if len(b.unknownFlags) == 0 {
+		return nil
+	}
+	unknown := make([]UnknownFlag, 0, len(b.unknownFlags))
+	for _, u := range b.unknownFlags {
+		unknown = append(unknown, UnknownFlag{Key: u.Key, Suggestions: u.Suggestions, Locations: sortedLocations(u.Locations)})
+	}
+	sort.Slice(unknown, func(i, j int) bool {
+		return unknown[i].Key < unknown[j].Key
+	})
+	return unknown
+}
+
+// whether references were removed in one place and added in another, with no hunk
+// both adding and removing a reference
+func isMoved(locations []ReferenceLocation) bool {
+	ops := make(map[string]diff_util.Operation, len(locations))
+	for _, l := range locations {
+		hunk := l.Path + " " + l.Hunk
+		if op, ok := ops[hunk]; ok && op != l.Operation {
+			return false
+		}
+		ops[hunk] = l.Operation
+	}
+	return true
+}
+
+// get a sorted copy of locations, ordered by path, line and operation
+func sortedLocations(locations []ReferenceLocation) []ReferenceLocation {
+	sorted := make([]ReferenceLocation, len(locations))
+	copy(sorted, locations)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		a, b := sorted[i], sorted[j]
+		if a.Path != b.Path {
+			return a.Path < b.Path
+		}
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+		return a.Operation < b.Operation
+	})
+	return sorted
+}
+
 // get slice with unique, non-empty strings
 func uniqueStrs(s []string) []string {
 	if len(s) <= 1 {
@@ -80,9 +80,9 @@ func TestBuilder_RemovedFlagKeys(t *testing.T) {
 }
 
 func TestBuilder_Build_netZeroChurnIsModified(t *testing.T) {
-	builder := NewReferenceSummaryBuilder(5, false)
-	assert.NoError(t, builder.AddReference("my-flag", diff_util.OperationDelete, nil))
-	assert.NoError(t, builder.AddReference("my-flag", diff_util.OperationAdd, nil))
+	builder := NewReferenceSummaryBuilder(false, false)
+	assert.NoError(t, builder.AddReference("my-flag", diff_util.OperationDelete, nil, ReferenceLocation{Path: "main.go", Line: 3}))
+	assert.NoError(t, builder.AddReference("my-flag", diff_util.OperationAdd, nil, ReferenceLocation{Path: "main.go", Line: 3}))
 
 	built := builder.Build()
 
@@ -90,3 +90,60 @@ func TestBuilder_Build_netZeroChurnIsModified(t *testing.T) {
 	assert.NotContains(t, built.FlagsRemoved, "my-flag")
 	assert.Empty(t, builder.RemovedFlagKeys())
 }
+
+func TestBuilder_Build_referenceLocations(t *testing.T) {
+	builder := NewReferenceSummaryBuilder(false, false)
+	assert.NoError(t, builder.AddReference("flag1", diff_util.OperationAdd, nil, ReferenceLocation{Path: "b.go", Line: 1}))
+	assert.NoError(t, builder.AddReference("flag1", diff_util.OperationAdd, nil, ReferenceLocation{Path: "a.go", Line: 7}))
+	assert.NoError(t, builder.AddReference("flag1", diff_util.OperationDelete, nil, ReferenceLocation{Path: "a.go", Line: 2}))
+	assert.NoError(t, builder.AddReference("flag2", diff_util.OperationDelete, nil, ReferenceLocation{Path: "c.go", Line: 4}))
+
+	built := builder.Build()
+
+	assert.Equal(t, []ReferenceLocation{
+		{Path: "a.go", Line: 2, Operation: diff_util.OperationDelete},
+		{Path: "a.go", Line: 7, Operation: diff_util.OperationAdd},
+		{Path: "b.go", Line: 1, Operation: diff_util.OperationAdd},
+	}, built.Locations("flag1"))
+	assert.Equal(t, []ReferenceLocation{
+		{Path: "c.go", Line: 4, Operation: diff_util.OperationDelete},
+	}, built.Locations("flag2"))
+	assert.Len(t, built.LocationsByOperation("flag1", diff_util.OperationAdd), 2)
+	assert.Empty(t, built.LocationsByOperation("flag2", diff_util.OperationAdd))
+}
+
+func TestBuilder_Build_changeTypes(t *testing.T) {
+	builder := NewReferenceSummaryBuilder(false, true)
+	// only added
+	assert.NoError(t, builder.AddReference("new-flag", diff_util.OperationAdd, nil, ReferenceLocation{Path: "a.go", Line: 1, Hunk: "@@ -1,1 +1,2 @@"}))
+	// added next to an existing reference
+	assert.NoError(t, builder.AddReference("existing-flag", diff_util.OperationAdd, nil, ReferenceLocation{Path: "a.go", Line: 2, Hunk: "@@ -1,1 +1,2 @@"}))
+	// reformatted in place
+	assert.NoError(t, builder.AddReference("modified-flag", diff_util.OperationDelete, nil, ReferenceLocation{Path: "b.go", Line: 5, Hunk: "@@ -5,1 +5,1 @@"}))
+	assert.NoError(t, builder.AddReference("modified-flag", diff_util.OperationAdd, nil, ReferenceLocation{Path: "b.go", Line: 5, Hunk: "@@ -5,1 +5,1 @@"}))
+	// removed from one file and added to another
+	assert.NoError(t, builder.AddReference("moved-flag", diff_util.OperationDelete, nil, ReferenceLocation{Path: "b.go", Line: 9, Hunk: "@@ -9,1 +8,0 @@"}))
+	assert.NoError(t, builder.AddReference("moved-flag", diff_util.OperationAdd, nil, ReferenceLocation{Path: "c.go", Line: 3, Hunk: "@@ -2,0 +3,1 @@"}))
+
+	assert.ElementsMatch(t, []string{"new-flag", "existing-flag"}, builder.AddedFlagKeys())
+	builder.AddHeadReference("new-flag", "a.go", 1)
+	builder.AddHeadReference("existing-flag", "a.go", 2)
+	builder.AddHeadReference("existing-flag", "d.go", 12)
+
+	built := builder.Build()
+
+	assert.Len(t, built.FlagsAdded, 4)
+	assert.Equal(t, []string{"new-flag"}, built.IntroducedKeys())
+	assert.Equal(t, []string{"moved-flag"}, built.MovedKeys())
+	assert.Equal(t, []string{"existing-flag", "modified-flag"}, built.ModifiedKeys())
+}
+
+func TestBuilder_Build_introductionsNotChecked(t *testing.T) {
+	builder := NewReferenceSummaryBuilder(false, false)
+	assert.NoError(t, builder.AddReference("new-flag", diff_util.OperationAdd, nil, ReferenceLocation{Path: "a.go", Line: 1}))
+
+	built := builder.Build()
+
+	assert.Nil(t, built.IntroducedFlags)
+	assert.Equal(t, []string{"new-flag"}, built.ModifiedKeys())
+}
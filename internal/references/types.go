@@ -1,17 +1,76 @@
 package flags
 
-import "sort"
+import (
+	"fmt"
+	"net/url"
+	"sort"
+
+	"github.com/launchdarkly/find-code-references-in-pull-request/internal/utils/diff_util"
+)
 
 type FlagAliasMap = map[string][]string
 
+// Location of a single flag reference in the diff
+type ReferenceLocation struct {
+	Path      string              // file path relative to the workspace
+	OrigPath  string              // path before the file was renamed or copied, empty otherwise
+	Line      int                 // line number in the new file for additions, or in the original file for removals
+	HeadLine  int                 // line number in the new file where the reference was added or removed
+	Operation diff_util.Operation // whether the referencing line was added or removed
+	Hunk      string              // header of the hunk containing the reference
+}
+
+func (l ReferenceLocation) String() string {
+	return fmt.Sprintf("%s:%d", l.Path, l.Line)
+}
+
+// URL of the referencing line in the repository at the commit sha. Only references that were
+// added are on a line in that commit, so the URL is empty for removed references.
+func (l ReferenceLocation) BlobURL(repoURL, sha string) string {
+	if repoURL == "" || sha == "" || l.Operation != diff_util.OperationAdd {
+		return ""
+	}
+	path := (&url.URL{Path: l.Path}).EscapedPath()
+	return fmt.Sprintf("%s/blob/%s/%s#L%d", repoURL, sha, path, l.Line)
+}
+
+// Number of references to a flag in a file
+type FileCount struct {
+	Path  string
+	Count int
+}
+
+// Reference locations by flag key
+type FlagReferenceMap = map[string][]ReferenceLocation
+
+// Key evaluated by an SDK call in the diff that doesn't match a flag in the project
+type UnknownFlag struct {
+	Key         string
+	Suggestions []string // closest existing flag keys
+	Locations   []ReferenceLocation
+}
+
 type ReferenceSummary struct {
-	FlagsAdded   FlagAliasMap
-	FlagsRemoved FlagAliasMap
-	ExtinctFlags map[string]struct{}
+	FlagsAdded      FlagAliasMap
+	FlagsRemoved    FlagAliasMap
+	ExtinctFlags    map[string]struct{}
+	IntroducedFlags map[string]struct{} // added flags with no references before the change, nil if not checked
+	MovedFlags      map[string]struct{} // added flags with references removed in one place and added in another
+	UnknownFlags    []UnknownFlag       // sorted by key
+	References      FlagReferenceMap
 }
 
 func (fr ReferenceSummary) AnyFound() bool {
-	return len(fr.FlagsAdded)+len(fr.FlagsRemoved) > 0
+	return len(fr.FlagsAdded)+len(fr.FlagsRemoved)+len(fr.UnknownFlags) > 0
+}
+
+// returns a sorted list of unknown flag keys
+func (fr ReferenceSummary) UnknownKeys() []string {
+	keys := make([]string, 0, len(fr.UnknownFlags))
+	for _, f := range fr.UnknownFlags {
+		keys = append(keys, f.Key)
+	}
+	return keys
 }
 
 // returns a sorted list of all added flag keys
@@ -26,22 +85,86 @@ func (fr ReferenceSummary) RemovedKeys() []string {
 
 // returns a sorted list of all extinct flag keys
 func (fr ReferenceSummary) ExtinctKeys() []string {
-	if fr.ExtinctFlags == nil {
+	return setKeys(fr.ExtinctFlags)
+}
+
+func (fr ReferenceSummary) IsExtinct(key string) bool {
+	_, ok := fr.ExtinctFlags[key]
+	return ok
+}
+
+// returns a sorted list of added flag keys with no references before the change
+func (fr ReferenceSummary) IntroducedKeys() []string {
+	return setKeys(fr.IntroducedFlags)
+}
+
+func (fr ReferenceSummary) IsIntroduced(key string) bool {
+	_, ok := fr.IntroducedFlags[key]
+	return ok
+}
+
+// returns a sorted list of added flag keys with references moved from one place to another
+func (fr ReferenceSummary) MovedKeys() []string {
+	return setKeys(fr.MovedFlags)
+}
+
+func (fr ReferenceSummary) IsMoved(key string) bool {
+	_, ok := fr.MovedFlags[key]
+	return ok
+}
+
+// returns a sorted list of added flag keys that are neither introduced nor moved
+func (fr ReferenceSummary) ModifiedKeys() []string {
+	keys := make([]string, 0, len(fr.FlagsAdded))
+	for _, k := range fr.AddedKeys() {
+		if !fr.IsIntroduced(k) && !fr.IsMoved(k) {
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
+
+// returns all reference locations for a flag, sorted by path and line
+func (fr ReferenceSummary) Locations(key string) []ReferenceLocation {
+	return fr.References[key]
+}
+
+// returns the number of references to a flag in each file, sorted by path
+func (fr ReferenceSummary) FileCounts(key string) []FileCount {
+	counts := make([]FileCount, 0)
+	for _, l := range fr.References[key] {
+		if n := len(counts); n > 0 && counts[n-1].Path == l.Path {
+			counts[n-1].Count++
+			continue
+		}
+		counts = append(counts, FileCount{Path: l.Path, Count: 1})
+	}
+	return counts
+}
+
+// returns reference locations for a flag with the given operation, sorted by path and line
+func (fr ReferenceSummary) LocationsByOperation(key string, op diff_util.Operation) []ReferenceLocation {
+	locations := make([]ReferenceLocation, 0, len(fr.References[key]))
+	for _, l := range fr.References[key] {
+		if l.Operation == op {
+			locations = append(locations, l)
+		}
+	}
+	return locations
+}
+
+func setKeys(set map[string]struct{}) []string {
+	if set == nil {
 		return nil
 	}
-	keys := make([]string, 0, len(fr.ExtinctFlags))
-	for k := range fr.ExtinctFlags {
+	keys := make([]string, 0, len(set))
+	for k := range set {
 		keys = append(keys, k)
 	}
 	sort.Strings(keys)
 	return keys
 }
 
-func (fr ReferenceSummary) IsExtinct(key string) bool {
-	_, ok := fr.ExtinctFlags[key]
-	return ok
-}
-
 func (fr ReferenceSummary) sortedKeys(keys map[string][]string) []string {
 	sortedKeys := make([]string, 0, len(keys))
 	for k := range keys {
@@ -0,0 +1,274 @@
+package report
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	ldapi "github.com/launchdarkly/api-client-go/v15"
+	lcr "github.com/launchdarkly/find-code-references-in-pull-request/config"
+	refs "github.com/launchdarkly/find-code-references-in-pull-request/internal/references"
+	"github.com/launchdarkly/find-code-references-in-pull-request/internal/scan"
+	"github.com/launchdarkly/find-code-references-in-pull-request/internal/utils/diff_util"
+)
+
+const (
+	FormatJSON  = "json"
+	FormatSARIF = "sarif"
+
+	// Bumped when fields are removed or change meaning
+	SchemaVersion = 1
+)
+
+// Change types of a flag, matching the sections of the PR comment and the introduced-flags and
+// moved-flags outputs
+const (
+	ChangeTypeIntroduced = "introduced" // references added to a flag with no references before the change
+	ChangeTypeModified   = "modified"   // references added to a flag that was neither introduced nor moved
+	ChangeTypeMoved      = "moved"      // references removed in one place and added in another
+	ChangeTypeRemoved    = "removed"    // references only removed
+)
+
+// Operations of a reference location
+const (
+	operationAdded   = "added"
+	operationRemoved = "removed"
+)
+
+// Report of all flag references found in the diff
+type Report struct {
+	SchemaVersion int                 `json:"schemaVersion"`
+	Projects      []string            `json:"projects"`
+	Environments  []string            `json:"environments"`
+	Flags         []FlagReport        `json:"flags"`
+	UnknownFlags  []UnknownFlagReport `json:"unknownFlags,omitempty"` // only set when unknown flags are detected
+}
+
+type FlagReport struct {
+	Project      string                       `json:"project"`
+	Key          string                       `json:"key"`
+	Name         string                       `json:"name"`
+	ChangeType   string                       `json:"changeType"`
+	Aliases      []string                     `json:"aliases"`
+	Extinct      *bool                        `json:"extinct,omitempty"` // only set for removed flags when extinctions are checked
+	Archived     bool                         `json:"archived"`
+	ArchivedAt   *time.Time                   `json:"archivedAt,omitempty"`
+	Deprecated   bool                         `json:"deprecated"`
+	DeprecatedAt *time.Time                   `json:"deprecatedAt,omitempty"`
+	Environments map[string]EnvironmentReport `json:"environments,omitempty"` // keyed by environment, for the configured environments the flag is in
+	Locations    []Location                   `json:"locations"`
+}
+
+// State of a flag in one of the configured environments
+type EnvironmentReport struct {
+	On  bool   `json:"on"`
+	URL string `json:"url,omitempty"`
+}
+
+// Key evaluated in the diff that doesn't match a flag in the project
+type UnknownFlagReport struct {
+	Project     string     `json:"project"`
+	Key         string     `json:"key"`
+	Suggestions []string   `json:"suggestions"`
+	Locations   []Location `json:"locations"`
+}
+
+type Location struct {
+	Path      string `json:"path"`
+	OrigPath  string `json:"origPath,omitempty"` // path before the file was renamed or copied
+	Line      int    `json:"line"`
+	HeadLine  int    `json:"headLine"`
+	Operation string `json:"operation"`
+	Hunk      string `json:"hunk,omitempty"`
+}
+
+// Build a report of every added or removed flag, sorted by project and flag key
+func Build(config *lcr.Config, projects []scan.Project) Report {
+	report := Report{
+		SchemaVersion: SchemaVersion,
+		Projects:      scan.ProjectKeys(projects),
+		Environments:  config.LdEnvironments,
+		Flags:         make([]FlagReport, 0),
+	}
+	for _, project := range projects {
+		report.Flags = append(report.Flags, buildFlags(project)...)
+		report.UnknownFlags = append(report.UnknownFlags, buildUnknownFlags(project)...)
+	}
+	return report
+}
+
+func buildUnknownFlags(project scan.Project) []UnknownFlagReport {
+	unknownReports := make([]UnknownFlagReport, 0, len(project.References.UnknownFlags))
+	for _, unknown := range project.References.UnknownFlags {
+		unknownReport := UnknownFlagReport{
+			Project:     project.Key,
+			Key:         unknown.Key,
+			Suggestions: append([]string{}, unknown.Suggestions...),
+			Locations:   make([]Location, 0, len(unknown.Locations)),
+		}
+		for _, location := range unknown.Locations {
+			unknownReport.Locations = append(unknownReport.Locations, newLocation(location))
+		}
+		unknownReports = append(unknownReports, unknownReport)
+	}
+	return unknownReports
+}
+
+func buildFlags(project scan.Project) []FlagReport {
+	config, flagsRef := project.Config, project.References
+	flagsByKey := make(map[string]ldapi.FeatureFlag, len(project.Flags))
+	for _, flag := range project.Flags {
+		flagsByKey[flag.Key] = flag
+	}
+
+	flagReports := make([]FlagReport, 0, len(flagsRef.FlagsAdded)+len(flagsRef.FlagsRemoved))
+	for _, flagKey := range changedKeys(flagsRef) {
+		flag := flagsByKey[flagKey]
+		aliasesAdded := flagsRef.FlagsAdded[flagKey]
+		aliasesRemoved, removed := flagsRef.FlagsRemoved[flagKey]
+		locations := flagsRef.Locations(flagKey)
+
+		flagReport := FlagReport{
+			Project:    project.Key,
+			Key:        flagKey,
+			Name:       flag.Name,
+			ChangeType: changeType(flagsRef, flagKey),
+			Aliases:    mergeAliases(aliasesAdded, aliasesRemoved),
+			Archived:   flag.Archived,
+			Deprecated: flag.Deprecated,
+			Locations:  make([]Location, 0, len(locations)),
+		}
+		if removed && config.CheckExtinctions {
+			extinct := flagsRef.IsExtinct(flagKey)
+			flagReport.Extinct = &extinct
+		}
+		if flag.ArchivedDate != nil {
+			archivedAt := time.UnixMilli(*flag.ArchivedDate).UTC()
+			flagReport.ArchivedAt = &archivedAt
+		}
+		if flag.DeprecatedDate != nil {
+			deprecatedAt := time.UnixMilli(*flag.DeprecatedDate).UTC()
+			flagReport.DeprecatedAt = &deprecatedAt
+		}
+		for _, envKey := range config.LdEnvironments {
+			env, ok := flag.Environments[envKey]
+			if !ok {
+				continue
+			}
+			if flagReport.Environments == nil {
+				flagReport.Environments = make(map[string]EnvironmentReport, len(config.LdEnvironments))
+			}
+			envReport := EnvironmentReport{On: env.On}
+			if href := env.Site.GetHref(); href != "" {
+				envReport.URL = config.LdInstance + href
+			}
+			flagReport.Environments[envKey] = envReport
+		}
+		for _, location := range locations {
+			flagReport.Locations = append(flagReport.Locations, newLocation(location))
+		}
+
+		flagReports = append(flagReports, flagReport)
+	}
+
+	return flagReports
+}
+
+func newLocation(location refs.ReferenceLocation) Location {
+	return Location{
+		Path:      location.Path,
+		OrigPath:  location.OrigPath,
+		Line:      location.Line,
+		HeadLine:  location.HeadLine,
+		Operation: operationName(location.Operation),
+		Hunk:      location.Hunk,
+	}
+}
+
+// Write the report to path in the given format, creating parent directories as needed
+func WriteFile(path, format string, report Report) error {
+	if dir := filepath.Dir(path); dir != "." {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return err
+		}
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err := Write(f, format, report); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// Write the report in the given format
+func Write(w io.Writer, format string, report Report) error {
+	var v interface{}
+	switch format {
+	case FormatJSON:
+		v = report
+	case FormatSARIF:
+		v = toSARIF(report)
+	default:
+		return fmt.Errorf("unsupported report format %q", format)
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+func changedKeys(flagsRef refs.ReferenceSummary) []string {
+	keys := flagsRef.AddedKeys()
+	for _, key := range flagsRef.RemovedKeys() {
+		if _, ok := flagsRef.FlagsAdded[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Change type of a flag, classified the same as in the PR comment. When introductions aren't
+// checked, introduced flags are modified.
+func changeType(flagsRef refs.ReferenceSummary, flagKey string) string {
+	switch {
+	case flagsRef.IsIntroduced(flagKey):
+		return ChangeTypeIntroduced
+	case flagsRef.IsMoved(flagKey):
+		return ChangeTypeMoved
+	}
+	if _, added := flagsRef.FlagsAdded[flagKey]; added {
+		return ChangeTypeModified
+	}
+	return ChangeTypeRemoved
+}
+
+func mergeAliases(a, b []string) []string {
+	aliases := make([]string, 0, len(a)+len(b))
+	seen := make(map[string]struct{}, len(a)+len(b))
+	for _, alias := range append(append([]string{}, a...), b...) {
+		if _, ok := seen[alias]; ok {
+			continue
+		}
+		seen[alias] = struct{}{}
+		aliases = append(aliases, alias)
+	}
+	sort.Strings(aliases)
+	return aliases
+}
+
+func operationName(op diff_util.Operation) string {
+	if op == diff_util.OperationDelete {
+		return operationRemoved
+	}
+	return operationAdded
+}
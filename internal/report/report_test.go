@@ -0,0 +1,234 @@
+package report
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	ldapi "github.com/launchdarkly/api-client-go/v15"
+	lcr "github.com/launchdarkly/find-code-references-in-pull-request/config"
+	refs "github.com/launchdarkly/find-code-references-in-pull-request/internal/references"
+	"github.com/launchdarkly/find-code-references-in-pull-request/internal/scan"
+	"github.com/launchdarkly/find-code-references-in-pull-request/internal/utils/diff_util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func ptr[T any](t T) *T {
+	return &t
+}
+
+func testConfig() *lcr.Config {
+	return &lcr.Config{
+		LdProject:        "default",
+		LdEnvironment:    "production",
+		LdEnvironments:   []string{"production", "staging"},
+		LdInstance:       "https://example.com",
+		CheckExtinctions: true,
+	}
+}
+
+func TestBuild(t *testing.T) {
+	builder := refs.NewReferenceSummaryBuilder(true, false)
+	require.NoError(t, builder.AddReference("example-flag", diff_util.OperationAdd, []string{"exampleFlag"}, refs.ReferenceLocation{Path: "main.go", Line: 3, HeadLine: 3}))
+	require.NoError(t, builder.AddReference("example-flag", diff_util.OperationDelete, []string{"EXAMPLE_FLAG"}, refs.ReferenceLocation{Path: "main.go", Line: 4, HeadLine: 3}))
+	require.NoError(t, builder.AddReference("archived-flag", diff_util.OperationAdd, nil, refs.ReferenceLocation{Path: "app.go", Line: 10, HeadLine: 10}))
+	require.NoError(t, builder.AddReference("old-flag", diff_util.OperationDelete, nil, refs.ReferenceLocation{Path: "app.go", OrigPath: "legacy/app.go", Line: 20, HeadLine: 18}))
+
+	config := testConfig()
+	flags := []ldapi.FeatureFlag{
+		{
+			Key:  "example-flag",
+			Name: "Example flag",
+			Environments: map[string]ldapi.FeatureFlagConfig{
+				"production": {On: true, Site: ldapi.Link{Href: ptr("/default/production/features/example-flag")}},
+				"staging":    {On: false, Site: ldapi.Link{Href: ptr("/default/staging/features/example-flag")}},
+				"test":       {On: true},
+			},
+		},
+		{Key: "archived-flag", Name: "Archived flag", Archived: true, ArchivedDate: ptr(int64(1700000000000))},
+		{Key: "old-flag", Name: "Old flag"},
+	}
+	report := Build(config, []scan.Project{{Key: "default", Config: config, Flags: flags, References: builder.Build()}})
+
+	assert.Equal(t, SchemaVersion, report.SchemaVersion)
+	assert.Equal(t, []string{"default"}, report.Projects)
+	assert.Equal(t, []string{"production", "staging"}, report.Environments)
+	require.Len(t, report.Flags, 3)
+
+	archived := report.Flags[0]
+	assert.Equal(t, "default", archived.Project)
+	assert.Equal(t, "archived-flag", archived.Key)
+	assert.Equal(t, ChangeTypeModified, archived.ChangeType)
+	assert.True(t, archived.Archived)
+	require.NotNil(t, archived.ArchivedAt)
+	assert.Equal(t, "2023-11-14", archived.ArchivedAt.Format("2006-01-02"))
+	assert.Nil(t, archived.Extinct)
+	assert.Nil(t, archived.Environments)
+
+	example := report.Flags[1]
+	assert.Equal(t, "example-flag", example.Key)
+	assert.Equal(t, ChangeTypeModified, example.ChangeType)
+	assert.Equal(t, []string{"EXAMPLE_FLAG", "exampleFlag"}, example.Aliases)
+	assert.Nil(t, example.Extinct)
+	assert.Equal(t, map[string]EnvironmentReport{
+		"production": {On: true, URL: "https://example.com/default/production/features/example-flag"},
+		"staging":    {On: false, URL: "https://example.com/default/staging/features/example-flag"},
+	}, example.Environments)
+	assert.Equal(t, []Location{
+		{Path: "main.go", Line: 3, HeadLine: 3, Operation: "added"},
+		{Path: "main.go", Line: 4, HeadLine: 3, Operation: "removed"},
+	}, example.Locations)
+
+	removed := report.Flags[2]
+	assert.Equal(t, "old-flag", removed.Key)
+	assert.Equal(t, ChangeTypeRemoved, removed.ChangeType)
+	assert.Equal(t, ptr(true), removed.Extinct)
+	assert.Equal(t, []Location{
+		{Path: "app.go", OrigPath: "legacy/app.go", Line: 20, HeadLine: 18, Operation: "removed"},
+	}, removed.Locations)
+}
+
+func TestBuild_changeTypes(t *testing.T) {
+	builder := refs.NewReferenceSummaryBuilder(false, true)
+	require.NoError(t, builder.AddReference("new-flag", diff_util.OperationAdd, nil, refs.ReferenceLocation{Path: "a.go", Line: 1, Hunk: "@@ -1,1 +1,2 @@"}))
+	require.NoError(t, builder.AddReference("existing-flag", diff_util.OperationAdd, nil, refs.ReferenceLocation{Path: "a.go", Line: 2, Hunk: "@@ -1,1 +1,2 @@"}))
+	require.NoError(t, builder.AddReference("modified-flag", diff_util.OperationDelete, nil, refs.ReferenceLocation{Path: "b.go", Line: 5, Hunk: "@@ -5,1 +5,1 @@"}))
+	require.NoError(t, builder.AddReference("modified-flag", diff_util.OperationAdd, nil, refs.ReferenceLocation{Path: "b.go", Line: 5, Hunk: "@@ -5,1 +5,1 @@"}))
+	require.NoError(t, builder.AddReference("moved-flag", diff_util.OperationDelete, nil, refs.ReferenceLocation{Path: "b.go", Line: 9, Hunk: "@@ -9,1 +8,0 @@"}))
+	require.NoError(t, builder.AddReference("moved-flag", diff_util.OperationAdd, nil, refs.ReferenceLocation{Path: "c.go", Line: 3, Hunk: "@@ -2,0 +3,1 @@"}))
+	require.NoError(t, builder.AddReference("removed-flag", diff_util.OperationDelete, nil, refs.ReferenceLocation{Path: "d.go", Line: 7, Hunk: "@@ -7,1 +7,0 @@"}))
+	builder.AddHeadReference("new-flag", "a.go", 1)
+	builder.AddHeadReference("existing-flag", "a.go", 2)
+	builder.AddHeadReference("existing-flag", "e.go", 12)
+
+	config := testConfig()
+	flags := []ldapi.FeatureFlag{{Key: "new-flag"}, {Key: "existing-flag"}, {Key: "modified-flag"}, {Key: "moved-flag"}, {Key: "removed-flag"}}
+	report := Build(config, []scan.Project{{Key: "default", Config: config, Flags: flags, References: builder.Build()}})
+
+	changeTypes := make(map[string]string, len(report.Flags))
+	for _, flag := range report.Flags {
+		changeTypes[flag.Key] = flag.ChangeType
+	}
+	assert.Equal(t, map[string]string{
+		"existing-flag": ChangeTypeModified,
+		"modified-flag": ChangeTypeModified,
+		"moved-flag":    ChangeTypeMoved,
+		"new-flag":      ChangeTypeIntroduced,
+		"removed-flag":  ChangeTypeRemoved,
+	}, changeTypes)
+}
+
+func TestBuild_noFlags(t *testing.T) {
+	config := testConfig()
+	projects := []scan.Project{{Key: "default", Config: config}}
+
+	var buf bytes.Buffer
+	require.NoError(t, Write(&buf, FormatJSON, Build(config, projects)))
+
+	assert.JSONEq(t, `{"schemaVersion": 1, "projects": ["default"], "environments": ["production", "staging"], "flags": []}`, buf.String())
+}
+
+func TestWrite_sarif(t *testing.T) {
+	builder := refs.NewReferenceSummaryBuilder(false, false)
+	require.NoError(t, builder.AddReference("archived-flag", diff_util.OperationAdd, nil, refs.ReferenceLocation{Path: "app.go", Line: 10, HeadLine: 10}))
+	require.NoError(t, builder.AddReference("old-flag", diff_util.OperationDelete, nil, refs.ReferenceLocation{Path: "app.go", OrigPath: "legacy/app.go", Line: 20, HeadLine: 18}))
+
+	config := testConfig()
+	flags := []ldapi.FeatureFlag{{Key: "archived-flag", Archived: true}, {Key: "old-flag"}}
+	report := Build(config, []scan.Project{{Key: "default", Config: config, Flags: flags, References: builder.Build()}})
+
+	var buf bytes.Buffer
+	require.NoError(t, Write(&buf, FormatSARIF, report))
+
+	var log sarifLog
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &log))
+	assert.Equal(t, "2.1.0", log.Version)
+	require.Len(t, log.Runs, 1)
+
+	results := log.Runs[0].Results
+	require.Len(t, results, 2)
+	assert.Equal(t, ruleFlagAdded, results[0].RuleID)
+	assert.Equal(t, "warning", results[0].Level)
+	assert.Equal(t, "Reference to archived flag `archived-flag` added", results[0].Message.Text)
+	assert.Equal(t, "app.go", results[0].Locations[0].PhysicalLocation.ArtifactLocation.URI)
+	assert.Equal(t, 10, results[0].Locations[0].PhysicalLocation.Region.StartLine)
+
+	assert.Equal(t, ruleFlagRemoved, results[1].RuleID)
+	assert.Equal(t, "note", results[1].Level)
+	assert.Contains(t, results[1].Message.Text, "in file renamed from `legacy/app.go`")
+	assert.Equal(t, 18, results[1].Locations[0].PhysicalLocation.Region.StartLine)
+}
+
+func TestWrite_unsupportedFormat(t *testing.T) {
+	err := Write(&bytes.Buffer{}, "xml", Report{})
+	assert.EqualError(t, err, `unsupported report format "xml"`)
+}
+
+func TestWriteFile(t *testing.T) {
+	builder := refs.NewReferenceSummaryBuilder(false, false)
+	require.NoError(t, builder.AddReference("example-flag", diff_util.OperationAdd, nil, refs.ReferenceLocation{Path: "main.go", Line: 3, HeadLine: 3}))
+
+	config := testConfig()
+	flags := []ldapi.FeatureFlag{{Key: "example-flag"}}
+	path := filepath.Join(t.TempDir(), "reports", "flags.json")
+	require.NoError(t, WriteFile(path, FormatJSON, Build(config, []scan.Project{{Key: "default", Config: config, Flags: flags, References: builder.Build()}})))
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+
+	var report Report
+	require.NoError(t, json.Unmarshal(data, &report))
+	assert.Len(t, report.Flags, 1)
+}
+
+func TestBuild_multipleProjects(t *testing.T) {
+	location := refs.ReferenceLocation{Path: "main.go", Line: 3, HeadLine: 3}
+	web := refs.NewReferenceSummaryBuilder(false, false)
+	require.NoError(t, web.AddReference("example-flag", diff_util.OperationDelete, nil, location))
+	mobile := refs.NewReferenceSummaryBuilder(false, false)
+	require.NoError(t, mobile.AddReference("example-flag", diff_util.OperationAdd, nil, location))
+
+	webConfig, mobileConfig := testConfig(), testConfig()
+	webConfig.LdProject, mobileConfig.LdProject = "web", "mobile"
+	projects := []scan.Project{
+		{Key: "web", Config: webConfig, Flags: []ldapi.FeatureFlag{{Key: "example-flag", Name: "Web example flag"}}, References: web.Build()},
+		{Key: "mobile", Config: mobileConfig, Flags: []ldapi.FeatureFlag{{Key: "example-flag", Name: "Mobile example flag"}}, References: mobile.Build()},
+	}
+
+	report := Build(testConfig(), projects)
+
+	assert.Equal(t, []string{"web", "mobile"}, report.Projects)
+	require.Len(t, report.Flags, 2)
+	assert.Equal(t, "web", report.Flags[0].Project)
+	assert.Equal(t, ChangeTypeRemoved, report.Flags[0].ChangeType)
+	assert.Equal(t, "mobile", report.Flags[1].Project)
+	assert.Equal(t, "Mobile example flag", report.Flags[1].Name)
+	assert.Equal(t, ChangeTypeModified, report.Flags[1].ChangeType)
+}
+
+func TestBuild_unknownFlags(t *testing.T) {
+	location := refs.ReferenceLocation{Path: "main.go", Line: 8, HeadLine: 8}
+	builder := refs.NewReferenceSummaryBuilder(false, false)
+	builder.AddUnknownFlag("exmaple-flag", []string{"example-flag"}, location)
+
+	config := testConfig()
+	report := Build(config, []scan.Project{{Key: "default", Config: config, References: builder.Build()}})
+	assert.Empty(t, report.Flags)
+	assert.Equal(t, []UnknownFlagReport{{
+		Project:     "default",
+		Key:         "exmaple-flag",
+		Suggestions: []string{"example-flag"},
+		Locations:   []Location{{Path: "main.go", Line: 8, HeadLine: 8, Operation: "added"}},
+	}}, report.UnknownFlags)
+
+	log := toSARIF(report)
+	results := log.Runs[0].Results
+	require.Len(t, results, 1)
+	assert.Equal(t, ruleFlagUnknown, results[0].RuleID)
+	assert.Equal(t, "warning", results[0].Level)
+	assert.Equal(t, "Flag `exmaple-flag` not found in LaunchDarkly. Did you mean `example-flag`?", results[0].Message.Text)
+	assert.Equal(t, 8, results[0].Locations[0].PhysicalLocation.Region.StartLine)
+}
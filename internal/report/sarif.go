@@ -0,0 +1,152 @@
+package report
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Minimal subset of the SARIF 2.1.0 format, enough for GitHub code scanning
+// https://docs.oasis-open.org/sarif/sarif/v2.1.0/sarif-v2.1.0.html
+
+const (
+	sarifSchema  = "https://json.schemastore.org/sarif-2.1.0.json"
+	sarifVersion = "2.1.0"
+	toolName     = "LaunchDarkly find code references in pull request"
+	toolURI      = "https://github.com/launchdarkly/find-code-references-in-pull-request"
+
+	ruleFlagAdded   = "launchdarkly-flag-added"
+	ruleFlagRemoved = "launchdarkly-flag-removed"
+	ruleFlagUnknown = "launchdarkly-flag-unknown"
+)
+
+type sarifLog struct {
+	Schema  string     `json:"$schema"`
+	Version string     `json:"version"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool    sarifTool     `json:"tool"`
+	Results []sarifResult `json:"results"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name           string      `json:"name"`
+	InformationURI string      `json:"informationUri"`
+	Rules          []sarifRule `json:"rules"`
+}
+
+type sarifRule struct {
+	ID               string       `json:"id"`
+	ShortDescription sarifMessage `json:"shortDescription"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifResult struct {
+	RuleID     string                 `json:"ruleId"`
+	Level      string                 `json:"level"`
+	Message    sarifMessage           `json:"message"`
+	Locations  []sarifLocation        `json:"locations"`
+	Properties map[string]interface{} `json:"properties,omitempty"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation sarifPhysicalLocation `json:"physicalLocation"`
+}
+
+type sarifPhysicalLocation struct {
+	ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+	Region           sarifRegion           `json:"region"`
+}
+
+type sarifArtifactLocation struct {
+	URI string `json:"uri"`
+}
+
+type sarifRegion struct {
+	StartLine int `json:"startLine"`
+}
+
+// Convert the report to a SARIF log with a result for each reference location.
+// Locations use the line in the head commit, since that is the revision being scanned.
+func toSARIF(report Report) sarifLog {
+	results := make([]sarifResult, 0)
+	for _, flag := range report.Flags {
+		for _, location := range flag.Locations {
+			ruleID, level := ruleFlagAdded, "note"
+			message := fmt.Sprintf("Reference to flag `%s` added", flag.Key)
+			if location.Operation == operationRemoved {
+				ruleID = ruleFlagRemoved
+				message = fmt.Sprintf("Reference to flag `%s` removed", flag.Key)
+			} else if flag.Archived {
+				level = "warning"
+				message = fmt.Sprintf("Reference to archived flag `%s` added", flag.Key)
+			} else if flag.Deprecated {
+				level = "warning"
+				message = fmt.Sprintf("Reference to deprecated flag `%s` added", flag.Key)
+			}
+
+			if location.OrigPath != "" {
+				message += fmt.Sprintf(" in file renamed from `%s`", location.OrigPath)
+			}
+
+			results = append(results, sarifResult{
+				RuleID:  ruleID,
+				Level:   level,
+				Message: sarifMessage{Text: message},
+				Locations: []sarifLocation{{
+					PhysicalLocation: sarifPhysicalLocation{
+						ArtifactLocation: sarifArtifactLocation{URI: location.Path},
+						Region:           sarifRegion{StartLine: max(location.HeadLine, 1)},
+					},
+				}},
+				Properties: map[string]interface{}{"flagKey": flag.Key, "project": flag.Project},
+			})
+		}
+	}
+
+	for _, unknown := range report.UnknownFlags {
+		message := fmt.Sprintf("Flag `%s` not found in LaunchDarkly", unknown.Key)
+		if len(unknown.Suggestions) > 0 {
+			message += fmt.Sprintf(". Did you mean `%s`?", strings.Join(unknown.Suggestions, "`, `"))
+		}
+		for _, location := range unknown.Locations {
+			results = append(results, sarifResult{
+				RuleID:  ruleFlagUnknown,
+				Level:   "warning",
+				Message: sarifMessage{Text: message},
+				Locations: []sarifLocation{{
+					PhysicalLocation: sarifPhysicalLocation{
+						ArtifactLocation: sarifArtifactLocation{URI: location.Path},
+						Region:           sarifRegion{StartLine: max(location.HeadLine, 1)},
+					},
+				}},
+				Properties: map[string]interface{}{"flagKey": unknown.Key, "project": unknown.Project},
+			})
+		}
+	}
+
+	return sarifLog{
+		Schema:  sarifSchema,
+		Version: sarifVersion,
+		Runs: []sarifRun{{
+			Tool: sarifTool{Driver: sarifDriver{
+				Name:           toolName,
+				InformationURI: toolURI,
+				Rules: []sarifRule{
+					{ID: ruleFlagAdded, ShortDescription: sarifMessage{Text: "Reference to a LaunchDarkly flag added"}},
+					{ID: ruleFlagRemoved, ShortDescription: sarifMessage{Text: "Reference to a LaunchDarkly flag removed"}},
+					{ID: ruleFlagUnknown, ShortDescription: sarifMessage{Text: "Flag key evaluated that doesn't exist in LaunchDarkly"}},
+				},
+			}},
+			Results: results,
+		}},
+	}
+}
@@ -0,0 +1,291 @@
+package reviews
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/google/go-github/v68/github"
+
+	ldapi "github.com/launchdarkly/api-client-go/v15"
+	ghc "github.com/launchdarkly/find-code-references-in-pull-request/comments"
+	lcr "github.com/launchdarkly/find-code-references-in-pull-request/config"
+	"github.com/launchdarkly/find-code-references-in-pull-request/internal/authors"
+	gha "github.com/launchdarkly/find-code-references-in-pull-request/internal/github_actions"
+	"github.com/launchdarkly/find-code-references-in-pull-request/internal/scan"
+	"github.com/launchdarkly/find-code-references-in-pull-request/internal/utils/diff_util"
+)
+
+// Identifies a review comment by the flag it refers to and the line it is on
+type commentKey struct {
+	flagKey string
+	path    string
+	line    int
+}
+
+type reviewComment struct {
+	id       int64
+	key      commentKey
+	outdated bool
+}
+
+// Leave an inline review comment on each added line that references a flag. When
+// introductions are checked, only lines referencing flags introduced by the pull request are
+// commented on; otherwise every added reference is, including modified and moved flags. Lines
+// that already have a comment from a previous run are skipped, and comments whose reference
+// no longer exists in the diff are resolved.
+func PostReviewComments(ctx context.Context, config *lcr.Config, event *github.PullRequestEvent, projects []scan.Project) error {
+	pr := event.PullRequest
+	if pr == nil || pr.Number == nil {
+		gha.Debug("No pull request found in event")
+		return nil
+	}
+	prNumber := pr.GetNumber()
+
+	existing, err := listReviewComments(ctx, config, prNumber)
+	if err != nil {
+		return err
+	}
+
+	commented := make(map[commentKey]struct{}, len(existing))
+	for _, c := range existing {
+		if !c.outdated {
+			commented[c.key] = struct{}{}
+		}
+	}
+
+	referenced := make(map[commentKey]struct{})
+	drafts := make([]*github.DraftReviewComment, 0)
+	for _, project := range projects {
+		drafts = append(drafts, draftComments(project, commented, referenced)...)
+	}
+
+	if len(drafts) > 0 {
+		gha.Log("Adding %d review comments", len(drafts))
+		review := &github.PullRequestReviewRequest{
+			CommitID: pr.GetHead().SHA,
+			Event:    github.Ptr("COMMENT"),
+			Comments: drafts,
+		}
+		if _, _, err := config.GHClient.PullRequests.CreateReview(ctx, config.Owner, config.Repo, prNumber, review); err != nil {
+			return err
+		}
+	}
+
+	stale := make(map[int64]struct{})
+	for _, c := range existing {
+		if _, ok := referenced[c.key]; c.outdated || !ok {
+			stale[c.id] = struct{}{}
+		}
+	}
+	if len(stale) == 0 {
+		return nil
+	}
+
+	gha.Debug("Resolving %d outdated review comments", len(stale))
+	return resolveReviewThreads(ctx, config, prNumber, stale)
+}
+
+// Draft a comment for each added reference to one of the project's flags that does not
+// already have one. Every reference commented on is recorded in referenced.
+func draftComments(project scan.Project, commented, referenced map[commentKey]struct{}) []*github.DraftReviewComment {
+	flagsRef := project.References
+	flagsByKey := make(map[string]ldapi.FeatureFlag, len(project.Flags))
+	for _, flag := range project.Flags {
+		flagsByKey[flag.Key] = flag
+	}
+
+	drafts := make([]*github.DraftReviewComment, 0)
+	for _, flagKey := range flagsRef.AddedKeys() {
+		flag, ok := flagsByKey[flagKey]
+		if !ok {
+			continue
+		}
+		// IntroducedFlags is nil unless introductions were checked
+		if flagsRef.IntroducedFlags != nil && !flagsRef.IsIntroduced(flagKey) {
+			continue
+		}
+		body, err := ghc.ReviewFlagComment(flag, project.Config)
+		if err != nil {
+			gha.LogError(err)
+			continue
+		}
+
+		for _, location := range flagsRef.LocationsByOperation(flagKey, diff_util.OperationAdd) {
+			key := commentKey{flagKey: flagKey, path: location.Path, line: location.Line}
+			if _, ok := referenced[key]; ok {
+				continue
+			}
+			referenced[key] = struct{}{}
+
+			if _, ok := commented[key]; ok {
+				gha.Debug("Review comment for %s at %s already exists", flagKey, location)
+				continue
+			}
+
+			drafts = append(drafts, &github.DraftReviewComment{
+				Path: github.Ptr(location.Path),
+				Line: github.Ptr(location.Line),
+				Side: github.Ptr("RIGHT"),
+				Body: github.Ptr(body),
+			})
+		}
+	}
+	return drafts
+}
+
+// List review comments on the pull request that were created by this action. Only comments
+// written by github-actions[bot] or by the user the token belongs to are considered, so other
+// users and bots can't stop a line from being commented on or have their threads resolved by
+// copying the marker.
+func listReviewComments(ctx context.Context, config *lcr.Config, prNumber int) ([]reviewComment, error) {
+	opts := &github.PullRequestListCommentsOptions{
+		ListOptions: github.ListOptions{PerPage: 100},
+	}
+
+	author := authors.NewMatcher(ctx, config)
+	comments := make([]reviewComment, 0)
+	for {
+		page, resp, err := config.GHClient.PullRequests.ListComments(ctx, config.Owner, config.Repo, prNumber, opts)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, c := range page {
+			flagKey, ok := ghc.ReviewCommentFlagKey(c.GetBody())
+			// skip replies, only the top-level comment is ours
+			if !ok || c.InReplyTo != nil || !author.Matches(c.GetUser()) {
+				continue
+			}
+			comments = append(comments, reviewComment{
+				id:       c.GetID(),
+				key:      commentKey{flagKey: flagKey, path: c.GetPath(), line: c.GetLine()},
+				outdated: c.Line == nil,
+			})
+		}
+
+		if resp.NextPage == 0 {
+			break
+		}
+		opts.Page = resp.NextPage
+	}
+
+	return comments, nil
+}
+
+type graphQLRequest struct {
+	Query     string         `json:"query"`
+	Variables map[string]any `json:"variables"`
+}
+
+type graphQLError struct {
+	Message string `json:"message"`
+}
+
+type reviewThreadsResponse struct {
+	Data struct {
+		Repository struct {
+			PullRequest struct {
+				ReviewThreads struct {
+					Nodes []struct {
+						ID         string `json:"id"`
+						IsResolved bool   `json:"isResolved"`
+						Comments   struct {
+							Nodes []struct {
+								DatabaseID int64 `json:"databaseId"`
+							} `json:"nodes"`
+						} `json:"comments"`
+					} `json:"nodes"`
+					PageInfo struct {
+						HasNextPage bool   `json:"hasNextPage"`
+						EndCursor   string `json:"endCursor"`
+					} `json:"pageInfo"`
+				} `json:"reviewThreads"`
+			} `json:"pullRequest"`
+		} `json:"repository"`
+	} `json:"data"`
+	Errors []graphQLError `json:"errors"`
+}
+
+const reviewThreadsQuery = `query($owner: String!, $repo: String!, $number: Int!, $cursor: String) {
+  repository(owner: $owner, name: $repo) {
+    pullRequest(number: $number) {
+      reviewThreads(first: 100, after: $cursor) {
+        nodes { id isResolved comments(first: 1) { nodes { databaseId } } }
+        pageInfo { hasNextPage endCursor }
+      }
+    }
+  }
+}`
+
+const resolveReviewThreadMutation = `mutation($threadId: ID!) {
+  resolveReviewThread(input: {threadId: $threadId}) { thread { id } }
+}`
+
+// Resolve the review threads started by the given comments. Review threads can only be
+// resolved through the GraphQL API.
+func resolveReviewThreads(ctx context.Context, config *lcr.Config, prNumber int, commentIDs map[int64]struct{}) error {
+	threadIDs := make([]string, 0, len(commentIDs))
+	variables := map[string]any{"owner": config.Owner, "repo": config.Repo, "number": prNumber}
+	for {
+		var resp reviewThreadsResponse
+		if err := graphQL(ctx, config.GHClient, reviewThreadsQuery, variables, &resp, &resp.Errors); err != nil {
+			return err
+		}
+
+		threads := resp.Data.Repository.PullRequest.ReviewThreads
+		for _, thread := range threads.Nodes {
+			if thread.IsResolved || len(thread.Comments.Nodes) == 0 {
+				continue
+			}
+			if _, ok := commentIDs[thread.Comments.Nodes[0].DatabaseID]; ok {
+				threadIDs = append(threadIDs, thread.ID)
+			}
+		}
+
+		if !threads.PageInfo.HasNextPage {
+			break
+		}
+		variables["cursor"] = threads.PageInfo.EndCursor
+	}
+
+	for _, threadID := range threadIDs {
+		var resp struct {
+			Errors []graphQLError `json:"errors"`
+		}
+		if err := graphQL(ctx, config.GHClient, resolveReviewThreadMutation, map[string]any{"threadId": threadID}, &resp, &resp.Errors); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func graphQL(ctx context.Context, client *github.Client, query string, variables map[string]any, v any, errs *[]graphQLError) error {
+	req, err := client.NewRequest("POST", graphQLURL(client), graphQLRequest{Query: query, Variables: variables})
+	if err != nil {
+		return err
+	}
+	if _, err := client.Do(ctx, req, v); err != nil {
+		return err
+	}
+	if len(*errs) > 0 {
+		messages := make([]string, 0, len(*errs))
+		for _, e := range *errs {
+			messages = append(messages, e.Message)
+		}
+		return fmt.Errorf("graphql request failed: %s", strings.Join(messages, "; "))
+	}
+	return nil
+}
+
+// GitHub Enterprise serves GraphQL from /api/graphql rather than under the REST API's /api/v3/ path
+func graphQLURL(client *github.Client) string {
+	base := *client.BaseURL
+	if strings.HasSuffix(base.Path, "/api/v3/") {
+		base.Path = strings.TrimSuffix(base.Path, "v3/") + "graphql"
+		return base.String()
+	}
+	return base.ResolveReference(&url.URL{Path: "graphql"}).String()
+}
@@ -0,0 +1,197 @@
+package reviews
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/google/go-github/v68/github"
+	ldapi "github.com/launchdarkly/api-client-go/v15"
+	refs "github.com/launchdarkly/find-code-references-in-pull-request/internal/references"
+	"github.com/launchdarkly/find-code-references-in-pull-request/internal/scan"
+	"github.com/launchdarkly/find-code-references-in-pull-request/internal/testutil"
+	"github.com/launchdarkly/find-code-references-in-pull-request/internal/utils/diff_util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Minimal stand-in for the GitHub REST and GraphQL APIs used for review comments
+type githubServer struct {
+	existing []*github.PullRequestComment
+	reviews  []github.PullRequestReviewRequest
+	resolved []string
+}
+
+func (s *githubServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/repos/owner/repo/pulls/1/comments":
+		_ = json.NewEncoder(w).Encode(s.existing)
+	case r.Method == http.MethodPost && r.URL.Path == "/repos/owner/repo/pulls/1/reviews":
+		var review github.PullRequestReviewRequest
+		_ = json.NewDecoder(r.Body).Decode(&review)
+		s.reviews = append(s.reviews, review)
+		_, _ = w.Write([]byte(`{"id": 1}`))
+	case r.Method == http.MethodPost && r.URL.Path == "/graphql":
+		var req graphQLRequest
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		if strings.HasPrefix(req.Query, "mutation") {
+			s.resolved = append(s.resolved, req.Variables["threadId"].(string))
+			_, _ = w.Write([]byte(`{"data": {}}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"data": {"repository": {"pullRequest": {"reviewThreads": {
+			"nodes": [
+				{"id": "thread-10", "isResolved": false, "comments": {"nodes": [{"databaseId": 10}]}},
+				{"id": "thread-11", "isResolved": false, "comments": {"nodes": [{"databaseId": 11}]}},
+				{"id": "thread-12", "isResolved": true, "comments": {"nodes": [{"databaseId": 12}]}}
+			],
+			"pageInfo": {"hasNextPage": false}
+		}}}}}`))
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func newTestEvent() *github.PullRequestEvent {
+	return &github.PullRequestEvent{
+		PullRequest: &github.PullRequest{
+			Number: github.Ptr(1),
+			Head:   &github.PullRequestBranch{SHA: github.Ptr("abc123")},
+		},
+	}
+}
+
+// Review comment for the flag written by github-actions[bot]
+func existingComment(id int64, flagKey, path string, line *int) *github.PullRequestComment {
+	return &github.PullRequestComment{
+		ID:   github.Ptr(id),
+		Path: github.Ptr(path),
+		Line: line,
+		Body: github.Ptr("**LaunchDarkly flag**\n\n<!-- launchdarkly-flag-reference:" + flagKey + " -->"),
+		User: &github.User{Login: github.Ptr("github-actions[bot]"), Type: github.Ptr("Bot")},
+	}
+}
+
+func TestPostReviewComments(t *testing.T) {
+	server := &githubServer{
+		existing: []*github.PullRequestComment{
+			// still referenced, should not be duplicated
+			existingComment(10, "example-flag", "main.go", github.Ptr(3)),
+			// reference removed, should be resolved
+			existingComment(11, "old-flag", "main.go", github.Ptr(8)),
+			// already resolved
+			existingComment(12, "old-flag", "main.go", nil),
+			// not created by the action
+			{ID: github.Ptr(int64(13)), Path: github.Ptr("main.go"), Line: github.Ptr(3), Body: github.Ptr("example-flag looks good")},
+			// marker copied by a user, should not be mistaken for the action's comment
+			{
+				ID:   github.Ptr(int64(14)),
+				Path: github.Ptr("app/app.go"),
+				Line: github.Ptr(12),
+				Body: github.Ptr("<!-- launchdarkly-flag-reference:second-flag -->"),
+				User: &github.User{Login: github.Ptr("octocat"), Type: github.Ptr("User")},
+			},
+		},
+	}
+
+	flags := []ldapi.FeatureFlag{
+		{Key: "example-flag", Name: "Example flag"},
+		{Key: "second-flag", Name: "Second flag", Archived: true},
+	}
+	flagsRef := refs.ReferenceSummary{
+		FlagsAdded: refs.FlagAliasMap{"example-flag": {}, "second-flag": {}},
+		References: refs.FlagReferenceMap{
+			"example-flag": {
+				{Path: "main.go", Line: 3, Operation: diff_util.OperationAdd},
+				{Path: "main.go", Line: 9, Operation: diff_util.OperationDelete},
+			},
+			"second-flag": {
+				{Path: "app/app.go", Line: 12, Operation: diff_util.OperationAdd},
+			},
+		},
+	}
+
+	config := testutil.GitHubConfig(t, server)
+	config.LdEnvironment = "production"
+	config.LdInstance = "https://example.com/"
+	projects := []scan.Project{{Key: "default", Config: config, Flags: flags, References: flagsRef}}
+	err := PostReviewComments(context.Background(), config, newTestEvent(), projects)
+	require.NoError(t, err)
+
+	require.Len(t, server.reviews, 1)
+	review := server.reviews[0]
+	assert.Equal(t, "abc123", review.GetCommitID())
+	assert.Equal(t, "COMMENT", review.GetEvent())
+	require.Len(t, review.Comments, 1)
+	assert.Equal(t, "app/app.go", review.Comments[0].GetPath())
+	assert.Equal(t, 12, review.Comments[0].GetLine())
+	assert.Equal(t, "RIGHT", review.Comments[0].GetSide())
+	assert.Contains(t, review.Comments[0].GetBody(), "<!-- launchdarkly-flag-reference:second-flag -->")
+
+	assert.Equal(t, []string{"thread-11"}, server.resolved)
+}
+
+func TestPostReviewComments_introducedFlags(t *testing.T) {
+	server := &githubServer{
+		existing: []*github.PullRequestComment{
+			// the flag is no longer introduced by the PR, should be resolved
+			existingComment(10, "example-flag", "main.go", github.Ptr(3)),
+		},
+	}
+
+	flagsRef := refs.ReferenceSummary{
+		FlagsAdded:      refs.FlagAliasMap{"example-flag": {}, "new-flag": {}},
+		IntroducedFlags: map[string]struct{}{"new-flag": {}},
+		References: refs.FlagReferenceMap{
+			"example-flag": {{Path: "main.go", Line: 3, Operation: diff_util.OperationAdd}},
+			"new-flag":     {{Path: "main.go", Line: 4, Operation: diff_util.OperationAdd}},
+		},
+	}
+
+	config := testutil.GitHubConfig(t, server)
+	config.LdEnvironment = "production"
+	config.LdInstance = "https://example.com/"
+	flags := []ldapi.FeatureFlag{{Key: "example-flag"}, {Key: "new-flag"}}
+	projects := []scan.Project{{Key: "default", Config: config, Flags: flags, References: flagsRef}}
+	err := PostReviewComments(context.Background(), config, newTestEvent(), projects)
+	require.NoError(t, err)
+
+	// only the introduced flag is commented on
+	require.Len(t, server.reviews, 1)
+	require.Len(t, server.reviews[0].Comments, 1)
+	assert.Equal(t, 4, server.reviews[0].Comments[0].GetLine())
+	assert.Contains(t, server.reviews[0].Comments[0].GetBody(), "<!-- launchdarkly-flag-reference:new-flag -->")
+	assert.Equal(t, []string{"thread-10"}, server.resolved)
+}
+
+func TestPostReviewComments_nothingToDo(t *testing.T) {
+	server := &githubServer{}
+
+	flagsRef := refs.ReferenceSummary{
+		FlagsRemoved: refs.FlagAliasMap{"example-flag": {}},
+		References: refs.FlagReferenceMap{
+			"example-flag": {{Path: "main.go", Line: 9, Operation: diff_util.OperationDelete}},
+		},
+	}
+
+	config := testutil.GitHubConfig(t, server)
+	config.LdEnvironment = "production"
+	config.LdInstance = "https://example.com/"
+	projects := []scan.Project{{Key: "default", Config: config, Flags: []ldapi.FeatureFlag{{Key: "example-flag"}}, References: flagsRef}}
+	err := PostReviewComments(context.Background(), config, newTestEvent(), projects)
+	require.NoError(t, err)
+
+	assert.Empty(t, server.reviews)
+	assert.Empty(t, server.resolved)
+}
+
+func TestGraphQLURL(t *testing.T) {
+	client := github.NewClient(nil)
+	assert.Equal(t, "https://api.github.com/graphql", graphQLURL(client))
+
+	enterprise, err := client.WithEnterpriseURLs("https://github.example.com/api/v3/", "https://github.example.com/api/uploads/")
+	require.NoError(t, err)
+	assert.Equal(t, "https://github.example.com/api/graphql", graphQLURL(enterprise))
+}
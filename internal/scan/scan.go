@@ -0,0 +1,383 @@
+package scan
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/bmatcuk/doublestar/v4"
+	ldapi "github.com/launchdarkly/api-client-go/v15"
+	lcr "github.com/launchdarkly/find-code-references-in-pull-request/config"
+	ldiff "github.com/launchdarkly/find-code-references-in-pull-request/diff"
+	"github.com/launchdarkly/find-code-references-in-pull-request/internal/extinctions"
+	"github.com/launchdarkly/find-code-references-in-pull-request/internal/git"
+	gha "github.com/launchdarkly/find-code-references-in-pull-request/internal/github_actions"
+	"github.com/launchdarkly/find-code-references-in-pull-request/internal/introductions"
+	refs "github.com/launchdarkly/find-code-references-in-pull-request/internal/references"
+	"github.com/launchdarkly/find-code-references-in-pull-request/internal/unknownflags"
+	"github.com/launchdarkly/find-code-references-in-pull-request/internal/utils"
+	"github.com/launchdarkly/find-code-references-in-pull-request/search"
+	"github.com/launchdarkly/ld-find-code-refs/v2/options"
+	lsearch "github.com/launchdarkly/ld-find-code-refs/v2/search"
+	"github.com/spf13/viper"
+)
+
+// A LaunchDarkly project to scan the diff for, along with the flags and references found
+type Project struct {
+	Key        string
+	Dir        string          // only files in this directory, relative to the workspace, are scanned. Empty for the whole repository.
+	Config     *lcr.Config     // config scoped to the project
+	Options    options.Options // options with the project's aliases
+	Flags      []ldapi.FeatureFlag
+	References refs.ReferenceSummary
+}
+
+// Get options from config. Note: dir will be set to workspace
+func GetOptions(config *lcr.Config) (options.Options, error) {
+	// Needed for ld-find-code-refs to work as a library
+	viper.Set("dir", config.Workspace)
+	token := config.ApiToken
+	if token == "" {
+		// the token is required to read the configuration file, but isn't used when
+		// flags are read from flags-file
+		token = "unused"
+	}
+	viper.Set("accessToken", token)
+
+	if err := options.InitYAML(); err != nil {
+		gha.LogError(err)
+	}
+	return options.GetOptions()
+}
+
+// Resolve the projects to scan. Projects defined in .launchdarkly/coderefs.yaml take
+// precedence over the configured project keys.
+func Projects(config *lcr.Config, opts options.Options) []Project {
+	if len(opts.Projects) > 0 {
+		gha.Debug("Using %d projects from configuration file", len(opts.Projects))
+		projects := make([]Project, 0, len(opts.Projects))
+		for _, p := range opts.Projects {
+			projectOpts := opts
+			projectOpts.Aliases = append(append([]options.Alias{}, opts.Aliases...), p.Aliases...)
+			projects = append(projects, Project{
+				Key:     p.Key,
+				Dir:     cleanDir(p.Dir),
+				Config:  config.ForProject(p.Key),
+				Options: projectOpts,
+			})
+		}
+		return projects
+	}
+
+	keys := config.LdProjects
+	if len(keys) == 0 {
+		keys = []string{config.LdProject}
+	}
+	projects := make([]Project, 0, len(keys))
+	for _, key := range keys {
+		projects = append(projects, Project{
+			Key:     key,
+			Config:  config.ForProject(key),
+			Options: opts,
+		})
+	}
+	return projects
+}
+
+// Project keys, in order
+func ProjectKeys(projects []Project) []string {
+	keys := make([]string, 0, len(projects))
+	for _, p := range projects {
+		keys = append(keys, p.Key)
+	}
+	return keys
+}
+
+// Whether any project has flags to search for
+func AnyFlags(projects []Project) bool {
+	for _, p := range projects {
+		if len(p.Flags) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Whether references were found for any project
+func AnyFound(projects []Project) bool {
+	for _, p := range projects {
+		if p.References.AnyFound() {
+			return true
+		}
+	}
+	return false
+}
+
+// Whether more flags were found across all projects than are shown by max-flags
+func Truncated(projects []Project) bool {
+	if len(projects) == 0 {
+		return false
+	}
+	found := 0
+	for _, p := range projects {
+		found += len(p.References.FlagsAdded) + len(p.References.FlagsRemoved)
+	}
+	maxFlags := projects[0].Config.MaxFlags
+	return maxFlags > 0 && found > maxFlags
+}
+
+// Combine the references found for all projects. Flags with the same key in
+// different projects are combined into a single entry.
+func MergeReferences(projects []Project) refs.ReferenceSummary {
+	if len(projects) == 1 {
+		return projects[0].References
+	}
+
+	merged := refs.ReferenceSummary{
+		FlagsAdded:   make(refs.FlagAliasMap),
+		FlagsRemoved: make(refs.FlagAliasMap),
+		ExtinctFlags: make(map[string]struct{}),
+		MovedFlags:   make(map[string]struct{}),
+		References:   make(refs.FlagReferenceMap),
+	}
+	for _, p := range projects {
+		for key, aliases := range p.References.FlagsAdded {
+			merged.FlagsAdded[key] = append(merged.FlagsAdded[key], aliases...)
+		}
+		for key, aliases := range p.References.FlagsRemoved {
+			merged.FlagsRemoved[key] = append(merged.FlagsRemoved[key], aliases...)
+		}
+		for key := range p.References.ExtinctFlags {
+			merged.ExtinctFlags[key] = struct{}{}
+		}
+		if p.References.IntroducedFlags != nil && merged.IntroducedFlags == nil {
+			merged.IntroducedFlags = make(map[string]struct{})
+		}
+		for key := range p.References.IntroducedFlags {
+			merged.IntroducedFlags[key] = struct{}{}
+		}
+		for key := range p.References.MovedFlags {
+			merged.MovedFlags[key] = struct{}{}
+		}
+		merged.UnknownFlags = mergeUnknownFlags(merged.UnknownFlags, p.References.UnknownFlags)
+		for key, locations := range p.References.References {
+			merged.References[key] = append(merged.References[key], locations...)
+		}
+	}
+	return merged
+}
+
+// Combine unknown flags with the same key, keeping them sorted by key. Projects scanning the
+// same files find the same locations, which are only listed once.
+func mergeUnknownFlags(merged, unknown []refs.UnknownFlag) []refs.UnknownFlag {
+	for _, u := range unknown {
+		i := sort.Search(len(merged), func(i int) bool { return merged[i].Key >= u.Key })
+		if i < len(merged) && merged[i].Key == u.Key {
+			for _, l := range u.Locations {
+				if !slices.Contains(merged[i].Locations, l) {
+					merged[i].Locations = append(merged[i].Locations, l)
+				}
+			}
+			merged[i].Suggestions = utils.Dedupe(append(merged[i].Suggestions, u.Suggestions...))
+			continue
+		}
+		merged = slices.Insert(merged, i, refs.UnknownFlag{
+			Key:         u.Key,
+			Suggestions: u.Suggestions,
+			Locations:   append([]refs.ReferenceLocation{}, u.Locations...),
+		})
+	}
+	return merged
+}
+
+func FlagKeys(flags []ldapi.FeatureFlag) []string {
+	flagKeys := make([]string, 0, len(flags))
+	for _, flag := range flags {
+		flagKeys = append(flagKeys, flag.Key)
+	}
+	return flagKeys
+}
+
+// Search the diff for references to each project's flags, setting the references found on each project.
+// The diff is scanned file by file as it is read from rawDiff.
+func ScanProjects(opts options.Options, projects []Project, rawDiff io.Reader) error {
+	if len(projects) == 0 {
+		return nil
+	}
+	config := projects[0].Config
+
+	// file pattern aliases are also generated from the contents of the diff, so the
+	// diff is read once to collect the files they match, and again to scan it
+	aliasFiles := make(ldiff.DiffFileMap)
+	if aliasPaths := aliasFilePaths(projects); len(aliasPaths) > 0 {
+		spool, err := os.CreateTemp("", "launchdarkly-diff-*")
+		if err != nil {
+			return err
+		}
+		defer os.Remove(spool.Name())
+		defer spool.Close()
+
+		gha.StartLogGroup("Reading diff for aliases...")
+		err = ldiff.ReadDiffs(io.TeeReader(rawDiff, spool), opts.Dir, 1, func(filePath string, file *ldiff.DiffFile) {
+			if _, ok := aliasPaths[filepath.Clean(filePath)]; ok {
+				aliasFiles[filepath.Clean(filePath)] = file
+			}
+		})
+		gha.EndLogGroup()
+		if err != nil {
+			return err
+		}
+		if _, err := spool.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		rawDiff = spool
+	}
+
+	scanners := make([]projectScanner, 0, len(projects))
+	for i := range projects {
+		project := &projects[i]
+		flagKeys := FlagKeys(project.Flags)
+		matcher, err := search.GetMatcher(project.Options, flagKeys, aliasFiles.InDir(project.Dir).Contents())
+		if err != nil {
+			return err
+		}
+		if len(projects) > 1 {
+			gha.Log("Searching for %d flags in project %s", len(flagKeys), project.Key)
+		} else {
+			gha.Log("Searching for %d flags", len(flagKeys))
+		}
+		scanner := projectScanner{
+			project: project,
+			matcher: matcher,
+			builder: refs.NewReferenceSummaryBuilder(project.Config.CheckExtinctions, project.Config.CheckIntroductions),
+		}
+		if project.Config.DetectUnknownFlags {
+			// keys of other projects scanning the same files aren't unknown
+			scanner.detector = unknownflags.NewDetector(overlappingFlagKeys(projects, i))
+		}
+		scanners = append(scanners, scanner)
+	}
+
+	gha.StartLogGroup("Scanning diff for references...")
+	err := ldiff.ReadDiffs(rawDiff, opts.Dir, config.Concurrency, func(_ string, file *ldiff.DiffFile) {
+		for _, s := range scanners {
+			if utils.InDir(file.Path, s.project.Dir) {
+				ldiff.ProcessDiffs(s.matcher, file, s.builder)
+				if s.detector != nil {
+					ldiff.DetectUnknownFlags(s.detector, file, s.builder)
+				}
+			}
+		}
+	})
+	gha.EndLogGroup()
+	if err != nil {
+		return err
+	}
+
+	baseDir, removeBase := checkoutBase(opts.Dir, config.BaseSha, scanners)
+	defer removeBase()
+	for _, s := range scanners {
+		s.project.References = s.summarize(baseDir)
+	}
+	return nil
+}
+
+// Check out the base commit once for all projects that check whether added flags were
+// introduced. Returns the workspace in the checkout, or an empty path if there is none to
+// search, along with a function that removes it.
+func checkoutBase(workspace, baseSha string, scanners []projectScanner) (string, func()) {
+	needed := false
+	for _, s := range scanners {
+		if s.project.Config.CheckIntroductions && len(s.builder.AddedFlagKeys()) > 0 {
+			needed = true
+		}
+	}
+	if !needed || baseSha == "" {
+		return "", func() {}
+	}
+
+	gha.Debug("Checking out base commit %s to check for introduced flags", baseSha)
+	baseDir, remove, err := git.AddWorktree(workspace, baseSha)
+	if err != nil {
+		gha.Log("Unable to check out the base commit, checking for introduced flags at HEAD instead: %s", err)
+		return "", func() {}
+	}
+	return baseDir, remove
+}
+
+// Matcher and references found for a project while scanning the diff
+type projectScanner struct {
+	project  *Project
+	matcher  lsearch.Matcher
+	builder  *refs.ReferenceSummaryBuilder
+	detector *unknownflags.Detector // nil unless unknown flags are detected
+}
+
+// Check for extinctions and introductions and summarize the references found. baseDir is the
+// workspace checked out at the base commit, empty if it couldn't be checked out.
+func (s projectScanner) summarize(baseDir string) refs.ReferenceSummary {
+	if s.project.Config.CheckExtinctions {
+		if err := extinctions.CheckExtinctions(s.project.Options, s.project.Dir, s.builder); err != nil {
+			gha.SetWarning("Error checking for extinct flags")
+			gha.LogError(err)
+		}
+	}
+	if s.project.Config.CheckIntroductions {
+		if err := introductions.CheckIntroductions(s.project.Options, s.project.Dir, baseDir, s.builder); err != nil {
+			gha.SetWarning("Error checking for introduced flags")
+			gha.LogError(err)
+		}
+	}
+
+	gha.Log("Summarizing results")
+	return s.builder.Build()
+}
+
+// Flag keys of the project at index i and of every other project that scans any of its files
+func overlappingFlagKeys(projects []Project, i int) []string {
+	dir := projects[i].Dir
+	flagKeys := make([]string, 0, len(projects[i].Flags))
+	for _, p := range projects {
+		if utils.InDir(p.Dir, dir) || utils.InDir(dir, p.Dir) {
+			flagKeys = append(flagKeys, FlagKeys(p.Flags)...)
+		}
+	}
+	return utils.Dedupe(flagKeys)
+}
+
+// Paths of files in the workspace matched by each project's file pattern aliases
+func aliasFilePaths(projects []Project) map[string]struct{} {
+	paths := make(map[string]struct{})
+	for _, p := range projects {
+		for _, alias := range p.Options.Aliases {
+			if alias.Type.Canonical() != options.FilePattern {
+				continue
+			}
+			for _, glob := range alias.Paths {
+				matches, err := doublestar.FilepathGlob(filepath.Join(p.Options.Dir, glob))
+				if err != nil {
+					gha.Debug("Skipping alias path %q: %s", glob, err)
+					continue
+				}
+				for _, match := range matches {
+					paths[filepath.Clean(match)] = struct{}{}
+				}
+			}
+		}
+	}
+	return paths
+}
+
+func cleanDir(dir string) string {
+	if dir == "" {
+		return ""
+	}
+	dir = strings.Trim(filepath.ToSlash(filepath.Clean(dir)), "/")
+	if dir == "." {
+		return ""
+	}
+	return dir
+}
@@ -0,0 +1,254 @@
+package scan
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	ldapi "github.com/launchdarkly/api-client-go/v15"
+	lcr "github.com/launchdarkly/find-code-references-in-pull-request/config"
+	refs "github.com/launchdarkly/find-code-references-in-pull-request/internal/references"
+	"github.com/launchdarkly/ld-find-code-refs/v2/options"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProjects_fromConfig(t *testing.T) {
+	config := &lcr.Config{LdProject: "web", LdProjects: []string{"web", "mobile"}}
+
+	projects := Projects(config, options.Options{})
+
+	require.Len(t, projects, 2)
+	assert.Equal(t, []string{"web", "mobile"}, ProjectKeys(projects))
+	assert.Equal(t, "mobile", projects[1].Config.LdProject)
+	assert.Equal(t, "", projects[1].Dir)
+	// the original config is not modified
+	assert.Equal(t, "web", config.LdProject)
+}
+
+func TestProjects_fromOptions(t *testing.T) {
+	config := &lcr.Config{LdProject: "default", LdProjects: []string{"default"}}
+	opts := options.Options{
+		Aliases: []options.Alias{{Type: options.Literal, Flags: map[string][]string{"shared-flag": {"SHARED"}}}},
+		Projects: []options.Project{
+			{Key: "web", Dir: "./apps/web/"},
+			{Key: "mobile", Dir: "apps/mobile", Aliases: []options.Alias{{Type: options.CamelCase}}},
+		},
+	}
+
+	projects := Projects(config, opts)
+
+	require.Len(t, projects, 2)
+	assert.Equal(t, "apps/web", projects[0].Dir)
+	assert.Equal(t, "web", projects[0].Config.LdProject)
+	assert.Len(t, projects[0].Options.Aliases, 1)
+	assert.Equal(t, "apps/mobile", projects[1].Dir)
+	assert.Len(t, projects[1].Options.Aliases, 2)
+	assert.Len(t, opts.Aliases, 1)
+}
+
+func TestScanProjects(t *testing.T) {
+	rawDiff := `diff --git a/apps/web/main.js b/apps/web/main.js
+index 0000000..1111111 100644
+--- a/apps/web/main.js
++++ b/apps/web/main.js
+@@ -1,1 +1,2 @@
+ const a = 1;
++ldClient.variation("shared-flag", false);
+diff --git a/apps/mobile/main.js b/apps/mobile/main.js
+index 0000000..1111111 100644
+--- a/apps/mobile/main.js
++++ b/apps/mobile/main.js
+@@ -1,2 +1,1 @@
+ const a = 1;
+-ldClient.variation("shared-flag", false);
+`
+	config := &lcr.Config{MaxFlags: 5}
+	opts := options.Options{Dir: t.TempDir()}
+	projects := []Project{
+		{Key: "web", Dir: "apps/web", Config: config.ForProject("web"), Options: opts, Flags: []ldapi.FeatureFlag{{Key: "shared-flag"}}},
+		{Key: "mobile", Dir: "apps/mobile", Config: config.ForProject("mobile"), Options: opts, Flags: []ldapi.FeatureFlag{{Key: "shared-flag"}}},
+		{Key: "other", Config: config.ForProject("other"), Options: opts, Flags: []ldapi.FeatureFlag{{Key: "other-flag"}}},
+	}
+
+	require.NoError(t, ScanProjects(opts, projects, strings.NewReader(rawDiff)))
+
+	assert.Equal(t, []string{"shared-flag"}, projects[0].References.AddedKeys())
+	assert.Empty(t, projects[0].References.RemovedKeys())
+	assert.Empty(t, projects[1].References.AddedKeys())
+	assert.Equal(t, []string{"shared-flag"}, projects[1].References.RemovedKeys())
+	assert.False(t, projects[2].References.AnyFound())
+
+	merged := MergeReferences(projects)
+	assert.Equal(t, []string{"shared-flag"}, merged.AddedKeys())
+	assert.Equal(t, []string{"shared-flag"}, merged.RemovedKeys())
+	assert.Len(t, merged.Locations("shared-flag"), 2)
+	assert.True(t, AnyFound(projects))
+}
+
+func TestScanProjects_unknownFlags(t *testing.T) {
+	rawDiff := `diff --git a/main.js b/main.js
+index 0000000..1111111 100644
+--- a/main.js
++++ b/main.js
+@@ -1,1 +1,3 @@
+ const a = 1;
++ldClient.variation("b-flag", false);
++ldClient.variation("missing-flag", false);
+`
+	config := &lcr.Config{DetectUnknownFlags: true}
+	opts := options.Options{Dir: t.TempDir()}
+	projects := []Project{
+		{Key: "a", Config: config.ForProject("a"), Options: opts, Flags: []ldapi.FeatureFlag{{Key: "a-flag"}}},
+		{Key: "b", Config: config.ForProject("b"), Options: opts, Flags: []ldapi.FeatureFlag{{Key: "b-flag"}}},
+		{Key: "c", Dir: "apps/c", Config: config.ForProject("c"), Options: opts, Flags: []ldapi.FeatureFlag{{Key: "c-flag"}}},
+	}
+
+	require.NoError(t, ScanProjects(opts, projects, strings.NewReader(rawDiff)))
+
+	// flags of other projects scanning the same files aren't unknown
+	assert.Equal(t, []string{"missing-flag"}, projects[0].References.UnknownKeys())
+	assert.Equal(t, []string{"missing-flag"}, projects[1].References.UnknownKeys())
+	assert.Empty(t, projects[2].References.UnknownKeys())
+
+	merged := MergeReferences(projects)
+	assert.Equal(t, []string{"missing-flag"}, merged.UnknownKeys())
+	assert.Len(t, merged.UnknownFlags[0].Locations, 1)
+
+	assert.ElementsMatch(t, []string{"a-flag", "b-flag", "c-flag"}, overlappingFlagKeys(projects, 0))
+	assert.ElementsMatch(t, []string{"a-flag", "b-flag", "c-flag"}, overlappingFlagKeys(projects, 2))
+}
+
+func TestScanProjects_filePatternAliases(t *testing.T) {
+	// the alias is only defined in the removed lines of the diff
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "flags.js"), []byte("// no flags\n"), 0o644))
+	rawDiff := `diff --git a/flags.js b/flags.js
+index 0000000..1111111 100644
+--- a/flags.js
++++ b/flags.js
+@@ -1,2 +1,1 @@
+-export const OLD_FLAG = "old-flag";
+ // no flags
+diff --git a/main.js b/main.js
+index 0000000..1111111 100644
+--- a/main.js
++++ b/main.js
+@@ -1,2 +1,1 @@
+-if (flags[OLD_FLAG]) {}
+ const a = 1;
+`
+
+	opts := options.Options{Dir: dir, Aliases: []options.Alias{{
+		Type:     options.FilePattern,
+		Paths:    []string{"*.js"},
+		Patterns: []string{`(\w+) = "FLAG_KEY"`},
+	}}}
+	config := &lcr.Config{CheckExtinctions: false}
+	projects := []Project{{Key: "default", Config: config, Options: opts, Flags: []ldapi.FeatureFlag{{Key: "old-flag"}}}}
+
+	require.NoError(t, ScanProjects(opts, projects, strings.NewReader(rawDiff)))
+
+	assert.Equal(t, []string{"OLD_FLAG"}, projects[0].References.FlagsRemoved["old-flag"])
+	paths := make([]string, 0)
+	for _, l := range projects[0].References.Locations("old-flag") {
+		paths = append(paths, l.Path)
+	}
+	assert.Equal(t, []string{"flags.js", "main.js"}, paths)
+}
+
+func TestScanProjects_introducedFlags(t *testing.T) {
+	dir := t.TempDir()
+	contents := "package main\nvar a = \"new-flag\"\nvar b = \"old-flag\"\nvar c = \"old-flag\"\n"
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "main.go"), []byte(contents), 0o644))
+	rawDiff := `diff --git a/main.go b/main.go
+index 0000000..1111111 100644
+--- a/main.go
++++ b/main.go
+@@ -1,2 +1,4 @@
+ package main
++var a = "new-flag"
+ var b = "old-flag"
++var c = "old-flag"
+`
+
+	opts := options.Options{Dir: dir}
+	config := &lcr.Config{CheckIntroductions: true}
+	projects := []Project{{Key: "default", Config: config, Options: opts, Flags: []ldapi.FeatureFlag{{Key: "new-flag"}, {Key: "old-flag"}}}}
+
+	require.NoError(t, ScanProjects(opts, projects, strings.NewReader(rawDiff)))
+
+	// old-flag was already referenced on an unchanged line
+	flagsRef := projects[0].References
+	assert.Equal(t, []string{"new-flag", "old-flag"}, flagsRef.AddedKeys())
+	assert.Equal(t, []string{"new-flag"}, flagsRef.IntroducedKeys())
+	assert.Equal(t, []string{"old-flag"}, flagsRef.ModifiedKeys())
+}
+
+func TestScanProjects_introducedFlagsAtBase(t *testing.T) {
+	dir := t.TempDir()
+	git := func(args ...string) string {
+		cmd := exec.Command("git", append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+		cmd.Dir = dir
+		output, err := cmd.CombinedOutput()
+		require.NoError(t, err, string(output))
+		return strings.TrimSpace(string(output))
+	}
+	git("init", "--quiet")
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "main.go"), []byte("package main\nvar b = \"old-flag\"\n"), 0o644))
+	git("add", "main.go")
+	git("commit", "--quiet", "-m", "base")
+	baseSha := git("rev-parse", "HEAD")
+
+	// the workspace is a merge commit with a line the diff doesn't have, so its line numbers
+	// don't match the diff's
+	contents := "package main\nvar merged = 1\nvar a = \"new-flag\"\nvar c = \"old-flag\"\n"
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "main.go"), []byte(contents), 0o644))
+	git("commit", "--quiet", "-am", "head")
+	rawDiff := `diff --git a/main.go b/main.go
+index 0000000..1111111 100644
+--- a/main.go
++++ b/main.go
+@@ -1,2 +1,3 @@
+ package main
++var a = "new-flag"
+-var b = "old-flag"
++var c = "old-flag"
+`
+
+	opts := options.Options{Dir: dir}
+	config := &lcr.Config{CheckIntroductions: true, BaseSha: baseSha}
+	projects := []Project{{Key: "default", Config: config, Options: opts, Flags: []ldapi.FeatureFlag{{Key: "new-flag"}, {Key: "other-flag"}}}}
+
+	require.NoError(t, ScanProjects(opts, projects, strings.NewReader(rawDiff)))
+
+	// new-flag isn't referenced at the base commit
+	assert.Equal(t, []string{"new-flag"}, projects[0].References.IntroducedKeys())
+
+	// the base checkout is removed
+	assert.NotContains(t, git("worktree", "list"), "launchdarkly-base-")
+}
+
+func TestTruncated(t *testing.T) {
+	flagsRef := refs.ReferenceSummary{
+		FlagsAdded:   refs.FlagAliasMap{"flag-a": {}},
+		FlagsRemoved: refs.FlagAliasMap{"flag-b": {}},
+	}
+
+	assert.True(t, Truncated([]Project{{References: flagsRef, Config: &lcr.Config{MaxFlags: 1}}}))
+	assert.False(t, Truncated([]Project{{References: flagsRef, Config: &lcr.Config{MaxFlags: 2}}}))
+	assert.False(t, Truncated([]Project{{References: flagsRef, Config: &lcr.Config{MaxFlags: 0}}}))
+
+	// max-flags applies across all projects
+	config := &lcr.Config{MaxFlags: 3}
+	assert.True(t, Truncated([]Project{{References: flagsRef, Config: config}, {References: flagsRef, Config: config}}))
+	assert.False(t, Truncated(nil))
+}
+
+func TestMergeReferences_singleProject(t *testing.T) {
+	flagsRef := refs.ReferenceSummary{FlagsAdded: refs.FlagAliasMap{"example-flag": {}}}
+
+	assert.Equal(t, flagsRef, MergeReferences([]Project{{Key: "default", References: flagsRef}}))
+}
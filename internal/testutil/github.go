@@ -0,0 +1,35 @@
+// Package testutil sets up stand-in servers for tests of code that calls the GitHub API.
+package testutil
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/google/go-github/v68/github"
+
+	lcr "github.com/launchdarkly/find-code-references-in-pull-request/config"
+)
+
+// Config for the repository owner/repo with a GitHub client that sends every request to
+// handler. The server is closed when the test ends.
+func GitHubConfig(t *testing.T, handler http.Handler) *lcr.Config {
+	t.Helper()
+
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	baseURL, err := url.Parse(server.URL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := github.NewClient(nil)
+	client.BaseURL = baseURL
+
+	return &lcr.Config{
+		Owner:    "owner",
+		Repo:     "repo",
+		GHClient: client,
+	}
+}
@@ -0,0 +1,154 @@
+package unknownflags
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const maxSuggestions = 3
+
+// quoted flag key, captured in one of three groups depending on the quote used
+const quotedKey = "\\s*(?:\"([\\w.-]+)\"|'([\\w.-]+)'|`([\\w.-]+)`)"
+
+// SDK calls that evaluate a flag by key
+var evaluationPatterns = []*regexp.Regexp{
+	// LaunchDarkly SDKs, e.g. variation, boolVariation, BoolVariation, bool_variation, useBoolVariation
+	regexp.MustCompile(`(?i)\b(?:use)?(?:(?:bool|boolean|string|str|int|integer|float|float64|double|number|json|json_?value)_?)?variation(?:_?detail)?\s*\(` + quotedKey),
+	// OpenFeature SDKs, e.g. getBooleanValue, get_boolean_value, getStringDetails
+	regexp.MustCompile(`(?i)\bget_?(?:boolean|string|number|object|integer|int|float|double)_?(?:value|details)\s*\(` + quotedKey),
+	// LaunchDarkly React SDK, e.g. useFlags()["my-flag"]
+	regexp.MustCompile(`\buseFlags\(\s*\)\s*\[` + quotedKey + `\s*\]`),
+}
+
+// React SDK flags accessed as properties, e.g. useFlags().myFlag. Keys are camel cased by default.
+var camelCasePattern = regexp.MustCompile(`\buseFlags\(\s*\)\.(\w+)`)
+
+// Finds keys passed to SDK evaluation calls that don't match a known flag. Safe for concurrent use.
+type Detector struct {
+	keys        []string
+	known       map[string]struct{}
+	camelCase   map[string]struct{}
+	mu          sync.Mutex
+	suggestions map[string][]string
+}
+
+func NewDetector(flagKeys []string) *Detector {
+	d := &Detector{
+		keys:        flagKeys,
+		known:       make(map[string]struct{}, len(flagKeys)),
+		camelCase:   make(map[string]struct{}, len(flagKeys)),
+		suggestions: make(map[string][]string),
+	}
+	for _, key := range flagKeys {
+		d.known[key] = struct{}{}
+		d.camelCase[camelCaseKey(key)] = struct{}{}
+	}
+	return d
+}
+
+// Keys evaluated on line that don't match a known flag
+func (d *Detector) FindUnknownKeys(line string) []string {
+	var unknown []string
+	for _, pattern := range evaluationPatterns {
+		for _, match := range pattern.FindAllStringSubmatch(line, -1) {
+			key := firstGroup(match)
+			if _, ok := d.known[key]; !ok {
+				unknown = append(unknown, key)
+			}
+		}
+	}
+	for _, match := range camelCasePattern.FindAllStringSubmatch(line, -1) {
+		key := match[1]
+		_, isKey := d.known[key]
+		_, isCamelCase := d.camelCase[key]
+		if !isKey && !isCamelCase {
+			unknown = append(unknown, key)
+		}
+	}
+	return unknown
+}
+
+// Known flag keys closest to key by edit distance, closest first
+func (d *Detector) Suggest(key string) []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if suggestions, ok := d.suggestions[key]; ok {
+		return suggestions
+	}
+
+	type candidate struct {
+		key      string
+		distance int
+	}
+	maxDistance := max(2, len(key)/3)
+	candidates := make([]candidate, 0)
+	for _, flagKey := range d.keys {
+		distance := levenshtein(strings.ToLower(key), strings.ToLower(flagKey))
+		if distance > maxDistance {
+			// compare property names with camel cased keys
+			distance = levenshtein(key, camelCaseKey(flagKey))
+		}
+		if distance <= maxDistance {
+			candidates = append(candidates, candidate{flagKey, distance})
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].distance != candidates[j].distance {
+			return candidates[i].distance < candidates[j].distance
+		}
+		return candidates[i].key < candidates[j].key
+	})
+
+	suggestions := make([]string, 0, maxSuggestions)
+	for _, c := range candidates {
+		if len(suggestions) == maxSuggestions {
+			break
+		}
+		suggestions = append(suggestions, c.key)
+	}
+	d.suggestions[key] = suggestions
+	return suggestions
+}
+
+func firstGroup(match []string) string {
+	for _, group := range match[1:] {
+		if group != "" {
+			return group
+		}
+	}
+	return ""
+}
+
+// Flag key as camel cased by the React SDK, e.g. my-flag.key becomes myFlagKey
+func camelCaseKey(key string) string {
+	parts := strings.FieldsFunc(key, func(r rune) bool { return r == '-' || r == '_' || r == '.' })
+	for i := 1; i < len(parts); i++ {
+		parts[i] = strings.ToUpper(parts[i][:1]) + parts[i][1:]
+	}
+	return strings.Join(parts, "")
+}
+
+// Number of single character edits to change a into b
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(rb)]
+}
@@ -0,0 +1,54 @@
+package unknownflags
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFindUnknownKeys(t *testing.T) {
+	detector := NewDetector([]string{"example-flag", "dark-mode", "checkout.v2"})
+
+	cases := []struct {
+		name     string
+		line     string
+		expected []string
+	}{
+		{name: "go", line: `+	if client.BoolVariation("exmaple-flag", ctx, false) {`, expected: []string{"exmaple-flag"}},
+		{name: "go detail", line: `+	v, _, _ := client.StringVariationDetail("new-flag", ctx, "")`, expected: []string{"new-flag"}},
+		{name: "javascript", line: `+  const on = client.variation('dark-mod', false);`, expected: []string{"dark-mod"}},
+		{name: "java", line: `+    boolean on = client.boolVariation("checkout-v2", context, false);`, expected: []string{"checkout-v2"}},
+		{name: "python", line: `+    on = client.bool_variation("dark_mode", context, False)`, expected: []string{"dark_mode"}},
+		{name: "react hook", line: "+  const on = useBoolVariation(`dark-mode-2`, false);", expected: []string{"dark-mode-2"}},
+		{name: "react flags index", line: `+  const on = useFlags()["darkmode"];`, expected: []string{"darkmode"}},
+		{name: "react flags property", line: `+  const on = useFlags().darkMod;`, expected: []string{"darkMod"}},
+		{name: "react flags camel case key", line: `+  const on = useFlags().darkMode;`},
+		{name: "openfeature", line: `+  const on = await client.getBooleanValue("exampleflag", false);`, expected: []string{"exampleflag"}},
+		{name: "openfeature python", line: `+    on = client.get_boolean_details("example-flag", False)`},
+		{name: "known flags", line: `+	a, b := client.BoolVariation("example-flag", ctx, false), client.BoolVariation("checkout.v2", ctx, false)`},
+		{name: "not an evaluation", line: `+	log.Printf("example-flg")`},
+		{name: "variable key", line: `+	client.BoolVariation(flagKey, ctx, false)`},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, detector.FindUnknownKeys(tc.line))
+		})
+	}
+}
+
+func TestSuggest(t *testing.T) {
+	detector := NewDetector([]string{"example-flag", "example-flags", "sample-flag", "dark-mode", "checkout.v2"})
+
+	assert.Equal(t, []string{"example-flag", "example-flags", "sample-flag"}, detector.Suggest("exmaple-flag"))
+	assert.Equal(t, []string{"dark-mode"}, detector.Suggest("darkMod"))
+	assert.Equal(t, []string{"checkout.v2"}, detector.Suggest("checkout-v2"))
+	assert.Empty(t, detector.Suggest("something-else"))
+}
+
+func TestLevenshtein(t *testing.T) {
+	assert.Equal(t, 0, levenshtein("flag", "flag"))
+	assert.Equal(t, 2, levenshtein("exmaple", "example"))
+	assert.Equal(t, 4, levenshtein("", "flag"))
+	assert.Equal(t, 3, levenshtein("kitten", "sitting"))
+}
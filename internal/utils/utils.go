@@ -1,5 +1,12 @@
 package utils
 
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+)
+
 func Dedupe(s []string) []string {
 	if len(s) <= 1 {
 		return s
@@ -21,3 +28,31 @@ func SafeString(s *string) string {
 	}
 	return ""
 }
+
+// Count of str, adding an "s" unless there is exactly one, e.g. "1 flag" or "2 flags"
+func Pluralize(str string, count int) string {
+	tmpl := "%d %s"
+	if count != 1 {
+		tmpl += "s"
+	}
+
+	return fmt.Sprintf(tmpl, count, str)
+}
+
+// Shorten s to at most length characters, without splitting multi-byte characters
+func Truncate(s string, length int) string {
+	if utf8.RuneCountInString(s) <= length {
+		return s
+	}
+	return string([]rune(s)[:length])
+}
+
+// Whether path, relative to the workspace, is inside dir. An empty dir contains every path.
+func InDir(path, dir string) bool {
+	dir = strings.Trim(filepath.ToSlash(filepath.Clean(dir)), "/")
+	if dir == "" || dir == "." {
+		return true
+	}
+	path = strings.TrimPrefix(filepath.ToSlash(filepath.Clean(path)), "./")
+	return path == dir || strings.HasPrefix(path, dir+"/")
+}
@@ -0,0 +1,179 @@
+package vcs
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/google/go-github/v68/github"
+
+	ghc "github.com/launchdarkly/find-code-references-in-pull-request/comments"
+	lcr "github.com/launchdarkly/find-code-references-in-pull-request/config"
+	e "github.com/launchdarkly/find-code-references-in-pull-request/errors"
+	"github.com/launchdarkly/find-code-references-in-pull-request/internal/authors"
+	"github.com/launchdarkly/find-code-references-in-pull-request/internal/checks"
+	"github.com/launchdarkly/find-code-references-in-pull-request/internal/events"
+	"github.com/launchdarkly/find-code-references-in-pull-request/internal/git"
+	gha "github.com/launchdarkly/find-code-references-in-pull-request/internal/github_actions"
+	"github.com/launchdarkly/find-code-references-in-pull-request/internal/httpclient"
+	"github.com/launchdarkly/find-code-references-in-pull-request/internal/scan"
+)
+
+// Pull requests and check runs on GitHub
+type GitHub struct {
+	config *lcr.Config
+	event  *events.Event
+}
+
+func NewGitHub(config *lcr.Config, event *events.Event) *GitHub {
+	return &GitHub{config: config, event: event}
+}
+
+func (g *GitHub) ChangeRequest() int {
+	if !g.event.IsPullRequest() {
+		return 0
+	}
+	return g.event.PullRequest.GetPullRequest().GetNumber()
+}
+
+func (g *GitHub) HeadSha() string {
+	return headSha(g.config, g.event)
+}
+
+// Get the diff of the event, from local git if refs are set and otherwise from the pull request API
+func (g *GitHub) Diff(ctx context.Context) (io.ReadCloser, error) {
+	config, event := g.config, g.event
+	if event.UseGitDiff() {
+		gha.Debug("Getting diff between %s and %s...", event.BaseRef, event.HeadRef)
+		return git.Diff(config.Workspace, event.BaseRef+"..."+event.HeadRef)
+	}
+
+	if !event.IsPullRequest() {
+		return nil, fmt.Errorf("unable to determine diff for %q event", event.Name)
+	}
+
+	gha.Debug("Getting pull request diff...")
+
+	var (
+		client   = config.GHClient
+		owner    = config.Owner
+		repo     = config.Repo
+		prNumber = g.ChangeRequest()
+	)
+
+	rawDiff, resp, err := getPullRequestDiff(ctx, client, owner, repo, prNumber)
+	if err != nil {
+		// TODO use this elsewhere
+		if resp != nil && resp.StatusCode == http.StatusUnauthorized {
+			return nil, e.UnauthorizedError
+		}
+
+		// For very large diffs, the github api will return a 406, when this
+		// happens, fallback to calling `git diff` directly
+		if resp != nil && resp.StatusCode == http.StatusNotAcceptable {
+			gha.Debug("Diff too large, fallback to traditional git command")
+			pr, _, err := client.PullRequests.Get(ctx, owner, repo, prNumber)
+			if err != nil {
+				return nil, err
+			}
+
+			headSha := pr.GetHead().GetSHA()
+
+			commitsComparison, _, err := client.Repositories.CompareCommits(ctx, owner, repo, headSha, pr.GetBase().GetSHA(), nil)
+			if err != nil {
+				return nil, err
+			}
+
+			mergeBaseSha := commitsComparison.GetMergeBaseCommit().GetSHA()
+			return git.Diff(config.Workspace, mergeBaseSha, headSha)
+		}
+
+		return nil, err
+	}
+
+	return rawDiff, nil
+}
+
+// Request the pull request diff, returning the response body unread so it can be
+// scanned as it is received
+func getPullRequestDiff(ctx context.Context, client *github.Client, owner, repo string, prNumber int) (io.ReadCloser, *github.Response, error) {
+	req, err := client.NewRequest(http.MethodGet, fmt.Sprintf("repos/%v/%v/pulls/%d", owner, repo, prNumber), nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	req.Header.Set("Accept", "application/vnd.github.v3.diff")
+
+	resp, err := client.BareDo(httpclient.WithStreamingBody(ctx), req)
+	if err != nil {
+		return nil, resp, err
+	}
+	return resp.Body, resp, nil
+}
+
+// Find the comment previously posted on the pull request for the configured instance id.
+// Only comments written by github-actions[bot] or by the user the token belongs to are
+// considered, so comments quoting the action's comment are ignored. If more than one is found,
+// the oldest is returned and the rest are deleted.
+func (g *GitHub) FindComment(ctx context.Context) (*Comment, error) {
+	config := g.config
+	opts := &github.IssueListCommentsOptions{
+		ListOptions: github.ListOptions{PerPage: 100},
+	}
+
+	author := authors.NewMatcher(ctx, config)
+	found := make([]*github.IssueComment, 0, 1)
+	for {
+		page, resp, err := config.GHClient.Issues.ListComments(ctx, config.Owner, config.Repo, g.ChangeRequest(), opts)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, c := range page {
+			if ghc.IsFlagComment(c.GetBody(), config.InstanceID) && author.Matches(c.GetUser()) {
+				found = append(found, c)
+			}
+		}
+
+		if resp.NextPage == 0 {
+			break
+		}
+		opts.Page = resp.NextPage
+	}
+
+	if len(found) == 0 {
+		return nil, nil
+	}
+
+	// comments are listed oldest first
+	for _, duplicate := range found[1:] {
+		gha.Log("Deleting duplicate comment %d", duplicate.GetID())
+		if err := g.DeleteComment(ctx, duplicate.GetID()); err != nil {
+			gha.SetWarning("Failed to delete duplicate comment")
+			gha.LogError(err)
+		}
+	}
+	return &Comment{ID: found[0].GetID(), Body: found[0].GetBody()}, nil
+}
+
+func (g *GitHub) CreateComment(ctx context.Context, body string) error {
+	comment := &github.IssueComment{Body: github.Ptr(body)}
+	_, _, err := g.config.GHClient.Issues.CreateComment(ctx, g.config.Owner, g.config.Repo, g.ChangeRequest(), comment)
+	return err
+}
+
+func (g *GitHub) UpdateComment(ctx context.Context, id int64, body string) error {
+	comment := &github.IssueComment{Body: github.Ptr(body)}
+	_, _, err := g.config.GHClient.Issues.EditComment(ctx, g.config.Owner, g.config.Repo, id, comment)
+	return err
+}
+
+func (g *GitHub) DeleteComment(ctx context.Context, id int64) error {
+	_, err := g.config.GHClient.Issues.DeleteComment(ctx, g.config.Owner, g.config.Repo, id)
+	return err
+}
+
+// Create a check run with an annotation for each reference
+func (g *GitHub) PostStatus(ctx context.Context, projects []scan.Project, summary string) error {
+	return checks.CreateCheckRun(ctx, g.config, g.config.HeadSha, projects, summary)
+}
@@ -0,0 +1,149 @@
+package vcs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/google/go-github/v68/github"
+	lcr "github.com/launchdarkly/find-code-references-in-pull-request/config"
+	"github.com/launchdarkly/find-code-references-in-pull-request/internal/events"
+	refs "github.com/launchdarkly/find-code-references-in-pull-request/internal/references"
+	"github.com/launchdarkly/find-code-references-in-pull-request/internal/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Minimal stand-in for the GitHub issue comments API, serving comments on PR 1 one per page
+type githubServer struct {
+	comments []*github.IssueComment
+	login    string // user the token belongs to, empty for app tokens
+	created  []string
+	deleted  []string
+}
+
+func (s *githubServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/repos/owner/repo/issues/1/comments":
+		page := 1
+		_, _ = fmt.Sscan(r.URL.Query().Get("page"), &page)
+		if page < len(s.comments) {
+			w.Header().Set("Link", fmt.Sprintf(`<%s?page=%d>; rel="next"`, r.URL.Path, page+1))
+		}
+		_ = json.NewEncoder(w).Encode(s.comments[page-1 : page])
+	case r.Method == http.MethodGet && r.URL.Path == "/user":
+		if s.login == "" {
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = w.Write([]byte(`{"message": "Resource not accessible by integration"}`))
+			return
+		}
+		_ = json.NewEncoder(w).Encode(github.User{Login: github.Ptr(s.login)})
+	case r.Method == http.MethodPost && r.URL.Path == "/repos/owner/repo/issues/1/comments":
+		var comment github.IssueComment
+		_ = json.NewDecoder(r.Body).Decode(&comment)
+		s.created = append(s.created, comment.GetBody())
+		_, _ = w.Write([]byte(`{"id": 100}`))
+	case r.Method == http.MethodDelete && strings.HasPrefix(r.URL.Path, "/repos/owner/repo/issues/comments/"):
+		s.deleted = append(s.deleted, strings.TrimPrefix(r.URL.Path, "/repos/owner/repo/issues/comments/"))
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// Provider for PR 1
+func newTestGitHub(config *lcr.Config) *GitHub {
+	event := &events.Event{PullRequest: &github.PullRequestEvent{PullRequest: &github.PullRequest{Number: github.Ptr(1)}}}
+	return NewGitHub(config, event)
+}
+
+func comment(id int64, login, userType, body string) *github.IssueComment {
+	return &github.IssueComment{
+		ID:   github.Ptr(id),
+		Body: github.Ptr(body),
+		User: &github.User{Login: github.Ptr(login), Type: github.Ptr(userType)},
+	}
+}
+
+func TestGitHub_FindComment(t *testing.T) {
+	stub := &githubServer{
+		comments: []*github.IssueComment{
+			comment(1, "octocat", "User", "> ## LaunchDarkly flag references\n> why is this flag here?"),
+			comment(2, "github-actions[bot]", "Bot", "## LaunchDarkly flag references\n <!-- comment hash: abc -->"),
+			comment(3, "github-actions[bot]", "Bot", "## LaunchDarkly flag references\n<!-- launchdarkly-flag-references:default -->"),
+			comment(4, "github-actions[bot]", "Bot", "## LaunchDarkly flag references\n<!-- launchdarkly-flag-references:web -->"),
+			comment(5, "dependabot[bot]", "Bot", "## LaunchDarkly flag references\n<!-- launchdarkly-flag-references:default -->"),
+		},
+	}
+	config := testutil.GitHubConfig(t, stub)
+	config.InstanceID = "default"
+
+	// the oldest comment is kept, ignoring the quote, the comment by another bot and the
+	// comment for another instance
+	found, err := newTestGitHub(config).FindComment(context.Background())
+	require.NoError(t, err)
+	require.NotNil(t, found)
+	assert.Equal(t, int64(2), found.ID)
+	assert.Equal(t, []string{"3"}, stub.deleted)
+
+	// unmarked comments only belong to the default instance
+	stub.deleted = nil
+	config.InstanceID = "web"
+	found, err = newTestGitHub(config).FindComment(context.Background())
+	require.NoError(t, err)
+	require.NotNil(t, found)
+	assert.Equal(t, int64(4), found.ID)
+	assert.Empty(t, stub.deleted)
+
+	config.InstanceID = "mobile"
+	found, err = newTestGitHub(config).FindComment(context.Background())
+	require.NoError(t, err)
+	assert.Nil(t, found)
+}
+
+func TestGitHub_FindComment_tokenUser(t *testing.T) {
+	stub := &githubServer{
+		comments: []*github.IssueComment{
+			comment(1, "octocat", "User", "<!-- launchdarkly-flag-references:default -->"),
+			comment(2, "ci-user", "User", "<!-- launchdarkly-flag-references:default -->"),
+		},
+	}
+	config := testutil.GitHubConfig(t, stub)
+	config.InstanceID = "default"
+
+	// comments by users aren't trusted when the token's user can't be found
+	found, err := newTestGitHub(config).FindComment(context.Background())
+	require.NoError(t, err)
+	assert.Nil(t, found)
+
+	stub.login = "ci-user"
+	found, err = newTestGitHub(config).FindComment(context.Background())
+	require.NoError(t, err)
+	require.NotNil(t, found)
+	assert.Equal(t, int64(2), found.ID)
+	assert.Empty(t, stub.deleted)
+}
+
+func TestPostComment_marksComment(t *testing.T) {
+	stub := &githubServer{}
+	config := testutil.GitHubConfig(t, stub)
+	config.InstanceID = "web"
+	config.PlaceholderComment = true
+
+	provider := newTestGitHub(config)
+
+	flagsRef := refs.ReferenceSummary{FlagsAdded: refs.FlagAliasMap{"example-flag": {}}}
+	err := PostComment(context.Background(), provider, config, flagsRef, nil, "## LaunchDarkly flag references")
+	require.NoError(t, err)
+
+	err = PostComment(context.Background(), provider, config, refs.ReferenceSummary{}, nil, "")
+	require.NoError(t, err)
+
+	require.Len(t, stub.created, 2)
+	assert.Equal(t, "## LaunchDarkly flag references\n<!-- launchdarkly-flag-references:web -->", stub.created[0])
+	assert.Contains(t, stub.created[1], "No flag references found in PR")
+	assert.True(t, strings.HasSuffix(stub.created[1], "<!-- launchdarkly-flag-references:web -->"))
+}
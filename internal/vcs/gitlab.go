@@ -0,0 +1,335 @@
+package vcs
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	ghc "github.com/launchdarkly/find-code-references-in-pull-request/comments"
+	lcr "github.com/launchdarkly/find-code-references-in-pull-request/config"
+	e "github.com/launchdarkly/find-code-references-in-pull-request/errors"
+	"github.com/launchdarkly/find-code-references-in-pull-request/internal/events"
+	"github.com/launchdarkly/find-code-references-in-pull-request/internal/git"
+	gha "github.com/launchdarkly/find-code-references-in-pull-request/internal/github_actions"
+	refs "github.com/launchdarkly/find-code-references-in-pull-request/internal/references"
+	"github.com/launchdarkly/find-code-references-in-pull-request/internal/scan"
+	"github.com/launchdarkly/find-code-references-in-pull-request/internal/utils"
+	"github.com/launchdarkly/find-code-references-in-pull-request/internal/version"
+)
+
+// Name of the commit status, matching the GitHub check run
+const statusName = "LaunchDarkly flag references"
+
+// GitLab limits the commit status description to 255 characters
+const maxStatusDescriptionLength = 255
+
+// Merge request notes and commit statuses on GitLab, using the REST API
+type GitLab struct {
+	config *lcr.Config
+	event  *events.Event
+	userID int64 // user the token belongs to, 0 until looked up
+}
+
+func NewGitLab(config *lcr.Config, event *events.Event) *GitLab {
+	return &GitLab{config: config, event: event}
+}
+
+func (g *GitLab) ChangeRequest() int {
+	if g.event.MergeRequest == nil {
+		return 0
+	}
+	return g.event.MergeRequest.IID
+}
+
+func (g *GitLab) HeadSha() string {
+	return headSha(g.config, g.event)
+}
+
+// A file changed in a merge request
+type gitLabDiff struct {
+	OldPath     string `json:"old_path"`
+	NewPath     string `json:"new_path"`
+	NewFile     bool   `json:"new_file"`
+	DeletedFile bool   `json:"deleted_file"`
+	Diff        string `json:"diff"`
+}
+
+// Get the diff of the event, from local git if refs are set and otherwise from the merge
+// request API. Each page of files is written as a unified diff as it is received.
+func (g *GitLab) Diff(ctx context.Context) (io.ReadCloser, error) {
+	event := g.event
+	if event.UseGitDiff() {
+		gha.Debug("Getting diff between %s and %s...", event.BaseRef, event.HeadRef)
+		return git.Diff(g.config.Workspace, event.BaseRef+"..."+event.HeadRef)
+	}
+
+	if event.MergeRequest == nil {
+		return nil, fmt.Errorf("unable to determine diff for %q pipeline", event.Name)
+	}
+
+	gha.Debug("Getting merge request diff...")
+
+	// fetch the first page before returning, so errors such as a bad token fail fast
+	var files []gitLabDiff
+	next, err := g.get(ctx, g.mergeRequestPath("diffs"), pageQuery("1"), &files)
+	if err != nil {
+		return nil, err
+	}
+
+	r, w := io.Pipe()
+	go func() {
+		for {
+			for _, f := range files {
+				if err := writeUnifiedDiff(w, f); err != nil {
+					w.CloseWithError(err)
+					return
+				}
+			}
+			if next == "" {
+				w.Close()
+				return
+			}
+			files = nil
+			if next, err = g.get(ctx, g.mergeRequestPath("diffs"), pageQuery(next), &files); err != nil {
+				w.CloseWithError(err)
+				return
+			}
+		}
+	}()
+	return r, nil
+}
+
+// Write a file's changes in the format of `git diff`
+func writeUnifiedDiff(w io.Writer, f gitLabDiff) error {
+	if f.Diff == "" {
+		// renames, mode changes, and files too large for the API to return
+		gha.Debug("No changes returned for %s", f.NewPath)
+		return nil
+	}
+
+	oldName, newName := "a/"+f.OldPath, "b/"+f.NewPath
+	if f.NewFile {
+		oldName = "/dev/null"
+	}
+	if f.DeletedFile {
+		newName = "/dev/null"
+	}
+
+	diff := f.Diff
+	if diff[len(diff)-1] != '\n' {
+		diff += "\n"
+	}
+	_, err := fmt.Fprintf(w, "diff --git a/%s b/%s\n--- %s\n+++ %s\n%s", f.OldPath, f.NewPath, oldName, newName, diff)
+	return err
+}
+
+// A merge request note
+type gitLabNote struct {
+	ID     int64  `json:"id"`
+	Body   string `json:"body"`
+	System bool   `json:"system"`
+	Author struct {
+		ID int64 `json:"id"`
+	} `json:"author"`
+}
+
+// Find the note previously posted on the merge request for the configured instance id.
+// Only notes written by the user the token belongs to are considered. If more than one is
+// found, the oldest is returned and the rest are deleted.
+func (g *GitLab) FindComment(ctx context.Context) (*Comment, error) {
+	userID, err := g.tokenUserID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	found := make([]gitLabNote, 0, 1)
+	query := url.Values{"sort": {"asc"}, "order_by": {"created_at"}, "per_page": {"100"}}
+	for page := "1"; page != ""; {
+		query.Set("page", page)
+		var notes []gitLabNote
+		if page, err = g.get(ctx, g.mergeRequestPath("notes"), query, &notes); err != nil {
+			return nil, err
+		}
+
+		for _, n := range notes {
+			if !n.System && n.Author.ID == userID && ghc.IsFlagComment(n.Body, g.config.InstanceID) {
+				found = append(found, n)
+			}
+		}
+	}
+
+	if len(found) == 0 {
+		return nil, nil
+	}
+
+	for _, duplicate := range found[1:] {
+		gha.Log("Deleting duplicate note %d", duplicate.ID)
+		if err := g.DeleteComment(ctx, duplicate.ID); err != nil {
+			gha.SetWarning("Failed to delete duplicate note")
+			gha.LogError(err)
+		}
+	}
+	return &Comment{ID: found[0].ID, Body: found[0].Body}, nil
+}
+
+// Look up the user the token belongs to, which for project and group access tokens is a bot user
+func (g *GitLab) tokenUserID(ctx context.Context) (int64, error) {
+	if g.userID == 0 {
+		var user struct {
+			ID int64 `json:"id"`
+		}
+		if _, err := g.get(ctx, "/user", nil, &user); err != nil {
+			return 0, err
+		}
+		g.userID = user.ID
+	}
+	return g.userID, nil
+}
+
+func (g *GitLab) CreateComment(ctx context.Context, body string) error {
+	return g.send(ctx, http.MethodPost, g.mergeRequestPath("notes"), map[string]string{"body": body})
+}
+
+func (g *GitLab) UpdateComment(ctx context.Context, id int64, body string) error {
+	return g.send(ctx, http.MethodPut, g.mergeRequestPath("notes/"+strconv.FormatInt(id, 10)), map[string]string{"body": body})
+}
+
+func (g *GitLab) DeleteComment(ctx context.Context, id int64) error {
+	return g.send(ctx, http.MethodDelete, g.mergeRequestPath("notes/"+strconv.FormatInt(id, 10)), nil)
+}
+
+// Set a commit status on the head commit. `check-run-conclusion` maps to a failed status for
+// failure and action_required, and to a successful status otherwise.
+func (g *GitLab) PostStatus(ctx context.Context, projects []scan.Project, summary string) error {
+	sha := g.config.HeadSha
+	if sha == "" {
+		gha.Debug("No head commit found in pipeline")
+		return nil
+	}
+
+	flagsRef := scan.MergeReferences(projects)
+	state := "success"
+	if flagsRef.AnyFound() {
+		switch g.config.CheckRunConclusion {
+		case "failure", "action_required":
+			state = "failed"
+		}
+	}
+
+	status := map[string]string{
+		"state":       state,
+		"name":        statusName,
+		"description": utils.Truncate(statusDescription(flagsRef), maxStatusDescriptionLength),
+	}
+	if g.config.RunURL != "" {
+		status["target_url"] = g.config.RunURL
+	}
+
+	path := fmt.Sprintf("/projects/%s/statuses/%s", url.PathEscape(g.config.GitLabProjectID), url.PathEscape(sha))
+	if err := g.send(ctx, http.MethodPost, path, status); err != nil {
+		return err
+	}
+	gha.Log("Set commit status %s on %s", state, sha)
+	return nil
+}
+
+func (g *GitLab) mergeRequestPath(resource string) string {
+	mr := g.event.MergeRequest
+	return fmt.Sprintf("/projects/%s/merge_requests/%d/%s", url.PathEscape(mr.ProjectID), mr.IID, resource)
+}
+
+func pageQuery(page string) url.Values {
+	return url.Values{"page": {page}, "per_page": {"100"}}
+}
+
+// Send a GET request, decoding the response into v. Returns the next page, empty on the last page.
+func (g *GitLab) get(ctx context.Context, path string, query url.Values, v any) (string, error) {
+	resp, err := g.do(ctx, http.MethodGet, path, query, nil)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return "", fmt.Errorf("decoding GitLab response for %s: %w", path, err)
+	}
+	return resp.Header.Get("X-Next-Page"), nil
+}
+
+// Send a request with a JSON body, discarding the response
+func (g *GitLab) send(ctx context.Context, method, path string, body any) error {
+	resp, err := g.do(ctx, method, path, nil, body)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (g *GitLab) do(ctx context.Context, method, path string, query url.Values, body any) (*http.Response, error) {
+	endpoint := g.config.GitLabAPIURL + path
+	if len(query) > 0 {
+		endpoint += "?" + query.Encode()
+	}
+
+	var reqBody io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reqBody = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, reqBody)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("PRIVATE-TOKEN", g.config.GitLabToken)
+	req.Header.Set("User-Agent", fmt.Sprintf("find-code-references-pr/%s", version.Version))
+
+	client := g.config.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		if resp.StatusCode == http.StatusUnauthorized {
+			return nil, e.GitLabUnauthorizedError
+		}
+		return nil, fmt.Errorf("GitLab API %s %s failed with %s: %s", method, path, resp.Status, bytes.TrimSpace(message))
+	}
+	return resp, nil
+}
+
+// Description of the commit status, which GitLab shows after the status name, counting the
+// flags referenced
+func statusDescription(flagsRef refs.ReferenceSummary) string {
+	if !flagsRef.AnyFound() {
+		return "None found"
+	}
+
+	parts := make([]string, 0, 2)
+	if n := len(flagsRef.FlagsAdded); n > 0 {
+		parts = append(parts, fmt.Sprintf("%d added or modified", n))
+	}
+	if n := len(flagsRef.FlagsRemoved); n > 0 {
+		parts = append(parts, fmt.Sprintf("%d removed", n))
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,255 @@
+package vcs
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	lcr "github.com/launchdarkly/find-code-references-in-pull-request/config"
+	ldiff "github.com/launchdarkly/find-code-references-in-pull-request/diff"
+	e "github.com/launchdarkly/find-code-references-in-pull-request/errors"
+	"github.com/launchdarkly/find-code-references-in-pull-request/internal/events"
+	refs "github.com/launchdarkly/find-code-references-in-pull-request/internal/references"
+	"github.com/launchdarkly/find-code-references-in-pull-request/internal/scan"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Minimal stand-in for the GitLab REST API, serving merge request 12 of project 5 one
+// page at a time. The token belongs to user 42.
+type gitlabServer struct {
+	mu       sync.Mutex
+	diffs    [][]gitLabDiff
+	notes    []gitLabNote
+	statuses []map[string]string
+	nextID   int64
+}
+
+func (s *gitlabServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if r.Header.Get("PRIVATE-TOKEN") != "gitlab-token" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+	path, isNote := strings.CutPrefix(r.URL.Path, "/api/v4/projects/5/merge_requests/12/notes/")
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/api/v4/user":
+		_, _ = w.Write([]byte(`{"id": 42, "username": "project_5_bot"}`))
+	case r.Method == http.MethodGet && r.URL.Path == "/api/v4/projects/5/merge_requests/12/diffs":
+		if page < len(s.diffs) {
+			w.Header().Set("X-Next-Page", strconv.Itoa(page+1))
+		}
+		_ = json.NewEncoder(w).Encode(s.diffs[page-1])
+	case r.Method == http.MethodGet && r.URL.Path == "/api/v4/projects/5/merge_requests/12/notes":
+		if page < len(s.notes) {
+			w.Header().Set("X-Next-Page", strconv.Itoa(page+1))
+		}
+		_ = json.NewEncoder(w).Encode(s.notes[page-1 : page])
+	case r.Method == http.MethodPost && r.URL.Path == "/api/v4/projects/5/merge_requests/12/notes":
+		var note gitLabNote
+		_ = json.NewDecoder(r.Body).Decode(&note)
+		s.nextID++
+		note.ID = s.nextID
+		note.Author.ID = 42
+		s.notes = append(s.notes, note)
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(note)
+	case r.Method == http.MethodPut && isNote:
+		var update gitLabNote
+		_ = json.NewDecoder(r.Body).Decode(&update)
+		if i := s.note(path); i >= 0 {
+			s.notes[i].Body = update.Body
+			_ = json.NewEncoder(w).Encode(s.notes[i])
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+	case r.Method == http.MethodDelete && isNote:
+		if i := s.note(path); i >= 0 {
+			s.notes = append(s.notes[:i], s.notes[i+1:]...)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+	case r.Method == http.MethodPost && strings.HasPrefix(r.URL.Path, "/api/v4/projects/7/statuses/"):
+		var status map[string]string
+		_ = json.NewDecoder(r.Body).Decode(&status)
+		status["sha"] = strings.TrimPrefix(r.URL.Path, "/api/v4/projects/7/statuses/")
+		s.statuses = append(s.statuses, status)
+		w.WriteHeader(http.StatusCreated)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// index of the note with the id, -1 if there is none
+func (s *gitlabServer) note(id string) int {
+	for i, n := range s.notes {
+		if strconv.FormatInt(n.ID, 10) == id {
+			return i
+		}
+	}
+	return -1
+}
+
+func newTestGitLab(serverURL string) *GitLab {
+	config := &lcr.Config{
+		Provider:        lcr.ProviderGitLab,
+		GitLabAPIURL:    serverURL + "/api/v4",
+		GitLabProjectID: "7",
+		GitLabToken:     "gitlab-token",
+		InstanceID:      "default",
+		HeadSha:         "abc123",
+	}
+	event := &events.Event{
+		Name:         "merge_request_event",
+		MergeRequest: &events.MergeRequest{IID: 12, ProjectID: "5", HeadSha: "abc123"},
+	}
+	return NewGitLab(config, event)
+}
+
+func note(id, authorID int64, body string) gitLabNote {
+	n := gitLabNote{ID: id, Body: body}
+	n.Author.ID = authorID
+	return n
+}
+
+func TestGitLab_Diff(t *testing.T) {
+	stub := &gitlabServer{
+		diffs: [][]gitLabDiff{
+			{
+				{OldPath: "app.go", NewPath: "app.go", Diff: "@@ -1,2 +1,2 @@\n package main\n-var a = \"old-flag\"\n+var a = \"new-flag\"\n"},
+				{OldPath: "flags.go", NewPath: "flags.go", NewFile: true, Diff: "@@ -0,0 +1 @@\n+var b = \"new-flag\""},
+			},
+			{
+				{OldPath: "old.go", NewPath: "old.go", DeletedFile: true, Diff: "@@ -1 +0,0 @@\n-var c = \"old-flag\"\n"},
+				// renamed without changes
+				{OldPath: "a.go", NewPath: "b.go"},
+			},
+		},
+	}
+	server := httptest.NewServer(stub)
+	defer server.Close()
+
+	rawDiff, err := newTestGitLab(server.URL).Diff(context.Background())
+	require.NoError(t, err)
+	b, err := io.ReadAll(rawDiff)
+	require.NoError(t, err)
+	require.NoError(t, rawDiff.Close())
+
+	expected := "diff --git a/app.go b/app.go\n--- a/app.go\n+++ b/app.go\n@@ -1,2 +1,2 @@\n package main\n-var a = \"old-flag\"\n+var a = \"new-flag\"\n" +
+		"diff --git a/flags.go b/flags.go\n--- /dev/null\n+++ b/flags.go\n@@ -0,0 +1 @@\n+var b = \"new-flag\"\n" +
+		"diff --git a/old.go b/old.go\n--- a/old.go\n+++ /dev/null\n@@ -1 +0,0 @@\n-var c = \"old-flag\"\n"
+	assert.Equal(t, expected, string(b))
+
+	// the diff is read the same as a GitHub diff
+	workspace := t.TempDir()
+	for _, path := range []string{"app.go", "flags.go"} {
+		require.NoError(t, os.WriteFile(filepath.Join(workspace, path), nil, 0o644))
+	}
+	var paths []string
+	err = ldiff.ReadDiffs(strings.NewReader(expected), workspace, 1, func(_ string, file *ldiff.DiffFile) {
+		paths = append(paths, file.Path)
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"app.go", "flags.go", "old.go"}, paths)
+}
+
+func TestGitLab_Diff_unauthorized(t *testing.T) {
+	server := httptest.NewServer(&gitlabServer{})
+	defer server.Close()
+
+	provider := newTestGitLab(server.URL)
+	provider.config.GitLabToken = ""
+	_, err := provider.Diff(context.Background())
+	assert.ErrorIs(t, err, e.GitLabUnauthorizedError)
+}
+
+func TestGitLab_FindComment(t *testing.T) {
+	stub := &gitlabServer{
+		notes: []gitLabNote{
+			note(1, 7, "> ## LaunchDarkly flag references\n> why is this flag here?"),
+			{ID: 2, Body: "added 1 commit", System: true},
+			note(3, 42, "## LaunchDarkly flag references\n<!-- launchdarkly-flag-references:default -->"),
+			note(4, 42, "## LaunchDarkly flag references\n<!-- launchdarkly-flag-references:web -->"),
+			note(5, 42, "## LaunchDarkly flag references\n<!-- launchdarkly-flag-references:default -->"),
+		},
+	}
+	server := httptest.NewServer(stub)
+	defer server.Close()
+	provider := newTestGitLab(server.URL)
+
+	// the oldest note is kept, ignoring notes by other users and for other instances
+	found, err := provider.FindComment(context.Background())
+	require.NoError(t, err)
+	require.NotNil(t, found)
+	assert.Equal(t, int64(3), found.ID)
+	assert.Equal(t, -1, stub.note("5"))
+
+	provider.config.InstanceID = "mobile"
+	found, err = provider.FindComment(context.Background())
+	require.NoError(t, err)
+	assert.Nil(t, found)
+}
+
+func TestGitLab_PostComment(t *testing.T) {
+	stub := &gitlabServer{}
+	server := httptest.NewServer(stub)
+	defer server.Close()
+	provider := newTestGitLab(server.URL)
+	config := provider.config
+	ctx := context.Background()
+
+	flagsRef := refs.ReferenceSummary{FlagsAdded: refs.FlagAliasMap{"example-flag": {}}}
+	require.NoError(t, PostComment(ctx, provider, config, flagsRef, nil, "## LaunchDarkly flag references"))
+	existing, err := provider.FindComment(ctx)
+	require.NoError(t, err)
+	require.NotNil(t, existing)
+	assert.Equal(t, "## LaunchDarkly flag references\n<!-- launchdarkly-flag-references:default -->", existing.Body)
+
+	require.NoError(t, PostComment(ctx, provider, config, flagsRef, existing, "## LaunchDarkly flag references\nupdated"))
+	require.Len(t, stub.notes, 1)
+	assert.Contains(t, stub.notes[0].Body, "updated")
+
+	// the note is deleted when flags are no longer referenced
+	require.NoError(t, PostComment(ctx, provider, config, refs.ReferenceSummary{}, existing, ""))
+	assert.Empty(t, stub.notes)
+}
+
+func TestGitLab_PostStatus(t *testing.T) {
+	stub := &gitlabServer{}
+	server := httptest.NewServer(stub)
+	defer server.Close()
+	provider := newTestGitLab(server.URL)
+	provider.config.RunURL = "https://gitlab.com/org/repo/-/pipelines/1"
+
+	projects := []scan.Project{{References: refs.ReferenceSummary{FlagsAdded: refs.FlagAliasMap{"example-flag": {}}}}}
+	for _, conclusion := range []string{"neutral", "failure"} {
+		provider.config.CheckRunConclusion = conclusion
+		require.NoError(t, provider.PostStatus(context.Background(), projects, "summary"))
+	}
+	require.NoError(t, provider.PostStatus(context.Background(), []scan.Project{}, "summary"))
+
+	require.Len(t, stub.statuses, 3)
+	assert.Equal(t, map[string]string{
+		"sha":         "abc123",
+		"state":       "success",
+		"name":        "LaunchDarkly flag references",
+		"description": "1 added or modified",
+		"target_url":  "https://gitlab.com/org/repo/-/pipelines/1",
+	}, stub.statuses[0])
+	assert.Equal(t, "failed", stub.statuses[1]["state"])
+	assert.Equal(t, "success", stub.statuses[2]["state"])
+	assert.Equal(t, "None found", stub.statuses[2]["description"])
+}
@@ -0,0 +1,92 @@
+// Package vcs reads changes from and reports flag references to the code host the action
+// runs on, such as GitHub pull requests or GitLab merge requests.
+package vcs
+
+import (
+	"context"
+	"io"
+	"strings"
+
+	ghc "github.com/launchdarkly/find-code-references-in-pull-request/comments"
+	lcr "github.com/launchdarkly/find-code-references-in-pull-request/config"
+	"github.com/launchdarkly/find-code-references-in-pull-request/internal/events"
+	"github.com/launchdarkly/find-code-references-in-pull-request/internal/git"
+	refs "github.com/launchdarkly/find-code-references-in-pull-request/internal/references"
+	"github.com/launchdarkly/find-code-references-in-pull-request/internal/scan"
+)
+
+// A code host that changes are scanned on
+type Provider interface {
+	// Number of the pull or merge request, 0 if the change isn't one
+	ChangeRequest() int
+	// Head commit of the change, empty if unknown
+	HeadSha() string
+	// Diff of the change, to be read file by file. The caller must close it.
+	Diff(ctx context.Context) (io.ReadCloser, error)
+	// The comment previously posted on the change request for the configured instance id,
+	// nil if there is none. Duplicate comments are deleted.
+	FindComment(ctx context.Context) (*Comment, error)
+	CreateComment(ctx context.Context, body string) error
+	UpdateComment(ctx context.Context, id int64, body string) error
+	DeleteComment(ctx context.Context, id int64) error
+	// Report the flag references found on the head commit
+	PostStatus(ctx context.Context, projects []scan.Project, summary string) error
+}
+
+// A comment on a pull or merge request
+type Comment struct {
+	ID   int64
+	Body string
+}
+
+// Body of the comment, empty if the comment is nil
+func (c *Comment) GetBody() string {
+	if c == nil {
+		return ""
+	}
+	return c.Body
+}
+
+// Create, update or delete the comment on the change request. The comment is marked with
+// the configured instance id so it can be found by FindComment.
+func PostComment(ctx context.Context, provider Provider, config *lcr.Config, flagsRef refs.ReferenceSummary, existingComment *Comment, body string) error {
+	if flagsRef.AnyFound() {
+		body = ghc.WithCommentMarker(body, config.InstanceID)
+		if existingComment != nil {
+			return provider.UpdateComment(ctx, existingComment.ID, body)
+		}
+		return provider.CreateComment(ctx, body)
+	}
+
+	// Check if this is already the body, flags could have originally been included then removed in later commit
+	if existingComment != nil {
+		if config.PlaceholderComment {
+			if strings.Contains(existingComment.Body, "No flag references found in PR") {
+				return nil
+			}
+			return provider.UpdateComment(ctx, existingComment.ID, placeholderComment(config))
+		}
+		return provider.DeleteComment(ctx, existingComment.ID)
+	}
+
+	if config.PlaceholderComment {
+		return provider.CreateComment(ctx, placeholderComment(config))
+	}
+
+	return nil
+}
+
+func placeholderComment(config *lcr.Config) string {
+	return ghc.WithCommentMarker(ghc.GithubNoFlagComment().GetBody(), config.InstanceID)
+}
+
+// Resolve the head commit SHA of the event
+func headSha(config *lcr.Config, event *events.Event) string {
+	head := event.HeadSha()
+	if event.UseGitDiff() {
+		if sha, err := git.RevParse(config.Workspace, head); err == nil {
+			return sha
+		}
+	}
+	return head
+}
@@ -3,240 +3,239 @@ package main
 import (
 	"context"
 	"fmt"
-	"net/http"
 	"os"
-	"os/exec"
+	"os/signal"
 	"sort"
 	"strings"
+	"syscall"
+	"text/template"
 
 	"github.com/pkg/errors"
 
-	"github.com/google/go-github/v68/github"
 	ghc "github.com/launchdarkly/find-code-references-in-pull-request/comments"
 	lcr "github.com/launchdarkly/find-code-references-in-pull-request/config"
-	ldiff "github.com/launchdarkly/find-code-references-in-pull-request/diff"
-	e "github.com/launchdarkly/find-code-references-in-pull-request/errors"
-	"github.com/launchdarkly/find-code-references-in-pull-request/internal/extinctions"
+	"github.com/launchdarkly/find-code-references-in-pull-request/internal/events"
 	gha "github.com/launchdarkly/find-code-references-in-pull-request/internal/github_actions"
+	"github.com/launchdarkly/find-code-references-in-pull-request/internal/httpclient"
 	ldclient "github.com/launchdarkly/find-code-references-in-pull-request/internal/ldclient"
+	"github.com/launchdarkly/find-code-references-in-pull-request/internal/policies"
 	references "github.com/launchdarkly/find-code-references-in-pull-request/internal/references"
-	"github.com/launchdarkly/find-code-references-in-pull-request/search"
-	"github.com/launchdarkly/ld-find-code-refs/v2/options"
-	"github.com/sourcegraph/go-diff/diff"
-	"github.com/spf13/viper"
+	"github.com/launchdarkly/find-code-references-in-pull-request/internal/report"
+	"github.com/launchdarkly/find-code-references-in-pull-request/internal/reviews"
+	"github.com/launchdarkly/find-code-references-in-pull-request/internal/scan"
+	"github.com/launchdarkly/find-code-references-in-pull-request/internal/vcs"
+	"github.com/launchdarkly/find-code-references-in-pull-request/internal/version"
 )
 
 func main() {
-	ctx := context.Background()
+	// cancel in-flight requests when the workflow is cancelled
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
 	config, err := lcr.ValidateInputandParse(ctx)
 	failExit(err)
 
-	eventPath := os.Getenv("GITHUB_EVENT_PATH")
-	event, err := parseEvent(eventPath)
-	if err != nil {
-		err := errors.Wrap(err, fmt.Sprintf("error parsing GitHub event payload at %q", eventPath))
+	event, provider, err := newProvider(config)
+	failExit(err)
+
+	config.HeadSha = provider.HeadSha()
+	config.BaseSha = event.BaseSha()
+
+	// validate the comment template before scanning
+	var commentTemplate *template.Template
+	if config.CommentTemplate != "" {
+		commentTemplate, err = ghc.LoadTemplate(config.CommentTemplate)
 		failExit(err)
 	}
 
-	flags, err := ldclient.GetAllFlags(config)
+	opts, err := scan.GetOptions(config)
 	failExit(err)
 
-	if len(flags) == 0 {
-		gha.SetNotice("No flags found in project %s", config.LdProject)
+	projects := scan.Projects(config, opts)
+	for i := range projects {
+		projects[i].Flags, err = ldclient.GetAllFlags(ctx, projects[i].Config)
+		failExit(err)
+	}
+
+	if !scan.AnyFlags(projects) {
+		gha.SetNotice("No flags found in project %s", strings.Join(scan.ProjectKeys(projects), ", "))
 		os.Exit(0)
 	}
 
-	opts, err := getOptions(config)
+	rawDiff, err := provider.Diff(ctx)
 	failExit(err)
 
-	flagKeys := make([]string, 0, len(flags))
-	for _, flag := range flags {
-		flagKeys = append(flagKeys, flag.Key)
+	err = scan.ScanProjects(opts, projects, rawDiff)
+	if closeErr := rawDiff.Close(); err == nil {
+		err = closeErr
 	}
-
-	gha.StartLogGroup("Preprocessing diffs...")
-	multiFiles, err := getDiffs(ctx, config, *event.PullRequest.Number)
 	failExit(err)
+	flagsRef := scan.MergeReferences(projects)
 
-	diffMap := ldiff.PreprocessDiffs(opts.Dir, multiFiles)
-
-	matcher, err := search.GetMatcher(opts, flagKeys, diffMap)
-	gha.EndLogGroup()
-	failExit(err)
+	var violations []policies.Violation
+	for _, p := range projects {
+		violations = append(violations, policies.Evaluate(p.Config, p.References, p.Flags)...)
+	}
 
-	builder := references.NewReferenceSummaryBuilder(config.MaxFlags, config.CheckExtinctions)
-	gha.StartLogGroup("Scanning diff for references...")
-	gha.Log("Searching for %d flags", len(flagKeys))
-	for _, contents := range diffMap {
-		ldiff.ProcessDiffs(matcher, contents, builder)
+	// Set outputs
+	setOutputs(config, flagsRef)
+	warnUnknownFlags(flagsRef)
+	truncated := scan.Truncated(projects)
+	if truncated {
+		gha.SetNotice("Found more than %d flags, only the first %d are shown in the comment. Increase `max-flags` to show more.", config.MaxFlags, config.MaxFlags)
 	}
-	gha.EndLogGroup()
+	gha.SetOutput("truncated", fmt.Sprintf("%t", truncated))
 
-	if config.CheckExtinctions {
-		if err := extinctions.CheckExtinctions(opts, builder); err != nil {
-			gha.SetWarning("Error checking for extinct flags")
+	// max-flags only applies to the PR comment
+	summary := ghc.BuildProjectsFlagSummary(ghc.ProcessAllProjects(projects))
+
+	// Add job summary
+	gha.AppendStepSummary(summary)
+
+	// Write report
+	if config.ReportPath != "" {
+		gha.Debug("Writing %s report to %s...", config.ReportFormat, config.ReportPath)
+		if err := report.WriteFile(config.ReportPath, config.ReportFormat, report.Build(config, projects)); err != nil {
+			gha.SetWarning("Failed to write report")
 			gha.LogError(err)
+		} else {
+			gha.SetOutput("report-path", config.ReportPath)
 		}
 	}
 
-	gha.Log("Summarizing results")
-	flagsRef := builder.Build()
-
-	// Set outputs
-	setOutputs(config, flagsRef)
-
 	// Add comment
-	gha.StartLogGroup("Processing comment...")
-	existingComment := checkExistingComments(event, config, ctx)
-	buildComment := ghc.ProcessFlags(flagsRef, flags, config)
-	postedComments := ghc.BuildFlagComment(buildComment, flagsRef, existingComment)
-	if postedComments != "" {
-		comment := github.IssueComment{
-			Body: &postedComments,
+	var postedComments string
+	var existingComment *vcs.Comment
+	if config.PrComment && provider.ChangeRequest() > 0 {
+		gha.StartLogGroup("Processing comment...")
+		var findErr error
+		existingComment, findErr = provider.FindComment(ctx)
+		if findErr != nil {
+			gha.SetWarning("Failed to find existing comment")
+			gha.LogError(findErr)
 		}
-
-		err = postGithubComment(ctx, flagsRef, config, existingComment, *event.PullRequest.Number, comment)
+		if commentTemplate != nil {
+			data := ghc.NewTemplateData(projects, templateMetadata(config, provider))
+			postedComments, err = ghc.BuildTemplateComment(commentTemplate, data, existingComment.GetBody())
+			failExit(err)
+		} else {
+			postedComments = ghc.BuildProjectsFlagComment(ghc.ProcessProjects(projects), existingComment.GetBody())
+		}
+		if postedComments != "" {
+			err = vcs.PostComment(ctx, provider, config, flagsRef, existingComment, postedComments)
+		}
+		gha.EndLogGroup()
 	}
-	gha.EndLogGroup()
 
-	// Add flag links
-	if config.CreateFlagLinks && postedComments != "" {
-		// if postedComments is empty, we probably already created the flag links
-		gha.StartLogGroup("Adding flag links...")
-		ldclient.CreateFlagLinks(config, flagsRef, event)
+	// Add check run or commit status
+	if config.CheckRun {
+		gha.StartLogGroup("Reporting status...")
+		if err := provider.PostStatus(ctx, projects, summary); err != nil {
+			gha.SetWarning("Failed to report status")
+			gha.LogError(err)
+		}
 		gha.EndLogGroup()
 	}
 
-	failExit(err)
-}
-
-func checkExistingComments(event *github.PullRequestEvent, config *lcr.Config, ctx context.Context) *github.IssueComment {
-	comments, _, err := config.GHClient.Issues.ListComments(ctx, config.Owner, config.Repo, *event.PullRequest.Number, nil)
-	if err != nil {
-		gha.LogError(err)
+	// Add review comments
+	if config.ReviewComments && event.IsPullRequest() {
+		gha.StartLogGroup("Processing review comments...")
+		if err := reviews.PostReviewComments(ctx, config, event.PullRequest, projects); err != nil {
+			gha.SetWarning("Failed to add review comments")
+			gha.LogError(err)
+		}
+		gha.EndLogGroup()
 	}
 
-	for _, comment := range comments {
-		if strings.Contains(*comment.Body, "LaunchDarkly flag references") {
-			return comment
+	// Add flag links
+	if config.CreateFlagLinks && event.IsPullRequest() && (postedComments != "" || !config.PrComment || pullRequestStateChanged(event)) {
+		// if postedComments is empty, we probably already created the flag links
+		gha.StartLogGroup("Syncing flag links...")
+		previousKeys, marked := ghc.FlagKeysFromComment(existingComment.GetBody())
+		for _, p := range projects {
+			candidates := previousKeys
+			if !marked && (existingComment != nil || !config.PrComment) {
+				// an earlier run may have linked any of the flags
+				candidates = scan.FlagKeys(p.Flags)
+			}
+			ldclient.SyncFlagLinks(ctx, p.Config, p.References, event.PullRequest, staleFlagKeys(candidates, p))
 		}
+		gha.EndLogGroup()
 	}
 
-	return nil
+	failExit(err)
+	failPolicies(violations)
 }
 
-func postGithubComment(ctx context.Context, flagsRef references.ReferenceSummary, config *lcr.Config, existingComment *github.IssueComment, prNumber int, comment github.IssueComment) error {
-	var existingCommentId int64
-	if existingComment != nil {
-		existingCommentId = existingComment.GetID()
+// Whether the pull request was closed or reopened, so flag links need updating even if the
+// comment is unchanged
+func pullRequestStateChanged(event *events.Event) bool {
+	switch event.PullRequest.GetAction() {
+	case "closed", "reopened":
+		return true
 	}
+	return false
+}
 
-	if flagsRef.AnyFound() {
-		if existingCommentId > 0 {
-			_, _, err := config.GHClient.Issues.EditComment(ctx, config.Owner, config.Repo, existingCommentId, &comment)
-			return err
-		}
-
-		_, _, err := config.GHClient.Issues.CreateComment(ctx, config.Owner, config.Repo, prNumber, &comment)
-		return err
+// Flags of the project among candidates, flags an earlier run may have linked to the pull
+// request, that are no longer referenced. Candidates read from the existing comment include
+// flags of all projects, so keys of other projects are skipped.
+func staleFlagKeys(candidates []string, project scan.Project) []string {
+	projectKeys := make(map[string]struct{}, len(project.Flags))
+	for _, flag := range project.Flags {
+		projectKeys[flag.Key] = struct{}{}
 	}
 
-	// Check if this is already the body, flags could have originally been included then removed in later commit
-	if existingCommentId > 0 {
-		if config.PlaceholderComment {
-			if strings.Contains(*existingComment.Body, "No flag references found in PR") {
-				return nil
-			}
-
-			_, _, err := config.GHClient.Issues.EditComment(ctx, config.Owner, config.Repo, existingCommentId, ghc.GithubNoFlagComment())
-			return err
+	stale := make([]string, 0)
+	for _, key := range candidates {
+		_, inProject := projectKeys[key]
+		_, added := project.References.FlagsAdded[key]
+		_, removed := project.References.FlagsRemoved[key]
+		if inProject && !added && !removed {
+			stale = append(stale, key)
 		}
-
-		_, err := config.GHClient.Issues.DeleteComment(ctx, config.Owner, config.Repo, existingCommentId)
-		return err
 	}
-
-	if config.PlaceholderComment {
-		_, _, err := config.GHClient.Issues.CreateComment(ctx, config.Owner, config.Repo, prNumber, ghc.GithubNoFlagComment())
-		return err
-	}
-
-	return nil
+	return stale
 }
 
-func getDiffs(ctx context.Context, config *lcr.Config, prNumber int) ([]*diff.FileDiff, error) {
-	gha.Debug("Getting pull request diff...")
-
-	var (
-		client  = config.GHClient
-		owner   = config.Owner
-		repo    = config.Repo
-		rawOpts = github.RawOptions{Type: github.Diff}
-		rawDiff []byte
-	)
-
-	raw, resp, err := client.PullRequests.GetRaw(ctx, owner, repo, prNumber, rawOpts)
-	rawDiff = []byte(raw)
-	if err != nil {
-		// TODO use this elsewhere
-		if resp.StatusCode == http.StatusUnauthorized {
-			return nil, e.UnauthorizedError
-		}
-
-		// check that git is installed
-		if _, gitCmdErr := exec.Command("git", "-v").CombinedOutput(); gitCmdErr != nil {
-			return nil, e.NoGitError
-		}
-
-		// For very large diffs, the github api will return a 406, when this
-		// happens, fallback to calling `git diff` directly
-		if resp != nil && resp.StatusCode == http.StatusNotAcceptable {
-			gha.Debug("Diff too large, fallback to traditional git command")
-			pr, _, err := client.PullRequests.Get(ctx, owner, repo, prNumber)
-			if err != nil {
-				return nil, err
-			}
-
-			headSha := pr.GetHead().GetSHA()
-
-			commitsComparison, _, err := client.Repositories.CompareCommits(ctx, owner, repo, headSha, pr.GetBase().GetSHA(), nil)
-			if err != nil {
-				return nil, err
-			}
-
-			mergeBaseSha := commitsComparison.GetMergeBaseCommit().GetSHA()
-			rawDiff, err = exec.Command("git", "diff", "--find-renames", mergeBaseSha, headSha).CombinedOutput()
-			// git diff return exit status 1 if there is a diff, so that does
-			// not indicate an error
-			if err != nil && err.Error() != "exit status 1" {
-				return nil, fmt.Errorf("failed to run git diff: %w", err)
-			}
+// Parse the triggering event and set up the code host it came from
+func newProvider(config *lcr.Config) (*events.Event, vcs.Provider, error) {
+	if config.Provider == lcr.ProviderGitLab {
+		event, err := events.ParseGitLab(config.BaseRef, config.HeadRef)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "error reading GitLab CI variables")
 		}
+		return event, vcs.NewGitLab(config, event), nil
 	}
 
-	multi, err := diff.ParseMultiFileDiff(rawDiff)
+	eventPath := os.Getenv("GITHUB_EVENT_PATH")
+	event, err := events.Parse(os.Getenv("GITHUB_EVENT_NAME"), eventPath, config.BaseRef, config.HeadRef, os.Getenv("GITHUB_SHA"))
 	if err != nil {
-		return nil, err
+		return nil, nil, errors.Wrap(err, fmt.Sprintf("error parsing GitHub event payload at %q", eventPath))
 	}
-	gha.Debug("Got %d diff files", len(multi))
-
-	return multi, nil
+	return event, vcs.NewGitHub(config, event), nil
 }
 
-// Get options from config. Note: dir will be set to workspace
-func getOptions(config *lcr.Config) (options.Options, error) {
-	// Needed for ld-find-code-refs to work as a library
-	viper.Set("dir", config.Workspace)
-	viper.Set("accessToken", config.ApiToken)
-
-	if err := options.InitYAML(); err != nil {
-		gha.LogError(err)
+func templateMetadata(config *lcr.Config, provider vcs.Provider) ghc.Metadata {
+	return ghc.Metadata{
+		Owner:        config.Owner,
+		Repo:         config.Repo,
+		PullRequest:  provider.ChangeRequest(),
+		RepoURL:      config.RepoURL,
+		HeadSha:      config.HeadSha,
+		RunURL:       config.RunURL,
+		Provider:     config.Provider,
+		LdInstance:   config.LdInstance,
+		Projects:     config.LdProjects,
+		Environments: config.LdEnvironments,
+		Version:      version.Version,
 	}
-	return options.GetOptions()
 }
 
 func setOutputs(config *lcr.Config, flagsRef references.ReferenceSummary) {
 	gha.Debug("Setting outputs...")
+	// modified-flags keeps its original meaning of every flag with references added, including
+	// introduced and moved flags, unlike the modified section of the comment and report
 	flagsModified := flagsRef.AddedKeys()
 	setOutputsForChangedFlags("modified", flagsModified)
 
@@ -247,6 +246,15 @@ func setOutputs(config *lcr.Config, flagsRef references.ReferenceSummary) {
 		setOutputsForChangedFlags("extinct", flagsRef.ExtinctKeys())
 	}
 
+	if config.CheckIntroductions {
+		setOutputsForChangedFlags("introduced", flagsRef.IntroducedKeys())
+	}
+	setOutputsForChangedFlags("moved", flagsRef.MovedKeys())
+
+	if config.DetectUnknownFlags {
+		setOutputsForChangedFlags("unknown", flagsRef.UnknownKeys())
+	}
+
 	allChangedFlags := make([]string, 0, len(flagsModified)+len(flagsRemoved))
 	allChangedFlags = append(allChangedFlags, flagsModified...)
 	allChangedFlags = append(allChangedFlags, flagsRemoved...)
@@ -254,6 +262,16 @@ func setOutputs(config *lcr.Config, flagsRef references.ReferenceSummary) {
 	setOutputsForChangedFlags("changed", allChangedFlags)
 }
 
+func warnUnknownFlags(flagsRef references.ReferenceSummary) {
+	for _, unknown := range flagsRef.UnknownFlags {
+		message := fmt.Sprintf("Flag key %q evaluated at %s was not found in LaunchDarkly.", unknown.Key, unknown.Locations[0])
+		if len(unknown.Suggestions) > 0 {
+			message += fmt.Sprintf(" Did you mean %s?", strings.Join(unknown.Suggestions, ", "))
+		}
+		gha.SetWarning("%s", message)
+	}
+}
+
 func setOutputsForChangedFlags(modifier string, changedFlags []string) {
 	count := len(changedFlags)
 	gha.SetOutput(fmt.Sprintf("any-%s", modifier), fmt.Sprintf("%t", count > 0))
@@ -263,10 +281,24 @@ func setOutputsForChangedFlags(modifier string, changedFlags []string) {
 	gha.SetOutput(fmt.Sprintf("%s-flags", modifier), strings.Join(changedFlags, " "))
 }
 
+func failPolicies(violations []policies.Violation) {
+	if len(violations) == 0 {
+		return
+	}
+	for _, v := range violations {
+		gha.SetError("%s", v.String())
+	}
+	os.Exit(1)
+}
+
 func failExit(err error) {
 	if err != nil {
 		gha.LogError(err)
-		gha.SetError("%s", err.Error())
+		if message, ok := httpclient.RateLimitMessage(err); ok {
+			gha.SetError("%s", message)
+		} else {
+			gha.SetError("%s", err.Error())
+		}
 		os.Exit(1)
 	}
 }
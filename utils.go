@@ -1,29 +0,0 @@
-package main
-
-import (
-	"encoding/json"
-	"io"
-	"os"
-
-	"github.com/google/go-github/v68/github"
-)
-
-// pulled from ld-find-code-refs github action
-func parseEvent(path string) (*github.PullRequestEvent, error) {
-	/* #nosec */
-	eventJsonFile, err := os.Open(path)
-	if err != nil {
-		return nil, err
-	}
-
-	eventJsonBytes, err := io.ReadAll(eventJsonFile)
-	if err != nil {
-		return nil, err
-	}
-	var evt github.PullRequestEvent
-	err = json.Unmarshal(eventJsonBytes, &evt)
-	if err != nil {
-		return nil, err
-	}
-	return &evt, err
-}